@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -8,7 +9,9 @@ import (
 	"os"
 	"time"
 
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/cards"
 	"github.com/fajarcahyadiputra/udemy-web-application/internal/driver"
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/migrations"
 	"github.com/fajarcahyadiputra/udemy-web-application/internal/models"
 )
 
@@ -18,11 +21,13 @@ type config struct {
 	port int
 	env  string
 	db   struct {
-		dsn string
+		dsn             string
+		checkMigrations bool
 	}
 	stripe struct {
-		secret string
-		key    string
+		secret  string
+		key     string
+		webhook string
 	}
 	smtp struct {
 		host     string
@@ -30,8 +35,20 @@ type config struct {
 		username string
 		password string
 	}
-	secrectkey string
-	frontend   string
+	secrectkey  string
+	frontend    string
+	gateway     string
+	reservation time.Duration
+	dunning     struct {
+		schedule []time.Duration
+		interval time.Duration
+	}
+	settlementInterval time.Duration
+	usageInterval      time.Duration
+	trial              struct {
+		reminder time.Duration
+		interval time.Duration
+	}
 }
 type application struct {
 	config   config
@@ -39,6 +56,7 @@ type application struct {
 	errorLog *log.Logger
 	version  string
 	DB       models.DBModel
+	Gateway  cards.PaymentGateway
 }
 
 func (app *application) Serve() error {
@@ -60,26 +78,63 @@ func main() {
 	flag.IntVar(&cfg.port, "port", 4001, "Server Port To Listen On")
 	flag.StringVar(&cfg.env, "env", "development", "Application Environment {development|prodyction|testing}")
 	flag.StringVar(&cfg.db.dsn, "dsn", "root:12345678@tcp(localhost:3306)/learning_widgets?parseTime=true&tls=false", "DSN")
+	flag.BoolVar(&cfg.db.checkMigrations, "check-migrations", true, "Refuse to start when database migrations are pending or not tracked, false only logs it")
 	flag.StringVar(&cfg.smtp.host, "smtphost", "sandbox.smtp.mailtrap.io", "smpt host")
 	flag.IntVar(&cfg.smtp.port, "smtpport", 587, "smpt port")
 	flag.StringVar(&cfg.smtp.username, "smtpusername", "2e2238e526e2c7", "smpt username")
 	flag.StringVar(&cfg.smtp.password, "smtppassword", "4595f6612f03b7", "smpt password")
 	flag.StringVar(&cfg.secrectkey, "secrectkey", "jdu73tdjruplcjry36ahsyebncmxkipe", "secrect key")
 	flag.StringVar(&cfg.frontend, "frontend", "http://localhost:4000", "domain frontend")
+	flag.StringVar(&cfg.gateway, "gateway", "stripe", "Payment gateway {stripe|fake}")
+	flag.DurationVar(&cfg.reservation, "reservation", 15*time.Minute, "How long stock is held for an unpaid payment intent")
+	dunningSchedule := flag.String("dunning-schedule", "72h,120h,168h", "Waits between retries of a failed renewal, the subscription is cancelled after the last one")
+	flag.DurationVar(&cfg.dunning.interval, "dunning-interval", 15*time.Minute, "How often due renewal retries are made")
+	flag.DurationVar(&cfg.usageInterval, "usage-interval", 5*time.Minute, "How often recorded usage is reported to the gateway")
+	flag.DurationVar(&cfg.trial.reminder, "trial-reminder", 72*time.Hour, "How long before a trial ends the customer is reminded")
+	flag.DurationVar(&cfg.trial.interval, "trial-interval", time.Hour, "How often trials ending soon are looked for")
+	flag.DurationVar(&cfg.settlementInterval, "settlement-interval", 10*time.Minute, "How often gateway fees are fetched for new charges")
 
 	flag.Parse()
 
 	cfg.stripe.key = os.Getenv("STRIPE_KEY")
 	cfg.stripe.secret = os.Getenv("STRIPE_SECRET")
+	cfg.stripe.webhook = os.Getenv("STRIPE_WEBHOOK_SECRET")
 
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	errorfoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime|log.Lshortfile)
 
+	// the webhook signature is keyed with the secret, an empty or known one lets anyone sign events
+	if cfg.stripe.webhook == "" || cfg.stripe.webhook == webhookSecretPlaceholder {
+		errorfoLog.Fatal("STRIPE_WEBHOOK_SECRET must be set to the signing secret of the webhook endpoint")
+	}
+
+	schedule, err := parseDunningSchedule(*dunningSchedule)
+	if err != nil {
+		errorfoLog.Fatal(err)
+	}
+	cfg.dunning.schedule = schedule
+
 	con, err := driver.OpenDB(cfg.db.dsn)
 	if err != nil {
 		errorfoLog.Fatal(err)
 	}
 	defer con.Close()
+
+	if err = migrations.Check(con); err != nil {
+		if cfg.db.checkMigrations {
+			if errors.Is(err, migrations.ErrUntracked) {
+				errorfoLog.Fatalf("%v, run go run ./cmd/migrate baseline N with the version the schema is at, or up on an empty database", err)
+			}
+			errorfoLog.Fatalf("%v, run go run ./cmd/migrate up", err)
+		}
+		errorfoLog.Println(err)
+	}
+
+	gateway, err := cards.NewGateway(cfg.gateway, cfg.stripe.secret, cfg.stripe.key)
+	if err != nil {
+		errorfoLog.Fatal(err)
+	}
+
 	app := &application{
 		config:   cfg,
 		infoLog:  infoLog,
@@ -88,8 +143,19 @@ func main() {
 		DB: models.DBModel{
 			DB: con,
 		},
+		Gateway: gateway,
+	}
+
+	// the status tables are seeded by the migrations, a missing row breaks the foreign keys later on
+	if err = app.DB.CheckStatuses(); err != nil {
+		errorfoLog.Println(err)
 	}
 
+	go app.runDunning()
+	go app.runSettlements()
+	go app.runTrialReminders()
+	go app.runUsageReporting()
+
 	err = app.Serve()
 	if err != nil {
 		log.Fatal(err)
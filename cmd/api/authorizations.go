@@ -0,0 +1,135 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/models"
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/validator"
+)
+
+// authorizationRequest is the body of the capture and cancel actions, an amount of 0 captures all of it
+type authorizationRequest struct {
+	ID     int `json:"id"`
+	Amount int `json:"amount"`
+}
+
+// readAuthorization reads the request and loads the authorization it is for
+func (app *application) readAuthorization(w http.ResponseWriter, r *http.Request) (authorizationRequest, models.Transaction, bool) {
+	var req authorizationRequest
+	var txn models.Transaction
+
+	if err := app.readJSON(w, r, &req); err != nil {
+		app.badRequest(w, r, err)
+		return req, txn, false
+	}
+
+	txn, err := app.DB.GetTransaction(req.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.notFound(w, r, "authorization not found")
+			return req, txn, false
+		}
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return req, txn, false
+	}
+
+	return req, txn, true
+}
+
+// AllAuthorizations returns the amounts held on cards waiting to be captured
+func (app *application) AllAuthorizations(w http.ResponseWriter, r *http.Request) {
+	txns, err := app.DB.GetPendingAuthorizations()
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, txns)
+}
+
+// CaptureAuthorization takes all or part of an authorized amount, the rest is released
+func (app *application) CaptureAuthorization(w http.ResponseWriter, r *http.Request) {
+	req, txn, ok := app.readAuthorization(w, r)
+	if !ok {
+		return
+	}
+
+	amount := req.Amount
+	if amount == 0 {
+		amount = txn.AuthorizedAmount
+	}
+
+	v := validator.New()
+	v.Check(txn.TransactionStatusID == models.TransactionAuthorized, "id", "transaction is not an open authorization")
+	v.Check(txn.AuthorizationExpiresAt == nil || time.Now().Before(*txn.AuthorizationExpiresAt), "id", "authorization has expired")
+	v.Check(amount > 0, "amount", "must be greater than zero")
+	v.Check(amount <= txn.AuthorizedAmount, "amount", fmt.Sprintf("must not be more than the authorized amount of %d", txn.AuthorizedAmount))
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	pi, err := app.Gateway.CapturePaymentIntent(txn.PaymentIntent, amount, idempotencyKey(r, "capture"))
+	if err != nil {
+		perr := app.paymentError(err)
+		app.badRequest(w, r, errors.New(perr.Message))
+		return
+	}
+
+	err = app.DB.CaptureAuthorization(txn.ID, int(pi.AmountReceived))
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, errors.New("the amount was captured, but the database could not be updated"))
+		return
+	}
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+		Amount  int    `json:"amount"`
+	}
+	resp.Error = false
+	resp.Message = "Authorization captured"
+	resp.Amount = int(pi.AmountReceived)
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// CancelAuthorization voids an authorization and releases the amount held on the card
+func (app *application) CancelAuthorization(w http.ResponseWriter, r *http.Request) {
+	_, txn, ok := app.readAuthorization(w, r)
+	if !ok {
+		return
+	}
+
+	if txn.TransactionStatusID != models.TransactionAuthorized {
+		app.badRequest(w, r, models.ErrNotAuthorized)
+		return
+	}
+
+	_, err := app.Gateway.CancelPaymentIntent(txn.PaymentIntent)
+	if err != nil {
+		perr := app.paymentError(err)
+		app.badRequest(w, r, errors.New(perr.Message))
+		return
+	}
+
+	if err = app.DB.VoidAuthorization(txn.ID); err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, errors.New("the authorization was voided, but the database could not be updated"))
+		return
+	}
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+	resp.Error = false
+	resp.Message = "Authorization voided"
+	app.writeJSON(w, http.StatusOK, resp)
+}
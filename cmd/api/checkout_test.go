@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/cards"
+	"github.com/stripe/stripe-go"
+)
+
+// confirmResponse is the body of /api/payment-intent/confirm
+type confirmResponse struct {
+	OK           bool                `json:"ok"`
+	Status       string              `json:"status"`
+	NextStep     string              `json:"next_step"`
+	PaymentError *cards.PaymentError `json:"payment_error"`
+}
+
+// newFakeApp returns the application on the fake gateway, the handlers tested don't use the database
+func newFakeApp() *application {
+	return &application{
+		config:   config{gateway: "fake"},
+		infoLog:  log.New(io.Discard, "", 0),
+		errorLog: log.New(io.Discard, "", 0),
+		Gateway:  cards.NewFakeGateway(),
+	}
+}
+
+// post sends a JSON body to the routes of the application and returns the status and decoded body
+func post(t *testing.T, h http.Handler, path string, body, dst interface{}) int {
+	t.Helper()
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(b)))
+
+	if dst != nil {
+		if err := json.NewDecoder(rr.Body).Decode(dst); err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+	}
+	return rr.Code
+}
+
+// pay confirms the intent with the card as stripe.js would and asks the API what comes next
+func pay(t *testing.T, h http.Handler, pi, pm string) (int, confirmResponse) {
+	t.Helper()
+
+	status := post(t, h, "/api/fake/confirm-payment-intent", map[string]string{
+		"payment_intent": pi,
+		"payment_method": pm,
+	}, nil)
+
+	var resp confirmResponse
+	if code := post(t, h, "/api/payment-intent/confirm", map[string]string{"payment_intent": pi}, &resp); code != http.StatusOK {
+		t.Fatalf("confirm returned %d", code)
+	}
+	return status, resp
+}
+
+func TestCheckoutOnFakeGateway(t *testing.T) {
+	tests := []struct {
+		name     string
+		pm       string
+		status   int
+		step     string
+		category string
+	}{
+		{"visa", "pm_card_visa", http.StatusOK, cards.StepSucceeded, ""},
+		{"mastercard", "pm_card_mastercard", http.StatusOK, cards.StepSucceeded, ""},
+		{"authentication", "pm_card_authenticationRequired", http.StatusOK, cards.StepAuthenticate, ""},
+		{"declined", "pm_card_chargeDeclined", http.StatusBadRequest, cards.StepRetry, cards.CategoryDeclined},
+		{"insufficient funds", "pm_card_chargeDeclinedInsufficientFunds", http.StatusBadRequest, cards.StepRetry, cards.CategoryDeclined},
+		{"incorrect cvc", "pm_card_chargeDeclinedIncorrectCvc", http.StatusBadRequest, cards.StepRetry, cards.CategoryInvalidCard},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newFakeApp()
+			pi, _, err := app.Gateway.Charge("usd", 2000, nil, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			status, resp := pay(t, app.routes(), pi.ID, tt.pm)
+			if status != tt.status {
+				t.Errorf("got status %d, want %d", status, tt.status)
+			}
+			if resp.NextStep != tt.step || resp.OK != (tt.step == cards.StepSucceeded) {
+				t.Errorf("got step %s ok %t, want %s", resp.NextStep, resp.OK, tt.step)
+			}
+			if tt.category == "" {
+				if resp.PaymentError != nil {
+					t.Errorf("got payment error %s", resp.PaymentError.Category)
+				}
+			} else if resp.PaymentError == nil || resp.PaymentError.Category != tt.category {
+				t.Errorf("got payment error %+v, want %s", resp.PaymentError, tt.category)
+			}
+		})
+	}
+}
+
+func TestCheckoutAuthenticationOnFakeGateway(t *testing.T) {
+	app := newFakeApp()
+	h := app.routes()
+	pi, _, err := app.Gateway.Charge("usd", 2000, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pm := "pm_card_threeDSecure2Required"
+	if _, resp := pay(t, h, pi.ID, pm); resp.NextStep != cards.StepAuthenticate {
+		t.Fatalf("got step %s, want %s", resp.NextStep, cards.StepAuthenticate)
+	}
+	// the customer passed the challenge
+	if _, resp := pay(t, h, pi.ID, pm); !resp.OK || resp.Status != string(stripe.PaymentIntentStatusSucceeded) {
+		t.Errorf("got status %s ok %t, want succeeded", resp.Status, resp.OK)
+	}
+}
+
+func TestSubscriptionAuthenticationOnFakeGateway(t *testing.T) {
+	app := newFakeApp()
+	h := app.routes()
+
+	pm := "pm_card_authenticationRequired"
+	cust, _, err := app.Gateway.CreateCustomer("", pm, "me@here.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscription, err := app.Gateway.SubscribeToPlan(cust, "price_fake", "me@here.com", "3184", "visa", "", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subscription.LatestInvoice == nil || subscription.LatestInvoice.PaymentIntent == nil {
+		t.Fatal("the subscription has no invoice payment intent")
+	}
+	pi := subscription.LatestInvoice.PaymentIntent
+
+	var resp confirmResponse
+	post(t, h, "/api/payment-intent/confirm", map[string]string{"payment_intent": pi.ID}, &resp)
+	if resp.NextStep != cards.StepAuthenticate {
+		t.Fatalf("got step %s, want %s", resp.NextStep, cards.StepAuthenticate)
+	}
+
+	if _, resp = pay(t, h, pi.ID, pm); !resp.OK {
+		t.Fatalf("got step %s, want %s", resp.NextStep, cards.StepSucceeded)
+	}
+	subscription, err = app.Gateway.GetSubscription(subscription.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subscription.Status != stripe.SubscriptionStatusActive {
+		t.Errorf("got subscription status %s, want active", subscription.Status)
+	}
+}
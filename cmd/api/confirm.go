@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/cards"
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/models"
+	"github.com/stripe/stripe-go"
+)
+
+// ConfirmPaymentIntent tells the front end what to do with a payment intent after stripe.js
+// confirmed it: place the order, authenticate, wait or try another card. When the intent paid
+// the first invoice of a subscription the pending transaction is cleared.
+func (app *application) ConfirmPaymentIntent(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		PaymentIntent string `json:"payment_intent"`
+		Subscription  string `json:"subscription"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	if payload.PaymentIntent == "" {
+		app.badRequest(w, r, errors.New("payment_intent must be provided"))
+		return
+	}
+
+	pi, err := app.Gateway.RetriveGetPaymentIntent(payload.PaymentIntent)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var resp struct {
+		OK       bool   `json:"ok"`
+		Status   string `json:"status"`
+		NextStep string `json:"next_step"`
+		Message  string `json:"message,omitempty"`
+		// PaymentError says why the last attempt of the intent failed
+		PaymentError *cards.PaymentError `json:"payment_error,omitempty"`
+	}
+	resp.Status = string(pi.Status)
+	resp.NextStep = cards.NextStep(pi)
+	resp.OK = resp.NextStep == cards.StepSucceeded
+	resp.Message = cards.IntentMessage(pi)
+	if !resp.OK && pi.LastPaymentError != nil {
+		resp.PaymentError = cards.NewPaymentError(pi.LastPaymentError)
+	}
+
+	if resp.OK && payload.Subscription != "" {
+		if err := app.confirmSubscription(payload.Subscription); err != nil {
+			app.errorLog.Println(err)
+		}
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// confirmSubscription clears the pending transaction of a subscription once the gateway has it active
+func (app *application) confirmSubscription(subID string) error {
+	subscription, err := app.Gateway.GetSubscription(subID)
+	if err != nil {
+		return err
+	}
+
+	if err = app.syncSubscription(subscription); err != nil {
+		return err
+	}
+
+	if subscription.Status != stripe.SubscriptionStatusActive && subscription.Status != stripe.SubscriptionStatusTrialing {
+		return nil
+	}
+	return app.DB.UpdateTransactionStatusByPaymentIntent(subID, models.TransactionCleared)
+}
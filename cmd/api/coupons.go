@@ -0,0 +1,250 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/currency"
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/models"
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/validator"
+	"github.com/go-chi/chi/v5"
+)
+
+// validateCoupon checks a coupon sent by an admin
+func (app *application) validateCoupon(c *models.Coupon) *validator.Validator {
+	v := validator.New()
+
+	c.Code = models.NormalizeCouponCode(c.Code)
+	c.Currency = strings.ToLower(c.Currency)
+	if c.AppliesTo == "" {
+		c.AppliesTo = models.CouponAppliesToAll
+	}
+
+	v.Check(c.Code != "", "code", "must be provided")
+	v.Check(len(c.Code) <= 64, "code", "must be at most 64 characters")
+	v.Check(!strings.ContainsAny(c.Code, " ,:"), "code", "must not contain spaces, commas or colons")
+	v.Check(len(c.Description) <= 255, "description", "must be at most 255 characters")
+	v.Check((c.PercentOff > 0) != (c.AmountOff > 0), "percent_off", "set either percent_off or amount_off")
+	v.Check(c.PercentOff >= 0 && c.PercentOff <= 100, "percent_off", "must be between 1 and 100")
+	v.Check(c.AmountOff >= 0, "amount_off", "must not be negative")
+	v.Check(c.AmountOff == 0 || currency.Valid(c.Currency), "currency", "is not supported")
+	v.Check(c.MaxRedemptions >= 0, "max_redemptions", "must not be negative")
+	v.Check(c.PerCustomerLimit >= 0, "per_customer_limit", "must not be negative")
+	v.Check(c.AppliesTo == models.CouponAppliesToAll ||
+		c.AppliesTo == models.CouponAppliesToOneTime ||
+		c.AppliesTo == models.CouponAppliesToRecurring, "applies_to", "must be all, one_time or recurring")
+
+	if c.WidgetID > 0 {
+		if _, err := app.DB.GetWidget(c.WidgetID); err != nil {
+			v.AddError("widget_id", "must be a valid product or plan")
+		}
+	}
+
+	return v
+}
+
+// AllCoupons returns every coupon
+func (app *application) AllCoupons(w http.ResponseWriter, r *http.Request) {
+	coupons, err := app.DB.GetAllCoupons()
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, coupons)
+}
+
+// GetCoupon returns one coupon
+func (app *application) GetCoupon(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	coupon, err := app.DB.GetCoupon(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.notFound(w, r, "Coupon not found")
+			return
+		}
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, coupon)
+}
+
+// CreateCoupon adds a coupon
+func (app *application) CreateCoupon(w http.ResponseWriter, r *http.Request) {
+	var coupon models.Coupon
+
+	err := app.readJSON(w, r, &coupon)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+
+	v := app.validateCoupon(&coupon)
+	if _, err := app.DB.GetCouponByCode(coupon.Code); err == nil {
+		v.AddError("code", "is already used by another coupon")
+	}
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	id, err := app.DB.InsertCoupon(coupon)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+		ID      int    `json:"id"`
+	}
+
+	resp.Error = false
+	resp.Message = "Coupon Saved"
+	resp.ID = id
+
+	app.writeJSON(w, http.StatusCreated, resp)
+}
+
+// UpdateCoupon changes a coupon
+func (app *application) UpdateCoupon(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	if _, err := app.DB.GetCoupon(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.notFound(w, r, "Coupon not found")
+			return
+		}
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var coupon models.Coupon
+	err := app.readJSON(w, r, &coupon)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+	coupon.ID = id
+
+	v := app.validateCoupon(&coupon)
+	if existing, err := app.DB.GetCouponByCode(coupon.Code); err == nil && existing.ID != id {
+		v.AddError("code", "is already used by another coupon")
+	}
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	err = app.DB.UpdateCoupon(coupon)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	resp.Error = false
+	resp.Message = "Coupon Saved"
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// DeleteCoupon deletes a coupon
+func (app *application) DeleteCoupon(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := app.DB.DeleteCoupon(id)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	resp.Error = false
+	resp.Message = "Coupon Deleted"
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// RedeemCoupon prices an order with a coupon so the checkout can show the discount,
+// the coupon is only recorded against the order once it is paid
+func (app *application) RedeemCoupon(w http.ResponseWriter, r *http.Request) {
+	var payload stripePayload
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(strings.TrimSpace(payload.Coupon) != "", "coupon", "must be provided")
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	recurring := false
+	if payload.ProductID != "" {
+		productID, _ := strconv.Atoi(payload.ProductID)
+		if widget, err := app.DB.GetWidget(productID); err == nil {
+			recurring = widget.IsRecurring
+		}
+	}
+
+	order, err := app.priceOrder(payload, recurring, v)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	var resp struct {
+		Error     bool   `json:"error"`
+		Message   string `json:"message"`
+		Code      string `json:"code"`
+		Currency  string `json:"currency"`
+		Subtotal  int    `json:"subtotal"`
+		Discount  int    `json:"discount"`
+		Total     int    `json:"total"`
+		Formatted string `json:"formatted"`
+	}
+
+	resp.Error = false
+	resp.Message = "Coupon applied"
+	resp.Code = order.Coupon.Code
+	resp.Currency = order.Currency
+	resp.Subtotal = order.Subtotal
+	resp.Discount = order.Discount
+	resp.Total = order.Total
+	resp.Formatted = currency.Format(order.Total, order.Currency)
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
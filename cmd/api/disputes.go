@@ -0,0 +1,189 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/cards"
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/models"
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/validator"
+	"github.com/go-chi/chi/v5"
+	"github.com/stripe/stripe-go"
+)
+
+// maxEvidenceSize is the most the gateway accepts for all the evidence of a dispute
+const maxEvidenceSize = 5 << 20
+
+// maxEvidenceText is the most characters the gateway accepts for the evidence text
+const maxEvidenceText = 20000
+
+// recordDispute saves a dispute event, a dispute the bank decided gets its outcome
+func (app *application) recordDispute(dispute stripe.Dispute) error {
+	d := models.Dispute{
+		GatewayDisputeID: dispute.ID,
+		Amount:           int(dispute.Amount),
+		Currency:         string(dispute.Currency),
+		Status:           string(dispute.Status),
+		Reason:           string(dispute.Reason),
+	}
+	if dispute.PaymentIntent != nil {
+		d.PaymentIntent = dispute.PaymentIntent.ID
+	}
+	if dispute.EvidenceDetails != nil && dispute.EvidenceDetails.DueBy > 0 {
+		dueBy := time.Unix(dispute.EvidenceDetails.DueBy, 0)
+		d.EvidenceDueBy = &dueBy
+	}
+
+	switch dispute.Status {
+	case stripe.DisputeStatusWon, stripe.DisputeStatusLost, stripe.DisputeStatusWarningClosed, stripe.DisputeStatusChargeRefunded:
+		d.Outcome = string(dispute.Status)
+	}
+
+	if err := app.DB.SaveDispute(d); err != nil {
+		return err
+	}
+	app.infoLog.Printf("dispute %s for %d %s is %s (%s)", d.GatewayDisputeID, d.Amount, d.Currency, d.Status, d.Reason)
+	return nil
+}
+
+// AllDisputes returns the disputes, the ones waiting for evidence first
+func (app *application) AllDisputes(w http.ResponseWriter, r *http.Request) {
+	disputes, err := app.DB.GetDisputes()
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, disputes)
+}
+
+// GetDispute returns a dispute with its evidence files
+func (app *application) GetDispute(w http.ResponseWriter, r *http.Request) {
+	dispute, ok := app.readDispute(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, dispute)
+}
+
+func (app *application) readDispute(w http.ResponseWriter, r *http.Request) (*models.Dispute, bool) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	dispute, err := app.DB.GetDispute(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.notFound(w, r, "Dispute not found")
+			return nil, false
+		}
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return nil, false
+	}
+	return dispute, true
+}
+
+// SubmitDisputeEvidence sends evidence for a dispute to the gateway. The multipart form has the
+// text, the files in fields named by their kind, e.g. receipt, and submit=true to send it to the bank,
+// without submit the evidence is only saved and can still be changed.
+func (app *application) SubmitDisputeEvidence(w http.ResponseWriter, r *http.Request) {
+	dispute, ok := app.readDispute(w, r)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxEvidenceSize+1<<20)
+	if err := r.ParseMultipartForm(maxEvidenceSize); err != nil {
+		app.badRequest(w, r, errors.New("the evidence must be a multipart form of at most 5MB"))
+		return
+	}
+
+	text := r.FormValue("text")
+	submit := r.FormValue("submit") == "true"
+
+	v := validator.New()
+	v.Check(dispute.Status == string(stripe.DisputeStatusNeedsResponse) || dispute.Status == string(stripe.DisputeStatusWarningNeedsResponse),
+		"id", "the dispute is not waiting for evidence")
+	v.Check(dispute.EvidenceDueBy == nil || time.Now().Before(*dispute.EvidenceDueBy), "id", "the evidence was due on "+dueDate(dispute))
+	v.Check(len(text) <= maxEvidenceText, "text", fmt.Sprintf("must be at most %d characters", maxEvidenceText))
+	for field := range r.MultipartForm.File {
+		v.Check(isDisputeFileKind(field), field, "is not a kind of evidence file")
+	}
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	var files []models.DisputeFile
+	gatewayFiles := make(map[string]string)
+	for kind, headers := range r.MultipartForm.File {
+		// the gateway takes one file of each kind
+		header := headers[0]
+		f, err := header.Open()
+		if err != nil {
+			app.badRequest(w, r, err)
+			return
+		}
+		uploaded, err := app.Gateway.UploadDisputeFile(header.Filename, f)
+		f.Close()
+		if err != nil {
+			perr := app.paymentError(err)
+			app.badRequest(w, r, errors.New(perr.Message))
+			return
+		}
+		gatewayFiles[kind] = uploaded.ID
+		files = append(files, models.DisputeFile{
+			Kind:          kind,
+			GatewayFileID: uploaded.ID,
+			Filename:      header.Filename,
+		})
+	}
+
+	updated, err := app.Gateway.UpdateDisputeEvidence(dispute.GatewayDisputeID, text, gatewayFiles, submit)
+	if err != nil {
+		perr := app.paymentError(err)
+		app.badRequest(w, r, errors.New(perr.Message))
+		return
+	}
+
+	err = app.DB.SaveDisputeEvidence(dispute.ID, text, string(updated.Status), files, submit)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, errors.New("the evidence was sent, but the database could not be updated"))
+		return
+	}
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	}
+	resp.Error = false
+	resp.Message = "Evidence saved"
+	if submit {
+		resp.Message = "Evidence submitted"
+	}
+	resp.Status = string(updated.Status)
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+func isDisputeFileKind(kind string) bool {
+	for _, k := range cards.DisputeFileKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func dueDate(d *models.Dispute) string {
+	if d.EvidenceDueBy == nil {
+		return ""
+	}
+	return d.EvidenceDueBy.Format("2006-01-02")
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/currency"
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/encryption"
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/models"
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/urlsigner"
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/validator"
+	"github.com/stripe/stripe-go"
+)
+
+// dunningEmail is the data of the dunning email templates
+type dunningEmail struct {
+	Name        string
+	Plan        string
+	Amount      string
+	Link        string
+	Attempt     int
+	NextAttempt string
+}
+
+// parseDunningSchedule reads the waits between retries of a failed renewal, e.g. "72h,120h,168h"
+func parseDunningSchedule(s string) ([]time.Duration, error) {
+	var schedule []time.Duration
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		d, err := time.ParseDuration(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dunning schedule %q: %w", s, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("invalid dunning schedule %q: waits must be positive", s)
+		}
+		schedule = append(schedule, d)
+	}
+	if len(schedule) == 0 {
+		return nil, fmt.Errorf("invalid dunning schedule %q: no retries", s)
+	}
+	return schedule, nil
+}
+
+// startDunning puts the subscription of a failed renewal invoice in dunning and tells the customer
+func (app *application) startDunning(invoice stripe.Invoice) error {
+	if invoice.BillingReason != stripe.InvoiceBillingReasonSubscriptionCycle &&
+		invoice.BillingReason != stripe.InvoiceBillingReasonSubscriptionUpdate {
+		// the first invoice is handled while subscribing, a trial ended early is invoiced as an update
+		return nil
+	}
+
+	orderID, _, err := app.DB.GetOrderIDByPaymentIntent(invoice.Subscription.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	next := time.Now().Add(app.config.dunning.schedule[0])
+	isNew, err := app.DB.StartDunning(models.Dunning{
+		OrderID:               orderID,
+		GatewaySubscriptionID: invoice.Subscription.ID,
+		InvoiceID:             invoice.ID,
+		NextAttemptAt:         &next,
+	})
+	if err != nil || !isNew {
+		return err
+	}
+
+	order, err := app.DB.GetOrderByID(orderID)
+	if err != nil {
+		return err
+	}
+
+	app.sendDunningEmail(order, "dunning-payment-failed", "Your payment failed", 0, &next)
+	return nil
+}
+
+// runDunning retries the due dunning every interval until the application stops
+func (app *application) runDunning() {
+	ticker := time.NewTicker(app.config.dunning.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		due, err := app.DB.GetDueDunning(time.Now())
+		if err != nil {
+			app.errorLog.Println(err)
+			continue
+		}
+
+		for _, d := range due {
+			if d.State == models.DunningUnpaid {
+				app.cancelUnpaid(d)
+				continue
+			}
+			app.retryDunning(d)
+		}
+	}
+}
+
+// retryDunning tries to collect the failed invoice again, the subscription is cancelled
+// after the last attempt of the schedule
+func (app *application) retryDunning(d *models.Dunning) {
+	d.Attempts++
+
+	invoice, err := app.Gateway.RetryInvoice(d.InvoiceID)
+	if err == nil && invoice.Paid {
+		d.State = models.DunningRecovered
+		d.NextAttemptAt = nil
+		d.LastError = ""
+		if err := app.DB.UpdateDunning(*d); err != nil {
+			app.errorLog.Println(err)
+			return
+		}
+		if err := app.DB.UpdateTransactionStatusByPaymentIntent(d.GatewaySubscriptionID, models.TransactionCleared); err != nil {
+			app.errorLog.Println(err)
+		}
+		if err := app.refreshSubscription(d.GatewaySubscriptionID); err != nil {
+			app.errorLog.Println(err)
+		}
+		app.infoLog.Printf("dunning %d recovered after %d attempts", d.ID, d.Attempts)
+		return
+	}
+
+	d.LastError = "invoice was not paid"
+	if err != nil {
+		d.LastError = err.Error()
+		if stripeErr, ok := err.(*stripe.Error); ok {
+			d.LastError = stripeErr.Msg
+		}
+	}
+
+	if d.Attempts >= len(app.config.dunning.schedule) {
+		d.State = models.DunningUnpaid
+		d.NextAttemptAt = nil
+		if err := app.DB.UpdateDunning(*d); err != nil {
+			app.errorLog.Println(err)
+			return
+		}
+		app.cancelUnpaid(d)
+		return
+	}
+
+	next := time.Now().Add(app.config.dunning.schedule[d.Attempts])
+	d.State = models.DunningRetrying
+	d.NextAttemptAt = &next
+	if err := app.DB.UpdateDunning(*d); err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+
+	order, err := app.DB.GetOrderByID(d.OrderID)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+	app.sendDunningEmail(order, "dunning-payment-failed", "Your payment failed again", d.Attempts, &next)
+}
+
+// cancelUnpaid cancels the subscription of a dunning that ran out of attempts, when the
+// gateway can't be reached the dunning stays unpaid and is tried again on the next run
+func (app *application) cancelUnpaid(d *models.Dunning) {
+	subscription, err := app.Gateway.CancelSubscriptionNow(d.GatewaySubscriptionID)
+	if err != nil {
+		if !subscriptionGone(err) {
+			app.errorLog.Println(err)
+			return
+		}
+		// the subscription was cancelled on the gateway already, there is nothing left to cancel
+		app.infoLog.Printf("dunning %d: %v", d.ID, err)
+		subscription = nil
+	}
+
+	description := fmt.Sprintf("Cancelled after %d failed payment attempts", d.Attempts)
+	err = app.DB.UpdateOrderStatus(d.OrderID, models.OrderCancelled, 0, description)
+	if err != nil && !errors.Is(err, models.ErrIllegalTransition) {
+		app.errorLog.Println(err)
+		return
+	}
+	// an order that can't be cancelled anymore was cancelled or refunded meanwhile, the dunning is closed all the same
+
+	d.State = models.DunningCancelled
+	if err = app.DB.UpdateDunning(*d); err != nil {
+		app.errorLog.Println(err)
+	}
+
+	if subscription != nil {
+		if err = app.syncSubscription(subscription); err != nil {
+			app.errorLog.Println(err)
+		}
+	}
+
+	order, err := app.DB.GetOrderByID(d.OrderID)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+	app.sendDunningEmail(order, "dunning-cancelled", "Your subscription was cancelled", d.Attempts, nil)
+}
+
+// subscriptionGone reports whether the gateway refused to cancel a subscription because
+// it doesn't exist or was cancelled already
+func subscriptionGone(err error) bool {
+	var stripeErr *stripe.Error
+	if !errors.As(err, &stripeErr) {
+		return false
+	}
+	return stripeErr.Code == stripe.ErrorCodeResourceMissing || stripeErr.HTTPStatusCode == http.StatusNotFound
+}
+
+// sendDunningEmail tells the customer about the dunning of the order with a signed link to update the card
+func (app *application) sendDunningEmail(order models.Order, teml, subject string, attempt int, next *time.Time) {
+	link := fmt.Sprintf("%s/update-card?subscription=%s", app.config.frontend, order.Transaction.PaymentIntent)
+	sign := urlsigner.Signer{
+		Secrect: []byte(app.config.secrectkey),
+	}
+
+	data := dunningEmail{
+		Name:    order.Customer.FirstName,
+		Plan:    order.Widget.Name,
+		Amount:  currency.Format(order.Amount, order.Transaction.Currency),
+		Link:    sign.GenerateTokenFromString(link),
+		Attempt: attempt,
+	}
+	if next != nil {
+		data.NextAttempt = next.Format("January 2, 2006")
+	}
+
+	if err := app.SendEmail("info@widgets.com", order.Customer.Email, subject, teml, data); err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// UpdateCard makes the card of the update card page the default of the customer and retries the
+// open dunning of the subscription on the next run
+func (app *application) UpdateCard(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Subscription  string `json:"subscription"`
+		PaymentMethod string `json:"payment_method"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(payload.Subscription != "", "subscription", "missing subscription")
+	v.Check(payload.PaymentMethod != "", "payment_method", "missing payment method")
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	encryptor := encryption.Encryption{
+		Key: []byte(app.config.secrectkey),
+	}
+	subID, err := encryptor.Decrypt(payload.Subscription)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, errors.New("invalid subscription"))
+		return
+	}
+
+	subscription, err := app.Gateway.GetSubscription(subID)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+	if subscription.Status == stripe.SubscriptionStatusCanceled || subscription.Customer == nil {
+		app.badRequest(w, r, errors.New("the subscription was cancelled"))
+		return
+	}
+
+	_, msg, err := app.Gateway.CreateCustomer(subscription.Customer.ID, payload.PaymentMethod, "", "")
+	if err != nil {
+		app.errorLog.Println(err)
+		if msg == "" {
+			msg = "Your card could not be saved"
+		}
+		app.badRequest(w, r, errors.New(msg))
+		return
+	}
+
+	if err = app.DB.RetryDunningNow(subID); err != nil {
+		app.errorLog.Println(err)
+	}
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+	resp.Error = false
+	resp.Message = "Your card was updated, we will try the payment again shortly"
+	app.writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/cards"
+	"github.com/stripe/stripe-go"
+)
+
+func TestParseDunningSchedule(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    []time.Duration
+		wantErr bool
+	}{
+		{"one wait", "72h", []time.Duration{72 * time.Hour}, false},
+		{"several waits", "24h, 72h,168h", []time.Duration{24 * time.Hour, 72 * time.Hour, 168 * time.Hour}, false},
+		{"empty parts", ",24h,,", []time.Duration{24 * time.Hour}, false},
+		{"empty", "", nil, true},
+		{"only commas", " , ", nil, true},
+		{"not a duration", "24h,3d", nil, true},
+		{"zero wait", "0s", nil, true},
+		{"negative wait", "24h,-1h", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDunningSchedule(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscriptionGone(t *testing.T) {
+	fake := cards.NewFakeGateway()
+	_, cancelled := fake.CancelSubscriptionNow("sub_missing")
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"fake gateway", cancelled, true},
+		{"resource missing", &stripe.Error{Code: stripe.ErrorCodeResourceMissing}, true},
+		{"not found", &stripe.Error{HTTPStatusCode: http.StatusNotFound}, true},
+		{"wrapped", fmt.Errorf("cancel: %w", &stripe.Error{Code: stripe.ErrorCodeResourceMissing}), true},
+		{"api error", &stripe.Error{Type: stripe.ErrorTypeAPI, HTTPStatusCode: http.StatusInternalServerError}, false},
+		{"network", errors.New("connection refused"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := subscriptionGone(tt.err); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/fajarcahyadiputra/udemy-web-application/internal/cards"
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/currency"
 	"github.com/fajarcahyadiputra/udemy-web-application/internal/encryption"
 	"github.com/fajarcahyadiputra/udemy-web-application/internal/models"
 	"github.com/fajarcahyadiputra/udemy-web-application/internal/urlsigner"
@@ -20,25 +21,41 @@ import (
 )
 
 type stripePayload struct {
-	Currency      string `json:"currency"`
-	Amount        string `json:"amount"`
-	PaymentMethod string `json:"payment_method"`
-	Email         string `json:"email"`
-	LasFour       string `json:"last_four"`
-	ExpMonth      int    `json:"exp_month"`
-	ExpYear       int    `json:"exp_year"`
-	CardBrand     string `json:"card_brand"`
-	Plan          string `json:"plan"`
-	ProductID     string `json:"product_id"`
-	FirstName     string `json:"first_name"`
-	LastName      string `json:"last_name"`
+	Currency      string     `json:"currency"`
+	Amount        string     `json:"amount"`
+	PaymentMethod string     `json:"payment_method"`
+	Email         string     `json:"email"`
+	LasFour       string     `json:"last_four"`
+	ExpMonth      int        `json:"exp_month"`
+	ExpYear       int        `json:"exp_year"`
+	CardBrand     string     `json:"card_brand"`
+	Plan          string     `json:"plan"`
+	ProductID     string     `json:"product_id"`
+	Quantity      int        `json:"quantity"`
+	FirstName     string     `json:"first_name"`
+	LastName      string     `json:"last_name"`
+	Items         []cartItem `json:"items"`
+	Coupon        string     `json:"coupon"`
+	// CaptureMethod manual only authorizes a virtual terminal amount, it is captured later
+	CaptureMethod string `json:"capture_method"`
+}
+
+// cartItem is one line of a cart sent to be priced
+type cartItem struct {
+	ProductID int `json:"product_id"`
+	Quantity  int `json:"quantity"`
 }
 
 type jsonResponse struct {
-	OK      bool   `json:"ok"`
-	Message string `json:"message,omitempty"`
-	Content string `json:"content,omitempty"`
-	ID      int    `json:"id,omitempty"`
+	OK           bool   `json:"ok"`
+	Message      string `json:"message,omitempty"`
+	Content      string `json:"content,omitempty"`
+	ID           int    `json:"id,omitempty"`
+	NextStep     string `json:"next_step,omitempty"`
+	ClientSecret string `json:"client_secret,omitempty"`
+	Subscription string `json:"subscription,omitempty"`
+	// PaymentError says why the gateway refused the payment
+	PaymentError *cards.PaymentError `json:"payment_error,omitempty"`
 }
 
 func (app *application) GetPaymentIntent(w http.ResponseWriter, r *http.Request) {
@@ -48,26 +65,100 @@ func (app *application) GetPaymentIntent(w http.ResponseWriter, r *http.Request)
 
 	if err != nil {
 		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
 		return
 	}
 
-	amount, err := strconv.Atoi(payload.Amount)
-	if err != nil {
-		app.errorLog.Println(err)
-		return
+	var amount int
+	var releaseOnFailure string
+	code := strings.ToLower(payload.Currency)
+	if code == "" {
+		code = currency.DefaultCurrency
 	}
+	metadata := make(map[string]string)
 
-	card := cards.Card{
-		Key:      app.config.stripe.key,
-		Secret:   app.config.stripe.secret,
-		Currency: payload.Currency,
+	if payload.ProductID != "" || len(payload.Items) > 0 {
+		v := validator.New()
+		order, err := app.priceOrder(payload, false, v)
+		if err != nil {
+			app.errorLog.Println(err)
+			app.badRequest(w, r, err)
+			return
+		}
+		if !v.Valid() {
+			app.failedValidation(w, r, v.Errors)
+			return
+		}
+
+		// hold the stock until the payment intent is confirmed or the reservation expires
+		reservation, err := newReservationReference()
+		if err != nil {
+			app.errorLog.Println(err)
+			app.badRequest(w, r, err)
+			return
+		}
+		err = app.DB.ReserveInventory(reservation, order.Items, time.Now().Add(app.config.reservation))
+		if err != nil {
+			if errors.Is(err, models.ErrOutOfStock) {
+				app.outOfStock(w, err)
+				return
+			}
+			app.errorLog.Println(err)
+			app.badRequest(w, r, err)
+			return
+		}
+		releaseOnFailure = reservation
+
+		amount = order.Total
+		metadata = order.metadata()
+		metadata["reservation"] = reservation
+		if payload.Email != "" {
+			metadata["email"] = payload.Email
+		}
+	} else {
+		// the virtual terminal charges an amount entered by staff
+		user, err := app.authenticateToken(r)
+		if err != nil {
+			app.invalidCredentials(w)
+			return
+		}
+
+		amount, err = strconv.Atoi(payload.Amount)
+		if err != nil {
+			app.errorLog.Println(err)
+			app.badRequest(w, r, errors.New("invalid amount"))
+			return
+		}
+		if !currency.Valid(code) {
+			app.badRequest(w, r, errors.New("currency is not supported"))
+			return
+		}
+		if payload.CaptureMethod != "" && payload.CaptureMethod != "automatic" && payload.CaptureMethod != "manual" {
+			app.badRequest(w, r, errors.New("capture method must be automatic or manual"))
+			return
+		}
+		metadata["virtual_terminal"] = "1"
+		metadata["user_id"] = strconv.Itoa(user.ID)
 	}
 
 	ok := true
 
-	pi, msg, err := card.Charge(payload.Currency, amount)
+	charge := app.Gateway.Charge
+	if metadata["virtual_terminal"] == "1" && payload.CaptureMethod == "manual" {
+		metadata["capture_method"] = "manual"
+		charge = app.Gateway.Authorize
+	}
+
+	var paymentErr *cards.PaymentError
+	pi, _, err := charge(code, amount, metadata, idempotencyKey(r, "payment-intent"))
 	if err != nil {
 		ok = false
+		paymentErr = app.paymentError(err)
+		if releaseOnFailure != "" {
+			if err := app.DB.ReleaseInventory(releaseOnFailure); err != nil {
+				app.errorLog.Println(err)
+			}
+		}
 	}
 
 	if ok {
@@ -80,9 +171,10 @@ func (app *application) GetPaymentIntent(w http.ResponseWriter, r *http.Request)
 		w.Write(out)
 	} else {
 		j := jsonResponse{
-			OK:      false,
-			Message: msg,
-			Content: "",
+			OK:           false,
+			Message:      paymentErr.Message,
+			Content:      "",
+			PaymentError: paymentErr,
 		}
 
 		out, err := json.MarshalIndent(j, "", "  ")
@@ -106,7 +198,21 @@ func (app *application) GetWidgetByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	out, err := json.MarshalIndent(widget, "", "  ")
+	prices, err := app.DB.GetWidgetPrices(widget)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var resp struct {
+		models.Widget
+		Prices []models.WidgetPrice `json:"prices"`
+	}
+	resp.Widget = widget
+	resp.Prices = prices
+
+	out, err := json.MarshalIndent(resp, "", "  ")
 	if err != nil {
 		app.errorLog.Println(err)
 		return
@@ -131,88 +237,140 @@ func (app *application) CreateCustomerAndSubscribeToPlan(w http.ResponseWriter,
 	v.Check(len(data.FirstName) > 1, "first_name", "must be at least 2 character")
 	// v.Check(len(data.LastName) > 1, "first_name", "must be at least 2 character")
 
+	data.Items = nil
+	plan, err := app.priceOrder(data, true, v)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+
 	if !v.Valid() {
 		app.failedValidation(w, r, v.Errors)
 		return
 	}
 
-	card := cards.Card{
-		Secret:   app.config.stripe.secret,
-		Key:      app.config.stripe.key,
-		Currency: data.Currency,
+	couponID := ""
+	if plan.Coupon != nil {
+		couponID = plan.Coupon.GatewayCouponID
 	}
+
 	okay := true
 	var subscription *stripe.Subscription
+	var paymentErr *cards.PaymentError
 	txnMsg := "Transaction successful"
 
-	stripeCustomer, msg, err := card.CreateCustomer(data.PaymentMethod, data.Email)
+	// the buyer is not signed in, the email they typed doesn't prove they own a gateway customer
+	// we already have, so every subscription gets a new one and no card is added to an old one
+	stripeCustomer, _, err := app.Gateway.CreateCustomer("", data.PaymentMethod, data.Email, idempotencyKey(r, "customer"))
 	if err != nil {
-		app.errorLog.Println("ERROR CREATE CUSTOMER:", err)
 		okay = false
-		txnMsg = msg
+		paymentErr = app.paymentError(err)
+		txnMsg = paymentErr.Message
 	}
 
 	if okay {
-		subscription, err = card.SubscribeToPlan(stripeCustomer, data.Plan, data.Email, data.LasFour, "")
+		subscription, err = app.Gateway.SubscribeToPlan(stripeCustomer, plan.PlanID, data.Email, data.LasFour, "", couponID, plan.TrialDays, idempotencyKey(r, "subscription"))
 		if err != nil {
-			app.errorLog.Println("ERROR SUBSCRIBE: ", err)
 			okay = false
-			txnMsg = "Error subscribing customer"
+			paymentErr = app.paymentError(err)
+			txnMsg = paymentErr.Message
+		} else {
+			app.infoLog.Println("subscription id is (payment intent)", subscription.ID)
 		}
+	}
 
-		app.infoLog.Println("subscription id is (payment intent)", subscription.ID)
+	// the first invoice may need the customer to authenticate, the transaction
+	// stays pending until the invoice is paid
+	txnStatusID := models.TransactionCleared
+	nextStep, clientSecret := cards.StepSucceeded, ""
+	if okay && subscription.LatestInvoice != nil && subscription.LatestInvoice.PaymentIntent != nil {
+		pi := subscription.LatestInvoice.PaymentIntent
+		nextStep = cards.NextStep(pi)
+		switch nextStep {
+		case cards.StepRetry, cards.StepCanceled:
+			okay = false
+			txnMsg = cards.IntentMessage(pi)
+			if pi.LastPaymentError != nil {
+				paymentErr = app.paymentError(pi.LastPaymentError)
+			}
+		case cards.StepAuthenticate, cards.StepProcessing:
+			txnStatusID = models.TransactionPending
+			clientSecret = pi.ClientSecret
+			txnMsg = cards.IntentMessage(pi)
+		}
 	}
 
 	if okay {
-		productID, _ := strconv.Atoi(data.ProductID)
-		customerID, err := app.SaveCustomer(data.FirstName, data.LastName, data.Email)
-		if err != nil {
-			app.errorLog.Println(err)
-			app.badRequest(w, r, err)
-			return
+		customer := models.Customer{
+			FirstName:         data.FirstName,
+			LastName:          data.LastName,
+			Email:             data.Email,
+			GatewayCustomerID: stripeCustomer.ID,
 		}
 
 		//create a new txn
-		amount, _ := strconv.Atoi(data.Amount)
+		amount := plan.Total
 		txn := models.Transaction{
 			Amount:              amount,
-			Currency:            "cad",
+			Currency:            plan.Currency,
 			LastFour:            data.LasFour,
 			ExpiryMonth:         data.ExpMonth,
 			ExpiryYear:          data.ExpYear,
-			TransactionStatusID: 2,
+			TransactionStatusID: txnStatusID,
 			PaymentIntent:       subscription.ID,
 			PaymentMethod:       data.PaymentMethod,
 		}
 
-		txnID, err := app.SaveTransaction(txn)
-		if err != nil {
-			app.errorLog.Println(err)
-			app.badRequest(w, r, err)
-			return
-		}
+		sub := subscriptionFromGateway(subscription)
+		sub.WidgetID = plan.Items[0].WidgetID
 
 		//create order
 		order := models.Order{
-			WidgetID:      productID,
-			TransactionID: txnID,
-			CustomerID:    customerID,
-			Amount:        amount,
-			StatusID:      1,
-			Quantity:      1,
+			WidgetID:     plan.Items[0].WidgetID,
+			Amount:       amount,
+			StatusID:     models.OrderCharged,
+			Quantity:     1,
+			Items:        plan.Items,
+			Subscription: &sub,
+		}
+
+		var redemption *models.CouponRedemption
+		if plan.Coupon != nil {
+			redemption = &models.CouponRedemption{
+				CouponID:       plan.Coupon.ID,
+				Code:           plan.Coupon.Code,
+				CustomerEmail:  data.Email,
+				DiscountAmount: plan.Discount,
+				Currency:       plan.Currency,
+			}
 		}
-		_, err = app.SaveOrder(order)
+
+		_, err = app.DB.CreateOrder(customer, txn, order, redemption)
 		if err != nil {
+			// the customer is subscribed already, keep what is needed to put it right
 			app.errorLog.Println(err)
+			app.recordReconciliation(subscription.ID, amount, plan.Currency, data.Email, err, struct {
+				Customer     models.Customer          `json:"customer"`
+				Transaction  models.Transaction       `json:"transaction"`
+				Order        models.Order             `json:"order"`
+				Redemption   *models.CouponRedemption `json:"redemption"`
+				Subscription string                   `json:"subscription"`
+			}{customer, txn, order, redemption, subscription.ID})
 			app.badRequest(w, r, err)
 			return
 		}
-
 	}
 
 	resp := jsonResponse{
-		OK:      okay,
-		Message: txnMsg,
+		OK:           okay,
+		Message:      txnMsg,
+		PaymentError: paymentErr,
+	}
+	if okay {
+		resp.NextStep = nextStep
+		resp.ClientSecret = clientSecret
+		resp.Subscription = subscription.ID
 	}
 
 	out, err := json.MarshalIndent(resp, "", " ")
@@ -224,21 +382,6 @@ func (app *application) CreateCustomerAndSubscribeToPlan(w http.ResponseWriter,
 	w.Write(out)
 }
 
-// save customer and returns a id
-func (app *application) SaveCustomer(firstName string, lastName string, email string) (int, error) {
-	customer := models.Customer{
-		FirstName: firstName,
-		LastName:  lastName,
-		Email:     email,
-	}
-
-	id, err := app.DB.InsertCustomer(customer)
-	if err != nil {
-		return 0, err
-	}
-	return id, nil
-}
-
 // save transaction and returns a id
 func (app *application) SaveTransaction(txn models.Transaction) (int, error) {
 	id, err := app.DB.InsertTransaction(txn)
@@ -248,14 +391,6 @@ func (app *application) SaveTransaction(txn models.Transaction) (int, error) {
 	return id, nil
 }
 
-// save order and returns a id
-func (app *application) SaveOrder(txn models.Order) (int, error) {
-	id, err := app.DB.InsertOrder(txn)
-	if err != nil {
-		return 0, err
-	}
-	return id, nil
-}
 func (app *application) CreateAuthToken(w http.ResponseWriter, r *http.Request) {
 	var userInput struct {
 		Email    string `json:"email"`
@@ -382,18 +517,30 @@ func (app *application) VirtualTerminalPaymentSucceeded(w http.ResponseWriter, r
 		return
 	}
 
-	card := cards.Card{
-		Secret: app.config.stripe.secret,
-		Key:    app.config.stripe.key,
+	pi, err := app.Gateway.RetriveGetPaymentIntent(txnData.PaymentIntent)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
 	}
 
-	pi, err := card.RetriveGetPaymentIntent(txnData.PaymentIntent)
+	// an authorization is saved as held until it is captured
+	txnStatusID := models.TransactionCleared
+	var authorizedAmount int
+	var expiresAt *time.Time
+	charge, err := cards.IntentCharge(pi)
+	if errors.Is(err, cards.ErrPaymentIncomplete) && cards.NextStep(pi) == cards.StepAuthorized {
+		var expiry time.Time
+		charge, expiry, err = cards.IntentAuthorization(pi)
+		txnStatusID = models.TransactionAuthorized
+		authorizedAmount = int(pi.AmountCapturable)
+		expiresAt = &expiry
+	}
 	if err != nil {
 		app.badRequest(w, r, err)
 		return
 	}
 
-	pm, err := card.GetPaymentMethod(txnData.PaymentMethod)
+	pm, err := app.Gateway.GetPaymentMethod(txnData.PaymentMethod)
 	if err != nil {
 		app.badRequest(w, r, err)
 		return
@@ -403,16 +550,21 @@ func (app *application) VirtualTerminalPaymentSucceeded(w http.ResponseWriter, r
 	txnData.ExpiryAmount = int(pm.Card.ExpMonth)
 	txnData.ExpiryYear = int(pm.Card.ExpYear)
 
+	txnData.PaymentAmount = int(pi.Amount)
+	txnData.PaymentCurrency = pi.Currency
+
 	txn := models.Transaction{
-		Amount:              txnData.PaymentAmount,
-		Currency:            txnData.PaymentCurrency,
-		LastFour:            txnData.LastFour,
-		ExpiryMonth:         txnData.ExpiryAmount,
-		PaymentIntent:       txnData.PaymentIntent,
-		PaymentMethod:       txnData.PaymentMethod,
-		ExpiryYear:          txnData.ExpiryYear,
-		BankReturnCode:      pi.Charges.Data[0].ID,
-		TransactionStatusID: 2,
+		Amount:                 txnData.PaymentAmount,
+		Currency:               txnData.PaymentCurrency,
+		LastFour:               txnData.LastFour,
+		ExpiryMonth:            txnData.ExpiryAmount,
+		PaymentIntent:          txnData.PaymentIntent,
+		PaymentMethod:          txnData.PaymentMethod,
+		ExpiryYear:             txnData.ExpiryYear,
+		BankReturnCode:         charge.ID,
+		TransactionStatusID:    txnStatusID,
+		AuthorizedAmount:       authorizedAmount,
+		AuthorizationExpiresAt: expiresAt,
 	}
 
 	_, err = app.SaveTransaction(txn)
@@ -629,6 +781,7 @@ func (app *application) RefundCharge(w http.ResponseWriter, r *http.Request) {
 		PaymentIntent string `json:"payment_intent"`
 		Amount        int    `json:"amount"`
 		Currency      string `json:"currency"`
+		Reason        string `json:"reason"`
 	}
 	err := app.readJSON(w, r, &chargeToRefund)
 	if err != nil {
@@ -636,43 +789,83 @@ func (app *application) RefundCharge(w http.ResponseWriter, r *http.Request) {
 		app.badRequest(w, r, err)
 		return
 	}
-	//validate
-	card := cards.Card{
-		Secret:   app.config.stripe.secret,
-		Key:      app.config.stripe.key,
-		Currency: chargeToRefund.Currency,
+
+	user, err := app.authenticateToken(r)
+	if err != nil {
+		app.invalidCredentials(w)
+		return
 	}
 
-	err = card.Refund(chargeToRefund.PaymentIntent, chargeToRefund.Amount)
+	order, err := app.DB.GetOrderByID(chargeToRefund.ID)
 	if err != nil {
 		app.errorLog.Println(err)
 		app.badRequest(w, r, err)
 		return
 	}
 
-	//update status in database
-	err = app.DB.UpdateOrderStatus(chargeToRefund.ID, 2)
+	//validate
+	v := validator.New()
+	v.Check(chargeToRefund.Amount > 0, "amount", "must be greater than zero")
+	v.Check(len(chargeToRefund.Reason) <= 255, "reason", "must be at most 255 characters")
+
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	// the remaining amount and the status are checked again with the transaction locked
+	refunded := false
+	orderStatusID, remaining, err := app.DB.RefundOrder(models.Refund{
+		OrderID:       order.ID,
+		TransactionID: order.TransactionID,
+		UserID:        user.ID,
+		Amount:        chargeToRefund.Amount,
+		Currency:      order.Transaction.Currency,
+		Reason:        chargeToRefund.Reason,
+	}, func() (string, error) {
+		refund, err := app.Gateway.Refund(order.Transaction.PaymentIntent, chargeToRefund.Amount, idempotencyKey(r, "refund"))
+		if err != nil {
+			return "", err
+		}
+		refunded = true
+		return refund.ID, nil
+	})
 	if err != nil {
-		app.badRequest(w, r, errors.New("the charge was refunded, but the database could not be updated"))
+		var exceeded *models.RefundExceededError
+		switch {
+		case errors.As(err, &exceeded):
+			v.AddError("amount", exceeded.Error())
+			app.failedValidation(w, r, v.Errors)
+		case errors.Is(err, models.ErrIllegalTransition):
+			app.statusConflict(w, err)
+		case refunded:
+			app.errorLog.Println(err)
+			app.badRequest(w, r, errors.New("the charge was refunded, but the database could not be updated"))
+		default:
+			app.errorLog.Println(err)
+			app.badRequest(w, r, err)
+		}
 		return
 	}
 
 	var resp struct {
-		Error   bool   `json:"error"`
-		Message string `json:"message"`
+		Error     bool   `json:"error"`
+		Message   string `json:"message"`
+		StatusID  int    `json:"status_id"`
+		Remaining int    `json:"remaining"`
 	}
 
 	resp.Error = false
 	resp.Message = "Charge refunded"
+	resp.StatusID = orderStatusID
+	resp.Remaining = remaining
 
 	app.writeJSON(w, http.StatusOK, resp)
 }
 
 func (app *application) CancelSubscription(w http.ResponseWriter, r *http.Request) {
 	var subToCancle struct {
-		ID            int    `json:"id"`
-		PaymentIntent string `json:"payment_intent"`
-		Currency      string `json:"currency"`
+		ID int `json:"id"`
 	}
 
 	err := app.readJSON(w, r, &subToCancle)
@@ -682,24 +875,47 @@ func (app *application) CancelSubscription(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	card := cards.Card{
-		Secret: app.config.stripe.secret,
-		Key:    app.config.stripe.key,
+	order, err := app.DB.GetOrderByID(subToCancle.ID)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if !models.CanChangeOrderStatus(order.StatusID, models.OrderCancelled) {
+		app.statusConflict(w, fmt.Errorf("a %s subscription can not be cancelled", strings.ToLower(models.OrderStatusName(order.StatusID))))
+		return
 	}
 
-	err = card.CancelSubscription(subToCancle.PaymentIntent)
+	// the subscription of the order is cancelled, whatever the client thinks it is
+	subID := order.Transaction.PaymentIntent
+	if subID == "" {
+		app.badRequest(w, r, errors.New("the order has no subscription"))
+		return
+	}
+
+	err = app.Gateway.CancelSubscription(subID)
 	if err != nil {
 		app.errorLog.Println(err)
 		app.badRequest(w, r, err)
 		return
 	}
 
-	//update status in database
-	err = app.DB.UpdateOrderStatus(subToCancle.ID, 3)
+	userID := 0
+	if user, err := app.authenticateToken(r); err == nil {
+		userID = user.ID
+	}
+
+	//update status in database, the status history records the cancellation
+	err = app.DB.UpdateOrderStatus(order.ID, models.OrderCancelled, userID, "Cancelled at the end of the billing period")
 	if err != nil {
+		app.errorLog.Println(err)
 		app.badRequest(w, r, errors.New("the subscription was cancel, but the database could not be updated"))
 		return
 	}
+	if err = app.refreshSubscription(subID); err != nil {
+		app.errorLog.Println(err)
+	}
 
 	var resp struct {
 		Error   bool   `json:"error"`
@@ -855,3 +1071,32 @@ func (app *application) EditUser(w http.ResponseWriter, r *http.Request) {
 
 	app.writeJSON(w, http.StatusOK, resp)
 }
+
+// ConfirmFakePaymentIntent confirms a payment intent on the fake gateway, standing in for stripe.js when running offline
+func (app *application) ConfirmFakePaymentIntent(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		PaymentIntent string `json:"payment_intent"`
+		PaymentMethod string `json:"payment_method"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	fake, ok := app.Gateway.(*cards.FakeGateway)
+	if !ok {
+		app.notFound(w, r, "fake gateway is not enabled")
+		return
+	}
+
+	pi, err := fake.ConfirmPaymentIntent(payload.PaymentIntent, payload.PaymentMethod)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, pi)
+}
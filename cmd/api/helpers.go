@@ -1,11 +1,15 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
 
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/cards"
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/models"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -86,6 +90,69 @@ func (app *application) notFound(w http.ResponseWriter, r *http.Request, msg str
 	return nil
 }
 
+// outOfStock tells the client which widget can't be sold
+func (app *application) outOfStock(w http.ResponseWriter, err error) error {
+	var payload struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	payload.Error = true
+	payload.Message = err.Error()
+
+	return app.writeJSON(w, http.StatusConflict, payload)
+}
+
+// statusConflict tells the client the order or transaction can't move to the status it asked for
+func (app *application) statusConflict(w http.ResponseWriter, err error) error {
+	var payload struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	payload.Error = true
+	payload.Message = err.Error()
+
+	return app.writeJSON(w, http.StatusConflict, payload)
+}
+
+// newReservationReference returns a random reference for stock held for a payment intent
+func newReservationReference() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// recordReconciliation marks a payment that was taken but not saved so it can be put right later
+func (app *application) recordReconciliation(paymentIntent string, amount int, currency, email string, cause error, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+
+	_, err = app.DB.InsertPaymentReconciliation(models.PaymentReconciliation{
+		PaymentIntent: paymentIntent,
+		Amount:        amount,
+		Currency:      currency,
+		Email:         email,
+		Reason:        cause.Error(),
+		Payload:       string(payload),
+	})
+	if err != nil {
+		app.errorLog.Printf("payment %s was taken but could not be recorded: %s", paymentIntent, err)
+	}
+}
+
+// paymentError classifies a gateway error and logs it with its codes
+func (app *application) paymentError(err error) *cards.PaymentError {
+	perr := cards.NewPaymentError(err)
+	app.errorLog.Printf("payment error: category=%s code=%s decline_code=%s retryable=%t: %v",
+		perr.Category, perr.Code, perr.DeclineCode, perr.Retryable, err)
+	return perr
+}
+
 func (app *application) invalidCredentials(w http.ResponseWriter) error {
 	var payload struct {
 		Error   bool   `json:"error"`
@@ -1,6 +1,25 @@
 package main
 
-import "net/http"
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/models"
+)
+
+type contextKey string
+
+const idempotencyKeyContextKey = contextKey("idempotency_key")
+
+const idempotencyKeyTTL = 24 * time.Hour
 
 func (app *application) Auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -12,3 +31,127 @@ func (app *application) Auth(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// idempotencyRecorder keeps a copy of the response so it can be replayed
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// Idempotent honours the Idempotency-Key header, the first response for a key and user
+// is stored and replayed for repeats with the same body
+func (app *application) Idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > 255 {
+			app.badRequest(w, r, errors.New("idempotency key must be at most 255 characters"))
+			return
+		}
+
+		userID := 0
+		if user, err := app.authenticateToken(r); err == nil {
+			userID = user.ID
+		}
+
+		maxBytes := 1048576
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxBytes)))
+		if err != nil {
+			app.badRequest(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		sum := sha256.Sum256(body)
+		requestHash := hex.EncodeToString(sum[:])
+
+		reserved, err := app.DB.InsertIdempotencyKey(models.IdempotencyKey{
+			Key:         key,
+			UserID:      userID,
+			RequestHash: requestHash,
+			ExpiresAt:   time.Now().Add(idempotencyKeyTTL),
+		})
+		if err != nil {
+			app.errorLog.Println(err)
+			app.badRequest(w, r, err)
+			return
+		}
+
+		if !reserved {
+			stored, err := app.DB.GetIdempotencyKey(key, userID)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					err = errors.New("idempotency key expired while in use, retry the request")
+				}
+				app.errorLog.Println(err)
+				app.badRequest(w, r, err)
+				return
+			}
+
+			var resp struct {
+				Error   bool   `json:"error"`
+				Message string `json:"message"`
+			}
+			resp.Error = true
+
+			switch {
+			case stored.RequestHash != requestHash:
+				resp.Message = "Idempotency key was already used with a different request body"
+				app.writeJSON(w, http.StatusUnprocessableEntity, resp)
+			case stored.StatusCode == 0:
+				resp.Message = "A request with this idempotency key is still in progress"
+				app.writeJSON(w, http.StatusConflict, resp)
+			default:
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(stored.StatusCode)
+				w.Write(stored.ResponseBody)
+			}
+			return
+		}
+
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		ctx := context.WithValue(r.Context(), idempotencyKeyContextKey, fmt.Sprintf("%d:%s", userID, key))
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		if rec.status == 0 || rec.status >= http.StatusInternalServerError {
+			// nothing worth replaying, let the client retry
+			if err := app.DB.DeleteIdempotencyKey(key, userID); err != nil {
+				app.errorLog.Println(err)
+			}
+			return
+		}
+
+		if err := app.DB.SaveIdempotencyResponse(key, userID, rec.status, rec.body.Bytes()); err != nil {
+			app.errorLog.Println(err)
+		}
+	})
+}
+
+// idempotencyKey returns the gateway idempotency key for the request and the step, or
+// an empty string when the client did not send one
+func idempotencyKey(r *http.Request, step string) string {
+	key, ok := r.Context().Value(idempotencyKeyContextKey).(string)
+	if !ok || key == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%s", key, step)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/models"
+)
+
+// plan is a plan as the catalog shows it
+type plan struct {
+	models.Widget
+	BillingPeriod string               `json:"billing_period"`
+	Prices        []models.WidgetPrice `json:"prices"`
+}
+
+// AllPlans returns the plans that can be subscribed to with their prices in every currency,
+// a plan is a recurring widget so a new one only needs a row in widgets
+func (app *application) AllPlans(w http.ResponseWriter, r *http.Request) {
+	widgets, err := app.DB.GetPlans()
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+
+	plans := []plan{}
+	for _, widget := range widgets {
+		prices, err := app.DB.GetWidgetPrices(*widget)
+		if err != nil {
+			app.errorLog.Println(err)
+			app.badRequest(w, r, err)
+			return
+		}
+		plans = append(plans, plan{
+			Widget:        *widget,
+			BillingPeriod: widget.BillingPeriod(),
+			Prices:        prices,
+		})
+	}
+
+	app.writeJSON(w, http.StatusOK, plans)
+}
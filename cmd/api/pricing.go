@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/currency"
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/models"
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/validator"
+)
+
+// pricedOrder is an order priced on the server, amounts are in minor units of the currency
+type pricedOrder struct {
+	Currency  string
+	Items     []*models.OrderItem
+	PlanID    string
+	TrialDays int
+	Subtotal  int
+	Coupon    *models.Coupon
+	Discount  int
+	Total     int
+}
+
+// metadata returns the payment intent metadata for the order
+func (p pricedOrder) metadata() map[string]string {
+	metadata := map[string]string{
+		"items": models.FormatItemsMetadata(p.Items),
+	}
+	if p.Coupon != nil {
+		metadata["coupon"] = p.Coupon.Code
+		metadata["discount"] = strconv.Itoa(p.Discount)
+	}
+	return metadata
+}
+
+// priceOrder prices the lines of a payload and applies its coupon, never trust the amount from the browser.
+// Problems the client can fix are added to v, err is only set when the order could not be priced.
+// A subscription is priced when recurring is true, its plan is the only line.
+func (app *application) priceOrder(payload stripePayload, recurring bool, v *validator.Validator) (pricedOrder, error) {
+	var p pricedOrder
+
+	p.Currency = strings.ToLower(payload.Currency)
+	if p.Currency == "" {
+		p.Currency = currency.DefaultCurrency
+	}
+
+	lines := payload.Items
+	if payload.ProductID != "" && len(lines) == 0 {
+		productID, _ := strconv.Atoi(payload.ProductID)
+		lines = []cartItem{{ProductID: productID, Quantity: payload.Quantity}}
+	}
+
+	v.Check(len(lines) > 0, "items", "must have at least one line")
+	v.Check(len(lines) <= models.MaxOrderItems, "items", fmt.Sprintf("must have at most %d lines", models.MaxOrderItems))
+	v.Check(!recurring || len(lines) == 1, "items", "a subscription is for one plan")
+	v.Check(currency.Valid(p.Currency), "currency", "is not supported")
+	for i := range lines {
+		if lines[i].Quantity == 0 {
+			lines[i].Quantity = 1
+		}
+		v.Check(lines[i].ProductID > 0, "product_id", "must be a valid product")
+		v.Check(lines[i].Quantity > 0 && lines[i].Quantity <= 100, "quantity", "must be between 1 and 100")
+		v.Check(!recurring || lines[i].Quantity == 1, "quantity", "a subscription is for one plan")
+	}
+	if !v.Valid() {
+		return p, nil
+	}
+
+	for _, line := range lines {
+		widget, err := app.DB.GetWidget(line.ProductID)
+		if err != nil {
+			app.errorLog.Println(err)
+			v.AddError("product_id", "must be a valid product")
+			return p, nil
+		}
+
+		if widget.IsRecurring != recurring {
+			if recurring {
+				v.AddError("product_id", "must be a subscription plan")
+			} else {
+				v.AddError("product_id", "plans must be bought with a subscription")
+			}
+			return p, nil
+		}
+
+		price, err := app.DB.GetWidgetPrice(widget, p.Currency)
+		if err != nil {
+			if errors.Is(err, models.ErrNoPrice) {
+				v.AddError("currency", fmt.Sprintf("%s is not sold in this currency", widget.Name))
+				return p, nil
+			}
+			return p, err
+		}
+
+		if recurring {
+			if price.PlanID == "" {
+				v.AddError("currency", fmt.Sprintf("%s is not sold in this currency", widget.Name))
+				return p, nil
+			}
+			p.PlanID = price.PlanID
+			p.TrialDays = widget.TrialDays
+		}
+
+		p.Items = append(p.Items, &models.OrderItem{
+			WidgetID:  widget.ID,
+			Quantity:  line.Quantity,
+			UnitPrice: price.Price,
+			Amount:    price.Price * line.Quantity,
+			Widget:    widget,
+		})
+		p.Subtotal += price.Price * line.Quantity
+	}
+	p.Total = p.Subtotal
+
+	if strings.TrimSpace(payload.Coupon) == "" {
+		return p, nil
+	}
+
+	coupon, err := app.DB.ValidateCoupon(payload.Coupon, payload.Email)
+	if err != nil {
+		if isCouponError(err) {
+			v.AddError("coupon", err.Error())
+			return p, nil
+		}
+		return p, err
+	}
+
+	if recurring && coupon.GatewayCouponID == "" {
+		v.AddError("coupon", models.ErrCouponNotApplicable.Error())
+		return p, nil
+	}
+
+	discount, err := coupon.Discount(p.Items, p.Currency)
+	if err != nil {
+		v.AddError("coupon", err.Error())
+		return p, nil
+	}
+
+	p.Coupon = &coupon
+	p.Discount = discount
+	p.Total = p.Subtotal - discount
+	return p, nil
+}
+
+func isCouponError(err error) bool {
+	return errors.Is(err, models.ErrCouponNotFound) ||
+		errors.Is(err, models.ErrCouponExpired) ||
+		errors.Is(err, models.ErrCouponExhausted) ||
+		errors.Is(err, models.ErrCouponLimitReached) ||
+		errors.Is(err, models.ErrCouponNotApplicable) ||
+		errors.Is(err, models.ErrCouponFreeOrder)
+}
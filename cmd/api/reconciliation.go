@@ -0,0 +1,272 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/currency"
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/models"
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/reconcile"
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/validator"
+	"github.com/stripe/stripe-go"
+)
+
+// StartReconciliation compares the transactions of a date range with the gateway in the background,
+// the report is read with LastReconciliation
+func (app *application) StartReconciliation(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	from, err := time.Parse("2006-01-02", payload.From)
+	v.Check(err == nil, "from", "must be a date like 2006-01-02")
+	to, err := time.Parse("2006-01-02", payload.To)
+	v.Check(err == nil, "to", "must be a date like 2006-01-02")
+	if v.Valid() {
+		v.Check(!to.Before(from), "to", "must not be before from")
+	}
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	report := models.ReconciliationReport{
+		DateFrom: from,
+		// the end date is included
+		DateTo: to.AddDate(0, 0, 1),
+		Status: models.ReconciliationRunning,
+	}
+	report.ID, err = app.DB.InsertReconciliationReport(report)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+
+	go app.runReconciliation(report)
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+		ID      int    `json:"id"`
+	}
+	resp.Error = false
+	resp.Message = "Reconciliation started"
+	resp.ID = report.ID
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// LastReconciliation returns the last report as JSON, or its issues as CSV with ?format=csv
+func (app *application) LastReconciliation(w http.ResponseWriter, r *http.Request) {
+	report, err := app.DB.GetLastReconciliationReport()
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.notFound(w, r, "no reconciliation report yet")
+			return
+		}
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+
+	issues := []reconcile.Issue{}
+	if len(report.Issues) > 0 {
+		if err := json.Unmarshal(report.Issues, &issues); err != nil {
+			app.errorLog.Println(err)
+			app.badRequest(w, r, err)
+			return
+		}
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"reconciliation-%d.csv\"", report.ID))
+		if err := reconcile.WriteCSV(w, issues); err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	resp := struct {
+		models.ReconciliationReport
+		Issues []reconcile.Issue `json:"issues"`
+	}{
+		ReconciliationReport: report,
+		Issues:               issues,
+	}
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// runReconciliation fills in the report, a failure is saved on the report
+func (app *application) runReconciliation(report models.ReconciliationReport) {
+	matched, issues, err := app.reconcile(report.DateFrom, report.DateTo)
+	if err != nil {
+		app.errorLog.Println(err)
+		report.Status = models.ReconciliationFailed
+		report.Error = err.Error()
+		if err := app.DB.UpdateReconciliationReport(report); err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	report.Status = models.ReconciliationCompleted
+	report.Matched = matched
+	for _, i := range issues {
+		switch i.Type {
+		case reconcile.IssueMissing:
+			report.Missing++
+		case reconcile.IssueExtra:
+			report.Extra++
+		case reconcile.IssueAmountMismatch:
+			report.Mismatched++
+		}
+	}
+
+	report.Issues, err = json.Marshal(issues)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+
+	if err := app.DB.UpdateReconciliationReport(report); err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+	app.infoLog.Printf("reconciliation %d: %d matched, %d missing, %d extra, %d mismatched",
+		report.ID, report.Matched, report.Missing, report.Extra, report.Mismatched)
+}
+
+// reconcile compares what the gateway settled in the range with our transactions and refunds
+func (app *application) reconcile(from, to time.Time) (int, []reconcile.Issue, error) {
+	gateway, err := app.gatewayRecords(from, to)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	local, err := app.localRecords(from, to)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	matched, issues := reconcile.Compare(local, gateway)
+	return matched, issues, nil
+}
+
+// gatewayRecords reads the captured charges, refunds and subscriptions of the range from the gateway,
+// charges of subscription invoices are left out as the subscription is matched instead
+func (app *application) gatewayRecords(from, to time.Time) ([]reconcile.Record, error) {
+	var records []reconcile.Record
+
+	charges, err := app.Gateway.ListCharges(from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range charges {
+		if c.Status != "succeeded" || !c.Captured || c.Invoice != nil {
+			continue
+		}
+		reference := c.PaymentIntent
+		if reference == "" {
+			reference = c.ID
+		}
+		records = append(records, reconcile.Record{
+			Kind:         reconcile.KindCharge,
+			Reference:    reference,
+			AltReference: c.ID,
+			Amount:       int(c.Amount),
+			Currency:     string(c.Currency),
+		})
+	}
+
+	refunds, err := app.Gateway.ListRefunds(from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range refunds {
+		if r.Status == stripe.RefundStatusFailed || r.Status == stripe.RefundStatusCanceled {
+			continue
+		}
+		records = append(records, reconcile.Record{
+			Kind:      reconcile.KindRefund,
+			Reference: r.ID,
+			Amount:    int(r.Amount),
+			Currency:  string(r.Currency),
+		})
+	}
+
+	subscriptions, err := app.Gateway.ListSubscriptions(from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range subscriptions {
+		if s.Status == stripe.SubscriptionStatusIncompleteExpired {
+			// never paid
+			continue
+		}
+		records = append(records, reconcile.Record{
+			Kind:      reconcile.KindSubscription,
+			Reference: s.ID,
+		})
+	}
+
+	return records, nil
+}
+
+// localRecords reads the settled transactions and refunds of the range, subscriptions are
+// matched by id only as their amount changes with plan changes and coupons
+func (app *application) localRecords(from, to time.Time) ([]reconcile.Record, error) {
+	var records []reconcile.Record
+
+	txns, err := app.DB.GetSettledTransactions(from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range txns {
+		if t.Recurring {
+			records = append(records, reconcile.Record{
+				Kind:      reconcile.KindSubscription,
+				Reference: t.PaymentIntent,
+			})
+			continue
+		}
+
+		code := t.Currency
+		if code == "" {
+			code = currency.DefaultCurrency
+		}
+		records = append(records, reconcile.Record{
+			Kind:         reconcile.KindCharge,
+			Reference:    t.PaymentIntent,
+			AltReference: t.BankReturnCode,
+			Amount:       t.Amount,
+			Currency:     code,
+		})
+	}
+
+	refunds, err := app.DB.GetRefundsBetween(from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range refunds {
+		records = append(records, reconcile.Record{
+			Kind:      reconcile.KindRefund,
+			Reference: r.GatewayRefundID,
+			Amount:    r.Amount,
+			Currency:  r.Currency,
+		})
+	}
+
+	return records, nil
+}
@@ -13,17 +13,26 @@ func (app *application) routes() http.Handler {
 	mux.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "C-CSRF-Token"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "C-CSRF-Token", "Idempotency-Key"},
 		AllowCredentials: false,
 		MaxAge:           300,
 	}))
-	mux.Post("/api/payment-intent", app.GetPaymentIntent)
+	mux.With(app.Idempotent).Post("/api/payment-intent", app.GetPaymentIntent)
+	mux.Post("/api/payment-intent/confirm", app.ConfirmPaymentIntent)
 	mux.Get("/api/widget/{id}", app.GetWidgetByID)
-	mux.Post("/api/create-customer-and-subscribe-to-plan", app.CreateCustomerAndSubscribeToPlan)
+	mux.Get("/api/plans", app.AllPlans)
+	mux.With(app.Idempotent).Post("/api/create-customer-and-subscribe-to-plan", app.CreateCustomerAndSubscribeToPlan)
 	mux.Post("/api/authenticate", app.CreateAuthToken)
 	mux.Post("/api/is-autheticated", app.CheckAuthentication)
 	mux.Post("/api/forget-password", app.SendPasswordResetEmail)
 	mux.Post("/api/reset-password", app.ResetPassword)
+	mux.Post("/api/webhooks/stripe", app.StripeWebhook)
+	mux.Post("/api/coupons/redeem", app.RedeemCoupon)
+	mux.Post("/api/update-card", app.UpdateCard)
+
+	if app.config.gateway == "fake" {
+		mux.Post("/api/fake/confirm-payment-intent", app.ConfirmFakePaymentIntent)
+	}
 
 	mux.Route("/api/admin", func(mux chi.Router) {
 		mux.Use(app.Auth)
@@ -31,16 +40,42 @@ func (app *application) routes() http.Handler {
 		mux.Get("/test", func(w http.ResponseWriter, r *http.Request) {
 			w.Write([]byte("Loggin"))
 		})
-		mux.Post("/virtual-terminal-succeeded", app.VirtualTerminalPaymentSucceeded)
+		mux.With(app.Idempotent).Post("/virtual-terminal-succeeded", app.VirtualTerminalPaymentSucceeded)
 		mux.Post("/all-sales", app.AllSales)
 		mux.Post("/all-subscription", app.AllSucription)
 		mux.Post("/get-sale/{id}", app.GetSale)
-		mux.Post("/refund", app.RefundCharge)
+		mux.With(app.Idempotent).Post("/refund", app.RefundCharge)
+		mux.Post("/authorizations", app.AllAuthorizations)
+		mux.With(app.Idempotent).Post("/capture-authorization", app.CaptureAuthorization)
+		mux.Post("/cancel-authorization", app.CancelAuthorization)
 		mux.Post("/cancel-subscription", app.CancelSubscription)
+		mux.With(app.Idempotent).Post("/change-subscription-plan", app.ChangeSubscriptionPlan)
+		mux.Post("/pause-subscription", app.PauseSubscription)
+		mux.Post("/resume-subscription", app.ResumeSubscription)
+		mux.Post("/sync-subscription", app.SyncSubscription)
+		mux.Post("/extend-trial", app.ExtendTrial)
+		mux.Post("/end-trial", app.EndTrial)
+		mux.Get("/subscriptions/{id}/usage", app.CurrentUsage)
+		mux.Post("/subscriptions/{id}/usage", app.RecordUsage)
 		mux.Post("/all-users", app.AllUsers)
 		mux.Post("/all-users/{id}", app.DetailUser)
 		mux.Post("/all-users/edit/{id}", app.EditUser)
 		mux.Post("/all-users/delete/{id}", app.DeleteUser)
+
+		mux.Get("/coupons", app.AllCoupons)
+		mux.Post("/coupons", app.CreateCoupon)
+		mux.Get("/coupons/{id}", app.GetCoupon)
+		mux.Put("/coupons/{id}", app.UpdateCoupon)
+		mux.Delete("/coupons/{id}", app.DeleteCoupon)
+
+		mux.Post("/reconciliation", app.StartReconciliation)
+		mux.Get("/reconciliation/latest", app.LastReconciliation)
+
+		mux.Post("/revenue-report", app.RevenueReport)
+
+		mux.Get("/disputes", app.AllDisputes)
+		mux.Get("/disputes/{id}", app.GetDispute)
+		mux.Post("/disputes/{id}/evidence", app.SubmitDisputeEvidence)
 	})
 	return mux
 }
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/models"
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/validator"
+	"github.com/stripe/stripe-go"
+)
+
+// settlementWindow is how far back transactions are still checked for a settlement, a charge
+// the gateway has not settled by then is left for reconciliation
+const settlementWindow = 30 * 24 * time.Hour
+
+// settlementBatch is how many transactions or invoices are read at once
+const settlementBatch = 100
+
+// runSettlements fetches the fee and net amount of new transactions and subscription invoices from
+// the gateway on every tick, those the gateway has not settled yet are tried again on the next one
+func (app *application) runSettlements() {
+	ticker := time.NewTicker(app.config.settlementInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		since := time.Now().Add(-settlementWindow)
+		app.settleTransactions(since)
+		app.settleInvoices(since)
+	}
+}
+
+// settleTransactions goes through the unsettled transactions a page at a time, so charges that
+// are not settled yet don't keep the newer ones from being read
+func (app *application) settleTransactions(since time.Time) {
+	afterID := 0
+	for {
+		txns, err := app.DB.GetUnsettledTransactions(since, afterID, settlementBatch)
+		if err != nil {
+			app.errorLog.Println(err)
+			return
+		}
+
+		for _, t := range txns {
+			afterID = t.ID
+			s, err := app.settlement(t.PaymentIntent)
+			if err == nil && s != nil {
+				err = app.DB.SaveSettlement(t.ID, *s)
+			} else {
+				if err != nil {
+					app.errorLog.Printf("settlement of transaction %d: %v", t.ID, err)
+				}
+				err = app.DB.AddSettlementAttempt(t.ID)
+			}
+			if err != nil {
+				app.errorLog.Printf("settlement of transaction %d: %v", t.ID, err)
+			}
+		}
+
+		if len(txns) < settlementBatch {
+			return
+		}
+	}
+}
+
+// settleInvoices goes through the unsettled invoices of subscriptions a page at a time
+func (app *application) settleInvoices(since time.Time) {
+	afterID := 0
+	for {
+		invoices, err := app.DB.GetUnsettledInvoices(since, afterID, settlementBatch)
+		if err != nil {
+			app.errorLog.Println(err)
+			return
+		}
+
+		for _, inv := range invoices {
+			afterID = inv.ID
+			s, err := app.settlement(inv.InvoiceID)
+			if err == nil && s != nil {
+				err = app.DB.SaveInvoiceSettlement(inv.ID, *s)
+			} else {
+				if err != nil {
+					app.errorLog.Printf("settlement of invoice %s: %v", inv.InvoiceID, err)
+				}
+				err = app.DB.AddInvoiceSettlementAttempt(inv.ID)
+			}
+			if err != nil {
+				app.errorLog.Printf("settlement of invoice %s: %v", inv.InvoiceID, err)
+			}
+		}
+
+		if len(invoices) < settlementBatch {
+			return
+		}
+	}
+}
+
+// settlement returns the balance transaction of a payment intent or invoice, nil while the gateway
+// has not settled the charge yet
+func (app *application) settlement(reference string) (*models.Settlement, error) {
+	bt, err := app.Gateway.GetSettlement(reference)
+	if err != nil || bt == nil {
+		return nil, err
+	}
+
+	// the gateway leaves the rate out when the charge was not converted
+	rate := bt.ExchangeRate
+	if rate == 0 {
+		rate = 1
+	}
+
+	return &models.Settlement{
+		BalanceTransaction: bt.ID,
+		Fee:                int(bt.Fee),
+		Net:                int(bt.Net),
+		Currency:           string(bt.Currency),
+		ExchangeRate:       rate,
+		AvailableOn:        time.Unix(bt.AvailableOn, 0),
+	}, nil
+}
+
+// recordInvoice keeps a paid invoice of a subscription to settle it, the first one and every renewal
+// are charged separately. An invoice with nothing to pay, e.g. of a trial, has no charge to settle.
+func (app *application) recordInvoice(invoice stripe.Invoice) error {
+	if invoice.AmountPaid == 0 {
+		return nil
+	}
+
+	_, txnID, err := app.DB.GetOrderIDByPaymentIntent(invoice.Subscription.ID)
+	if err != nil {
+		// the order of a new subscription may not be saved yet, the gateway sends the event again
+		return fmt.Errorf("invoice %s of subscription %s: %w", invoice.ID, invoice.Subscription.ID, err)
+	}
+
+	paidAt := time.Unix(invoice.StatusTransitions.PaidAt, 0)
+	if invoice.StatusTransitions.PaidAt == 0 {
+		paidAt = time.Unix(invoice.Created, 0)
+	}
+
+	return app.DB.InsertInvoiceSettlement(models.InvoiceSettlement{
+		TransactionID: txnID,
+		InvoiceID:     invoice.ID,
+		Amount:        int(invoice.AmountPaid),
+		Currency:      string(invoice.Currency),
+		PaidAt:        paidAt,
+	})
+}
+
+// RevenueReport returns the gross, fees and net of the settled transactions by day or month
+func (app *application) RevenueReport(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		From  string `json:"from"`
+		To    string `json:"to"`
+		Group string `json:"group"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	from, err := time.Parse("2006-01-02", payload.From)
+	v.Check(err == nil, "from", "must be a date like 2006-01-02")
+	to, err := time.Parse("2006-01-02", payload.To)
+	v.Check(err == nil, "to", "must be a date like 2006-01-02")
+	if v.Valid() {
+		v.Check(!to.Before(from), "to", "must not be before from")
+	}
+	v.Check(payload.Group == "" || payload.Group == "day" || payload.Group == "month", "group", "must be day or month")
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	// the end date is included
+	rows, err := app.DB.GetRevenueReport(from, to.AddDate(0, 0, 1), payload.Group == "month")
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var resp struct {
+		Rows   []*models.RevenueReportRow `json:"rows"`
+		Totals []*models.RevenueReportRow `json:"totals"`
+	}
+	resp.Rows = rows
+	resp.Totals = revenueTotals(rows)
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// revenueTotals sums the rows of the report by settlement currency
+func revenueTotals(rows []*models.RevenueReportRow) []*models.RevenueReportRow {
+	totals := []*models.RevenueReportRow{}
+	byCurrency := make(map[string]*models.RevenueReportRow)
+
+	for _, row := range rows {
+		t, ok := byCurrency[row.Currency]
+		if !ok {
+			t = &models.RevenueReportRow{Period: "total", Currency: row.Currency}
+			byCurrency[row.Currency] = t
+			totals = append(totals, t)
+		}
+		t.Transactions += row.Transactions
+		t.Gross += row.Gross
+		t.Fees += row.Fees
+		t.Net += row.Net
+	}
+
+	for _, t := range totals {
+		if t.Gross > 0 {
+			t.FeeRate = float64(t.Fees) / float64(t.Gross)
+		}
+	}
+	return totals
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/models"
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/validator"
+	"github.com/stripe/stripe-go"
+)
+
+// subscriptionRequest is the body of the subscription admin actions
+type subscriptionRequest struct {
+	ID       int `json:"id"`
+	WidgetID int `json:"widget_id"`
+	Days     int `json:"days"`
+}
+
+// subscriptionFromGateway maps a gateway subscription onto the local subscription state
+func subscriptionFromGateway(s *stripe.Subscription) models.Subscription {
+	sub := models.Subscription{
+		GatewaySubscriptionID: s.ID,
+		Status:                string(s.Status),
+		CurrentPeriodStart:    time.Unix(s.CurrentPeriodStart, 0),
+		CurrentPeriodEnd:      time.Unix(s.CurrentPeriodEnd, 0),
+		CancelAtPeriodEnd:     s.CancelAtPeriodEnd,
+		Paused:                s.PauseCollection.Behavior != "",
+	}
+	if s.Plan != nil {
+		sub.GatewayPlanID = s.Plan.ID
+	}
+	if s.TrialEnd > 0 {
+		t := time.Unix(s.TrialEnd, 0)
+		sub.TrialEnd = &t
+	}
+	if s.CanceledAt > 0 {
+		t := time.Unix(s.CanceledAt, 0)
+		sub.CanceledAt = &t
+	}
+	return sub
+}
+
+// syncSubscription saves the gateway state of a subscription for the order it was sold with,
+// subscriptions without an order are ignored
+func (app *application) syncSubscription(s *stripe.Subscription) error {
+	orderID, _, err := app.DB.GetOrderIDByPaymentIntent(s.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	order, err := app.DB.GetOrderByID(orderID)
+	if err != nil {
+		return err
+	}
+
+	sub := subscriptionFromGateway(s)
+	sub.OrderID = order.ID
+	sub.CustomerID = order.CustomerID
+	sub.WidgetID = order.WidgetID
+	return app.DB.SaveSubscription(sub)
+}
+
+// refreshSubscription reads a subscription from the gateway and saves its state
+func (app *application) refreshSubscription(subID string) error {
+	s, err := app.Gateway.GetSubscription(subID)
+	if err != nil {
+		return err
+	}
+	return app.syncSubscription(s)
+}
+
+// subscriptionPaused reports whether payment collection is paused, orders without a local
+// subscription use the last pause or resume in the history
+func subscriptionPaused(order models.Order) bool {
+	if order.Subscription != nil {
+		return order.Subscription.Paused
+	}
+
+	paused := false
+	for _, h := range order.History {
+		switch h.Action {
+		case models.OrderHistoryPaused:
+			paused = true
+		case models.OrderHistoryResumed:
+			paused = false
+		}
+	}
+	return paused
+}
+
+// readSubscription reads the request and loads the subscription order it is for,
+// the order must be a subscription that is not cancelled
+func (app *application) readSubscription(w http.ResponseWriter, r *http.Request) (subscriptionRequest, models.Order, *models.User, bool) {
+	var req subscriptionRequest
+	var order models.Order
+
+	if err := app.readJSON(w, r, &req); err != nil {
+		app.badRequest(w, r, err)
+		return req, order, nil, false
+	}
+
+	user, err := app.authenticateToken(r)
+	if err != nil {
+		app.invalidCredentials(w)
+		return req, order, nil, false
+	}
+
+	order, err = app.DB.GetOrderByID(req.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.notFound(w, r, "subscription not found")
+			return req, order, nil, false
+		}
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return req, order, nil, false
+	}
+
+	widget, err := app.DB.GetWidget(order.WidgetID)
+	if err != nil || !widget.IsRecurring {
+		app.notFound(w, r, "subscription not found")
+		return req, order, nil, false
+	}
+	order.Widget = widget
+
+	if order.StatusID != models.OrderCharged {
+		app.badRequest(w, r, errors.New("the subscription is cancelled"))
+		return req, order, nil, false
+	}
+
+	return req, order, user, true
+}
+
+// ChangeSubscriptionPlan upgrades or downgrades a subscription to another plan, the gateway
+// prorates the rest of the current period
+func (app *application) ChangeSubscriptionPlan(w http.ResponseWriter, r *http.Request) {
+	req, order, user, ok := app.readSubscription(w, r)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+	plan, err := app.DB.GetWidget(req.WidgetID)
+	if err != nil || !plan.IsRecurring {
+		v.AddError("widget_id", "must be a plan")
+	}
+	v.Check(req.WidgetID != order.WidgetID, "widget_id", "is the current plan")
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	price, err := app.DB.GetWidgetPrice(plan, order.Transaction.Currency)
+	if err != nil || price.PlanID == "" {
+		v.AddError("widget_id", fmt.Sprintf("is not sold in %s", order.Transaction.Currency))
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	subscription, err := app.Gateway.ChangeSubscriptionPlan(order.Transaction.PaymentIntent, price.PlanID, idempotencyKey(r, "change-plan"))
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+
+	err = app.DB.ChangeOrderPlan(order.ID, plan, price.Price, models.OrderHistory{
+		UserID:      user.ID,
+		Action:      models.OrderHistoryPlanChanged,
+		Description: fmt.Sprintf("Changed from %s to %s", order.Widget.Name, plan.Name),
+	})
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, errors.New("the plan was changed, but the database could not be updated"))
+		return
+	}
+	if err = app.syncSubscription(subscription); err != nil {
+		app.errorLog.Println(err)
+	}
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+	resp.Error = false
+	resp.Message = fmt.Sprintf("Subscription changed to %s", plan.Name)
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// PauseSubscription stops collecting payments for a subscription
+func (app *application) PauseSubscription(w http.ResponseWriter, r *http.Request) {
+	_, order, user, ok := app.readSubscription(w, r)
+	if !ok {
+		return
+	}
+
+	if subscriptionPaused(order) {
+		app.badRequest(w, r, errors.New("the subscription is already paused"))
+		return
+	}
+
+	subscription, err := app.Gateway.PauseSubscription(order.Transaction.PaymentIntent)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+
+	_, err = app.DB.InsertOrderHistory(models.OrderHistory{
+		OrderID:     order.ID,
+		UserID:      user.ID,
+		Action:      models.OrderHistoryPaused,
+		Description: "Payment collection paused",
+	})
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, errors.New("the subscription was paused, but the database could not be updated"))
+		return
+	}
+	if err = app.syncSubscription(subscription); err != nil {
+		app.errorLog.Println(err)
+	}
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+	resp.Error = false
+	resp.Message = "Subscription paused"
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// ResumeSubscription collects payments for a paused subscription again
+func (app *application) ResumeSubscription(w http.ResponseWriter, r *http.Request) {
+	_, order, user, ok := app.readSubscription(w, r)
+	if !ok {
+		return
+	}
+
+	if !subscriptionPaused(order) {
+		app.badRequest(w, r, errors.New("the subscription is not paused"))
+		return
+	}
+
+	subscription, err := app.Gateway.ResumeSubscription(order.Transaction.PaymentIntent)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+
+	_, err = app.DB.InsertOrderHistory(models.OrderHistory{
+		OrderID:     order.ID,
+		UserID:      user.ID,
+		Action:      models.OrderHistoryResumed,
+		Description: "Payment collection resumed",
+	})
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, errors.New("the subscription was resumed, but the database could not be updated"))
+		return
+	}
+	if err = app.syncSubscription(subscription); err != nil {
+		app.errorLog.Println(err)
+	}
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+	resp.Error = false
+	resp.Message = "Subscription resumed"
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// SyncSubscription reads the state of a subscription from the gateway and saves it
+func (app *application) SyncSubscription(w http.ResponseWriter, r *http.Request) {
+	var req subscriptionRequest
+	if err := app.readJSON(w, r, &req); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	order, err := app.DB.GetOrderByID(req.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.notFound(w, r, "subscription not found")
+			return
+		}
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err = app.refreshSubscription(order.Transaction.PaymentIntent); err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+
+	order.Subscription, err = app.DB.GetSubscriptionByOrderID(order.ID)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, order.Subscription)
+}
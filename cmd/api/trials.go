@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/currency"
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/models"
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/validator"
+)
+
+// maxTrialExtension is the most days a trial can be extended by at once
+const maxTrialExtension = 90
+
+// trialEmail is the data of the trial email templates
+type trialEmail struct {
+	Name     string
+	Plan     string
+	Amount   string
+	TrialEnd string
+}
+
+// inTrial reports whether the subscription of an order is in its free trial
+func inTrial(order models.Order) bool {
+	return order.Subscription != nil && order.Subscription.Status == models.SubscriptionTrialing &&
+		order.Subscription.TrialEnd != nil
+}
+
+// runTrialReminders tells customers their trial is about to end every interval until the application stops
+func (app *application) runTrialReminders() {
+	ticker := time.NewTicker(app.config.trial.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		trials, err := app.DB.GetTrialsEndingBefore(time.Now().Add(app.config.trial.reminder))
+		if err != nil {
+			app.errorLog.Println(err)
+			continue
+		}
+
+		for _, s := range trials {
+			app.remindTrialEnding(s)
+		}
+	}
+}
+
+// remindTrialEnding emails the customer that the trial converts to a paid subscription, a
+// reminder that could not be sent is tried again on the next run
+func (app *application) remindTrialEnding(s *models.Subscription) {
+	order, err := app.DB.GetOrderByID(s.OrderID)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+
+	data := trialEmail{
+		Name:     order.Customer.FirstName,
+		Plan:     order.Widget.Name,
+		Amount:   currency.Format(order.Amount, order.Transaction.Currency),
+		TrialEnd: s.TrialEnd.Format("January 2, 2006"),
+	}
+	if err := app.SendEmail("info@widgets.com", order.Customer.Email, "Your free trial ends soon", "trial-ending", data); err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+
+	if err := app.DB.MarkTrialReminderSent(s.ID); err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// ExtendTrial moves the end of the trial of a subscription by a number of days
+func (app *application) ExtendTrial(w http.ResponseWriter, r *http.Request) {
+	req, order, user, ok := app.readSubscription(w, r)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+	v.Check(inTrial(order), "id", "the subscription is not in a trial")
+	v.Check(req.Days > 0 && req.Days <= maxTrialExtension, "days", fmt.Sprintf("must be between 1 and %d", maxTrialExtension))
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	trialEnd := order.Subscription.TrialEnd.AddDate(0, 0, req.Days)
+	subscription, err := app.Gateway.ExtendTrial(order.Transaction.PaymentIntent, trialEnd)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+
+	_, err = app.DB.InsertOrderHistory(models.OrderHistory{
+		OrderID:     order.ID,
+		UserID:      user.ID,
+		Action:      models.OrderHistoryTrialExtended,
+		Description: fmt.Sprintf("Trial extended by %d days to %s", req.Days, trialEnd.Format("2006-01-02")),
+	})
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, errors.New("the trial was extended, but the database could not be updated"))
+		return
+	}
+	if err = app.syncSubscription(subscription); err != nil {
+		app.errorLog.Println(err)
+	}
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+	resp.Error = false
+	resp.Message = fmt.Sprintf("Trial extended to %s", trialEnd.Format("January 2, 2006"))
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// EndTrial ends the trial of a subscription now, the gateway collects the first invoice right away
+func (app *application) EndTrial(w http.ResponseWriter, r *http.Request) {
+	_, order, user, ok := app.readSubscription(w, r)
+	if !ok {
+		return
+	}
+
+	if !inTrial(order) {
+		v := validator.New()
+		v.AddError("id", "the subscription is not in a trial")
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	subscription, err := app.Gateway.EndTrial(order.Transaction.PaymentIntent)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+
+	_, err = app.DB.InsertOrderHistory(models.OrderHistory{
+		OrderID:     order.ID,
+		UserID:      user.ID,
+		Action:      models.OrderHistoryTrialEnded,
+		Description: "Trial ended early",
+	})
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, errors.New("the trial was ended, but the database could not be updated"))
+		return
+	}
+	if err = app.syncSubscription(subscription); err != nil {
+		app.errorLog.Println(err)
+	}
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+	resp.Error = false
+	resp.Message = "Trial ended, the first invoice is being collected"
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
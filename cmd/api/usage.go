@@ -0,0 +1,231 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/models"
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/validator"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxUsageQuantity is the most usage one event can add
+const maxUsageQuantity = 1000000
+
+// usageClockSkew is how far in the future a usage event may be dated, for clocks that run ahead
+const usageClockSkew = 5 * time.Minute
+
+// usagePayload is the body of a usage event, occurred at defaults to now
+type usagePayload struct {
+	EventID    string `json:"event_id"`
+	Quantity   int    `json:"quantity"`
+	OccurredAt string `json:"occurred_at"`
+}
+
+// runUsageReporting reports the usage recorded since the last run to the gateway on every tick,
+// usage the gateway did not take is tried again on the next one
+func (app *application) runUsageReporting() {
+	ticker := time.NewTicker(app.config.usageInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		batches, err := app.DB.GetUnreportedUsage(time.Now())
+		if err != nil {
+			app.errorLog.Println(err)
+			continue
+		}
+
+		for _, b := range batches {
+			if err := app.reportUsage(b); err != nil {
+				app.errorLog.Printf("usage of order %d: %v", b.OrderID, err)
+			}
+		}
+	}
+}
+
+// reportUsage sends the sum of a batch to the gateway as one usage record. The batch key is stored
+// with its records before it is sent, usage recorded later goes in another batch, so a batch that was
+// reported but could not be marked is sent again with the same key and not counted twice.
+func (app *application) reportUsage(b *models.UsageBatch) error {
+	if err := app.DB.StartUsageBatch(b); err != nil {
+		return err
+	}
+
+	record, err := app.Gateway.ReportUsage(b.GatewaySubscriptionID, b.Quantity, b.LastOccurredAt, b.Key)
+	if err != nil {
+		return err
+	}
+
+	return app.DB.MarkUsageReported(b.RecordIDs, record.ID)
+}
+
+// readUsageOrder loads the subscription order of the url and its plan, the plan must be metered
+func (app *application) readUsageOrder(w http.ResponseWriter, r *http.Request) (models.Order, bool) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	order, err := app.DB.GetOrderByID(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.notFound(w, r, "subscription not found")
+			return order, false
+		}
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return order, false
+	}
+
+	widget, err := app.DB.GetWidget(order.WidgetID)
+	if err != nil || !widget.IsRecurring || order.Subscription == nil {
+		app.notFound(w, r, "subscription not found")
+		return order, false
+	}
+	order.Widget = widget
+
+	if !widget.Metered() {
+		app.badRequest(w, r, fmt.Errorf("%s is not billed by usage", widget.Name))
+		return order, false
+	}
+
+	return order, true
+}
+
+// RecordUsage stores a usage event of a metered subscription, it is reported to the gateway on the
+// next run. An event id that was already recorded with the same quantity is accepted again.
+func (app *application) RecordUsage(w http.ResponseWriter, r *http.Request) {
+	var payload usagePayload
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	order, ok := app.readUsageOrder(w, r)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+	v.Check(payload.EventID != "", "event_id", "must be provided")
+	v.Check(len(payload.EventID) <= 255, "event_id", "must be at most 255 characters")
+	v.Check(payload.Quantity > 0 && payload.Quantity <= maxUsageQuantity, "quantity", fmt.Sprintf("must be between 1 and %d", maxUsageQuantity))
+	occurredAt := time.Now()
+	if payload.OccurredAt != "" {
+		t, err := time.Parse(time.RFC3339, payload.OccurredAt)
+		v.Check(err == nil, "occurred_at", "must be a time like 2006-01-02T15:04:05Z")
+		occurredAt = t
+	}
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	if app.usageReplayed(w, r, order.ID, payload) {
+		return
+	}
+
+	v.Check(order.StatusID == models.OrderCharged && order.Subscription.Status != models.SubscriptionCanceled,
+		"id", "the subscription is cancelled")
+	v.Check(!occurredAt.After(time.Now().Add(usageClockSkew)), "occurred_at", "must not be in the future")
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	// the subscription renewed when the gateway has not told us yet
+	sub := order.Subscription
+	if !occurredAt.Before(sub.CurrentPeriodEnd) {
+		if err := app.refreshSubscription(order.Transaction.PaymentIntent); err != nil {
+			app.errorLog.Println(err)
+		} else if s, err := app.DB.GetSubscriptionByOrderID(order.ID); err == nil && s != nil {
+			sub = s
+		}
+	}
+	v.Check(!occurredAt.Before(sub.CurrentPeriodStart) && occurredAt.Before(sub.CurrentPeriodEnd),
+		"occurred_at", "must be in the current billing period")
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	usage := models.UsageRecord{
+		OrderID:     order.ID,
+		EventID:     payload.EventID,
+		Quantity:    payload.Quantity,
+		OccurredAt:  occurredAt,
+		PeriodStart: sub.CurrentPeriodStart,
+		PeriodEnd:   sub.CurrentPeriodEnd,
+	}
+	id, isNew, err := app.DB.InsertUsageRecord(usage)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+	if !isNew && app.usageReplayed(w, r, order.ID, payload) {
+		// the same event was recorded by a concurrent request
+		return
+	}
+	usage.ID = id
+
+	var resp struct {
+		Error   bool                `json:"error"`
+		Message string              `json:"message"`
+		Usage   *models.UsageRecord `json:"usage"`
+	}
+	resp.Error = false
+	resp.Message = "Usage recorded"
+	resp.Usage = &usage
+	app.writeJSON(w, http.StatusCreated, resp)
+}
+
+// usageReplayed answers for an event id that was already recorded, the same event sent again is
+// fine but an event id reused for another quantity is a conflict
+func (app *application) usageReplayed(w http.ResponseWriter, r *http.Request, orderID int, payload usagePayload) bool {
+	existing, err := app.DB.GetUsageRecordByEventID(orderID, payload.EventID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false
+	}
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return true
+	}
+
+	if existing.Quantity != payload.Quantity {
+		app.statusConflict(w, fmt.Errorf("event %s was already recorded with quantity %d", existing.EventID, existing.Quantity))
+		return true
+	}
+
+	var resp struct {
+		Error   bool                `json:"error"`
+		Message string              `json:"message"`
+		Usage   *models.UsageRecord `json:"usage"`
+	}
+	resp.Error = false
+	resp.Message = "Usage already recorded"
+	resp.Usage = existing
+	app.writeJSON(w, http.StatusOK, resp)
+	return true
+}
+
+// CurrentUsage returns the usage of a metered subscription in its current billing period
+func (app *application) CurrentUsage(w http.ResponseWriter, r *http.Request) {
+	order, ok := app.readUsageOrder(w, r)
+	if !ok {
+		return
+	}
+
+	sub := order.Subscription
+	summary, err := app.DB.GetUsageSummary(order.ID, sub.CurrentPeriodStart, sub.CurrentPeriodEnd)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+	summary.Unit = order.Widget.UsageUnit
+
+	app.writeJSON(w, http.StatusOK, summary)
+}
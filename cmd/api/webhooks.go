@@ -0,0 +1,236 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/models"
+	"github.com/stripe/stripe-go"
+	"github.com/stripe/stripe-go/webhook"
+)
+
+// webhookSecretPlaceholder is the webhook secret in the Makefile, the API refuses to start with it
+const webhookSecretPlaceholder = "whsec_replace_me"
+
+// StripeWebhook receives the gateway events, verifies the signature and updates orders and transactions
+func (app *application) StripeWebhook(w http.ResponseWriter, r *http.Request) {
+	maxBytes := 65536
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+
+	event, err := webhook.ConstructEvent(body, r.Header.Get("Stripe-Signature"), app.config.stripe.webhook)
+	if err != nil {
+		app.errorLog.Println("invalid webhook signature:", err)
+		app.badRequest(w, r, errors.New("invalid signature"))
+		return
+	}
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	isNew, err := app.DB.InsertWebhookEvent(event.ID, event.Type)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if !isNew {
+		resp.Error = false
+		resp.Message = "Event already processed"
+		app.writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	err = app.handleStripeEvent(event)
+	if err != nil {
+		app.errorLog.Println(err)
+		// forget the event so the gateway retries it
+		if err := app.DB.DeleteWebhookEvent(event.ID); err != nil {
+			app.errorLog.Println(err)
+		}
+		resp.Error = true
+		resp.Message = "Event could not be processed"
+		app.writeJSON(w, http.StatusInternalServerError, resp)
+		return
+	}
+
+	resp.Error = false
+	resp.Message = "Event processed"
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// handleStripeEvent maps a gateway event onto the order and transaction statuses
+func (app *application) handleStripeEvent(event stripe.Event) error {
+	switch event.Type {
+	case "invoice.paid":
+		var invoice stripe.Invoice
+		if err := json.Unmarshal(event.Data.Raw, &invoice); err != nil {
+			return err
+		}
+		if invoice.Subscription == nil {
+			return nil
+		}
+		err := app.DB.UpdateTransactionStatusByPaymentIntent(invoice.Subscription.ID, models.TransactionCleared)
+		if err = app.skipIllegal(err); err != nil {
+			return err
+		}
+		if err := app.DB.CloseDunning(invoice.Subscription.ID, models.DunningRecovered); err != nil {
+			return err
+		}
+		err = app.DB.UpdateOrderStatusByPaymentIntent(invoice.Subscription.ID, models.OrderCharged, "Invoice paid")
+		if err = app.skipIllegal(err); err != nil {
+			return err
+		}
+		return app.recordInvoice(invoice)
+
+	case "invoice.payment_failed":
+		var invoice stripe.Invoice
+		if err := json.Unmarshal(event.Data.Raw, &invoice); err != nil {
+			return err
+		}
+		if invoice.Subscription == nil {
+			return nil
+		}
+		err := app.DB.UpdateTransactionStatusByPaymentIntent(invoice.Subscription.ID, models.TransactionDeclined)
+		if err = app.skipIllegal(err); err != nil {
+			return err
+		}
+		return app.startDunning(invoice)
+
+	case "charge.refunded":
+		var charge stripe.Charge
+		if err := json.Unmarshal(event.Data.Raw, &charge); err != nil {
+			return err
+		}
+		if charge.PaymentIntent == "" {
+			return nil
+		}
+		return app.recordGatewayRefunds(charge)
+
+	case "customer.subscription.updated":
+		var subscription stripe.Subscription
+		if err := json.Unmarshal(event.Data.Raw, &subscription); err != nil {
+			return err
+		}
+		return app.syncSubscription(&subscription)
+
+	case "customer.subscription.deleted":
+		var subscription stripe.Subscription
+		if err := json.Unmarshal(event.Data.Raw, &subscription); err != nil {
+			return err
+		}
+		err := app.DB.UpdateOrderStatusByPaymentIntent(subscription.ID, models.OrderCancelled, "Subscription ended on the gateway")
+		if err = app.skipIllegal(err); err != nil {
+			return err
+		}
+		if err := app.DB.CloseDunning(subscription.ID, models.DunningCancelled); err != nil {
+			return err
+		}
+		return app.syncSubscription(&subscription)
+
+	case "payment_intent.canceled":
+		// the intent will never be paid, give back the stock it held
+		var pi stripe.PaymentIntent
+		if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
+			return err
+		}
+		// an authorization that expired or was voided on the dashboard
+		if err := app.DB.VoidAuthorizationByPaymentIntent(pi.ID); err != nil {
+			return err
+		}
+		if pi.Metadata["reservation"] == "" {
+			return nil
+		}
+		return app.DB.ReleaseInventory(pi.Metadata["reservation"])
+
+	case "charge.dispute.created", "charge.dispute.updated", "charge.dispute.closed",
+		"charge.dispute.funds_withdrawn", "charge.dispute.funds_reinstated":
+		var dispute stripe.Dispute
+		if err := json.Unmarshal(event.Data.Raw, &dispute); err != nil {
+			return err
+		}
+		return app.recordDispute(dispute)
+
+	default:
+		app.infoLog.Println("unhandled webhook event", event.Type)
+		return nil
+	}
+}
+
+// skipIllegal logs a status change the order or transaction no longer allows, e.g. an event delivered
+// after the order was cancelled, instead of failing the event so the gateway would send it again
+func (app *application) skipIllegal(err error) error {
+	if errors.Is(err, models.ErrIllegalTransition) {
+		app.infoLog.Println(err)
+		return nil
+	}
+	return err
+}
+
+// recordGatewayRefunds adds refunds issued outside the admin, e.g. from the dashboard, to the refund ledger
+func (app *application) recordGatewayRefunds(charge stripe.Charge) error {
+	orderID, txnID, err := app.DB.GetOrderIDByPaymentIntent(charge.PaymentIntent)
+	if errors.Is(err, sql.ErrNoRows) {
+		// a virtual terminal charge has no order
+		status := models.TransactionRefunded
+		if charge.AmountRefunded < charge.Amount {
+			status = models.TransactionPartiallyRefunded
+		}
+		return app.skipIllegal(app.DB.UpdateTransactionStatusByPaymentIntent(charge.PaymentIntent, status))
+	}
+	if err != nil {
+		return err
+	}
+
+	orderStatusID, txnStatusID := models.OrderRefunded, models.TransactionRefunded
+	if charge.AmountRefunded < charge.Amount {
+		orderStatusID, txnStatusID = models.OrderPartiallyRefunded, models.TransactionPartiallyRefunded
+	}
+
+	// the money went back whatever we think of the order, e.g. a cancelled subscription refunded on
+	// the dashboard, so the refund is recorded and a status it can't move to is left as it is
+	order, err := app.DB.GetOrderByID(orderID)
+	if err != nil {
+		return err
+	}
+	if !models.CanChangeOrderStatus(order.StatusID, orderStatusID) {
+		app.infoLog.Printf("refund %s recorded for order %d without changing its status (%s)",
+			charge.ID, orderID, models.OrderStatusName(order.StatusID))
+		orderStatusID = order.StatusID
+	}
+	if !models.CanChangeTransactionStatus(order.Transaction.TransactionStatusID, txnStatusID) {
+		txnStatusID = order.Transaction.TransactionStatusID
+	}
+
+	if charge.Refunds == nil {
+		return nil
+	}
+
+	for _, refund := range charge.Refunds.Data {
+		_, err := app.DB.InsertRefund(models.Refund{
+			OrderID:         orderID,
+			TransactionID:   txnID,
+			Amount:          int(refund.Amount),
+			Currency:        string(refund.Currency),
+			Reason:          string(refund.Reason),
+			GatewayRefundID: refund.ID,
+		}, orderStatusID, txnStatusID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/currency"
 	"github.com/signintech/gopdf"
 )
 
@@ -14,6 +15,7 @@ type Order struct {
 	ID        int       `json:"id"`
 	Quantity  int       `json:"quantity"`
 	Amount    int       `json:"amount"`
+	Currency  string    `json:"currency"`
 	Product   string    `json:"product"`
 	FirstName string    `json:"first_name"`
 	LastName  string    `json:"last_name"`
@@ -35,6 +37,7 @@ func (app *application) CreateAndSendInvoice(w http.ResponseWriter, r *http.Requ
 	order.FirstName = "fajar"
 	order.LastName = "cp"
 	order.Amount = 1000
+	order.Currency = "cad"
 	order.Quantity = 1
 	order.Product = "Widget"
 	order.CreatedAt = time.Now()
@@ -112,7 +115,7 @@ func (app *application) createInvoicePDF(order Order) error {
 	})
 
 	pdf.SetX(185)
-	pdf.CellWithOption(&gopdf.Rect{W: 20, H: 8}, fmt.Sprintf("$%.2f", float32(order.Quantity/100.0)), gopdf.CellOption{
+	pdf.CellWithOption(&gopdf.Rect{W: 20, H: 8}, currency.Format(order.Amount, order.Currency), gopdf.CellOption{
 		Border:      gopdf.ContentTypeText,
 		Align:       gopdf.Right,
 		BreakOption: &gopdf.DefaultBreakOption,
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/driver"
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/migrations"
+)
+
+const usage = `Usage: migrate [flags] command
+
+Commands:
+  up [n]       apply the pending migrations, or only the next n
+  down [n]     revert the last n applied migrations, 1 by default
+  status       list the migrations and when they were applied
+  baseline N   mark the migrations up to version N as applied without running them
+  create NAME  write empty up and down files for a new migration
+
+A database made before the migrations already has some of their tables, run
+baseline with the last version its schema has and then up. baseline also clears
+a dirty migration once the database was put right by hand.
+
+Flags:
+`
+
+func main() {
+	var dsn, dir string
+	flag.StringVar(&dsn, "dsn", "root:12345678@tcp(localhost:3306)/learning_widgets?parseTime=true&tls=false", "DSN")
+	flag.StringVar(&dir, "dir", migrations.Dir, "Directory new migrations are created in")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
+	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime)
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// create only writes files, it works without a database
+	if args[0] == "create" {
+		if len(args) != 2 {
+			errorLog.Fatal("create needs the name of the migration")
+		}
+		created, err := migrations.Create(dir, args[1])
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		for _, path := range created {
+			infoLog.Printf("Created %s", path)
+		}
+		return
+	}
+
+	steps := 0
+	if args[0] == "baseline" && len(args) != 2 {
+		errorLog.Fatal("baseline needs the version the database schema is at")
+	}
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			errorLog.Fatalf("%s is not a number of migrations", args[1])
+		}
+		steps = n
+	}
+
+	con, err := driver.OpenDB(dsn)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	defer con.Close()
+
+	switch args[0] {
+	case "up":
+		done, err := migrations.Up(con, steps)
+		for _, m := range done {
+			infoLog.Printf("Applied %04d_%s", m.Version, m.Name)
+		}
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		if len(done) == 0 {
+			infoLog.Println("No pending migrations")
+		}
+
+	case "down":
+		if steps == 0 {
+			steps = 1
+		}
+		done, err := migrations.Down(con, steps)
+		for _, m := range done {
+			infoLog.Printf("Reverted %04d_%s", m.Version, m.Name)
+		}
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		if len(done) == 0 {
+			infoLog.Println("No applied migrations")
+		}
+
+	case "baseline":
+		// the argument of baseline is a version, not a number of migrations
+		done, err := migrations.Baseline(con, steps)
+		for _, m := range done {
+			infoLog.Printf("Marked %04d_%s as applied", m.Version, m.Name)
+		}
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		if len(done) == 0 {
+			infoLog.Println("Nothing to mark, the migrations are already applied")
+		}
+
+	case "status":
+		statuses, err := migrations.Statuses(con)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.Dirty {
+				state = "dirty"
+			} else if s.AppliedAt != nil {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-40s %s\n", s.Version, s.Name, state)
+		}
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
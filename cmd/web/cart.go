@@ -0,0 +1,424 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/cards"
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/currency"
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/models"
+)
+
+// maxCartQuantity is the most of one widget a cart may hold
+const maxCartQuantity = 100
+
+// CartItem is one line of the cart
+type CartItem struct {
+	WidgetID int
+	Quantity int
+}
+
+// Cart is the type for the cart kept in the session
+type Cart struct {
+	Items []CartItem
+}
+
+// CartLine is a cart item priced for display
+type CartLine struct {
+	Widget    models.Widget
+	Quantity  int
+	UnitPrice int
+	Amount    int
+}
+
+// getCart returns the cart of the session, empty when there is none
+func (app *application) getCart(r *http.Request) Cart {
+	cart, ok := app.Session.Get(r.Context(), "cart").(Cart)
+	if !ok {
+		return Cart{}
+	}
+	return cart
+}
+
+func (app *application) putCart(r *http.Request, cart Cart) {
+	if len(cart.Items) == 0 {
+		app.Session.Remove(r.Context(), "cart")
+		return
+	}
+	app.Session.Put(r.Context(), "cart", cart)
+}
+
+// setQuantity sets the quantity of a widget, a quantity of zero removes the line
+func (c *Cart) setQuantity(widgetID, quantity int) {
+	for i, item := range c.Items {
+		if item.WidgetID == widgetID {
+			if quantity <= 0 {
+				c.Items = append(c.Items[:i], c.Items[i+1:]...)
+				return
+			}
+			c.Items[i].Quantity = quantity
+			return
+		}
+	}
+	if quantity > 0 {
+		c.Items = append(c.Items, CartItem{WidgetID: widgetID, Quantity: quantity})
+	}
+}
+
+func (c *Cart) quantity(widgetID int) int {
+	for _, item := range c.Items {
+		if item.WidgetID == widgetID {
+			return item.Quantity
+		}
+	}
+	return 0
+}
+
+// cartCurrency returns the currency asked for, or the default currency
+func cartCurrency(r *http.Request) string {
+	code := strings.ToLower(r.URL.Query().Get("currency"))
+	if !currency.Valid(code) {
+		return currency.DefaultCurrency
+	}
+	return code
+}
+
+// ShowCart displays the cart priced in the chosen currency
+func (app *application) ShowCart(w http.ResponseWriter, r *http.Request) {
+	cart := app.getCart(r)
+	code := cartCurrency(r)
+
+	lines := []CartLine{}
+	total := 0
+	for _, item := range cart.Items {
+		widget, err := app.DB.GetWidget(item.WidgetID)
+		if err != nil {
+			app.errorLog.Println(err)
+			continue
+		}
+
+		price, err := app.DB.GetWidgetPrice(widget, code)
+		if err != nil {
+			if errors.Is(err, models.ErrNoPrice) {
+				app.Session.Put(r.Context(), "warning", fmt.Sprintf("%s is not sold in %s", widget.Name, strings.ToUpper(code)))
+				http.Redirect(w, r, "/cart", http.StatusSeeOther)
+				return
+			}
+			app.errorLog.Println(err)
+			return
+		}
+
+		lines = append(lines, CartLine{
+			Widget:    widget,
+			Quantity:  item.Quantity,
+			UnitPrice: price.Price,
+			Amount:    price.Price * item.Quantity,
+		})
+		total += price.Price * item.Quantity
+	}
+
+	data := make(map[string]interface{})
+	data["lines"] = lines
+	data["total"] = total
+	data["currency"] = code
+	data["currencies"] = currency.All()
+
+	if err := app.renderTemplate(w, r, "cart", &templateData{
+		Data: data,
+	}, "stripe-js"); err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// readCartForm reads the widget and the quantity posted to the cart
+func (app *application) readCartForm(r *http.Request) (models.Widget, int, error) {
+	err := r.ParseForm()
+	if err != nil {
+		return models.Widget{}, 0, err
+	}
+
+	widgetID, _ := strconv.Atoi(r.Form.Get("widget_id"))
+	quantity := 1
+	if q := r.Form.Get("quantity"); q != "" {
+		quantity, err = strconv.Atoi(q)
+		if err != nil {
+			return models.Widget{}, 0, errors.New("invalid quantity")
+		}
+	}
+
+	widget, err := app.DB.GetWidget(widgetID)
+	if err != nil {
+		return widget, 0, errors.New("product not found")
+	}
+	if widget.IsRecurring {
+		return widget, 0, errors.New("plans must be bought with a subscription")
+	}
+
+	return widget, quantity, nil
+}
+
+// AddToCart adds a widget to the cart
+func (app *application) AddToCart(w http.ResponseWriter, r *http.Request) {
+	widget, quantity, err := app.readCartForm(r)
+	if err != nil {
+		app.Session.Put(r.Context(), "error", err.Error())
+		http.Redirect(w, r, "/cart", http.StatusSeeOther)
+		return
+	}
+
+	cart := app.getCart(r)
+	if cart.quantity(widget.ID) == 0 && len(cart.Items) >= models.MaxOrderItems {
+		app.Session.Put(r.Context(), "error", fmt.Sprintf("A cart may have at most %d lines", models.MaxOrderItems))
+		http.Redirect(w, r, "/cart", http.StatusSeeOther)
+		return
+	}
+
+	quantity += cart.quantity(widget.ID)
+	if quantity < 1 || quantity > maxCartQuantity {
+		app.Session.Put(r.Context(), "error", fmt.Sprintf("Quantity must be between 1 and %d", maxCartQuantity))
+		http.Redirect(w, r, "/cart", http.StatusSeeOther)
+		return
+	}
+
+	cart.setQuantity(widget.ID, quantity)
+	app.putCart(r, cart)
+	app.Session.Put(r.Context(), "flash", fmt.Sprintf("%s added to cart", widget.Name))
+	http.Redirect(w, r, "/cart", http.StatusSeeOther)
+}
+
+// UpdateCartItem changes the quantity of a widget in the cart, zero removes it
+func (app *application) UpdateCartItem(w http.ResponseWriter, r *http.Request) {
+	widget, quantity, err := app.readCartForm(r)
+	if err != nil {
+		app.Session.Put(r.Context(), "error", err.Error())
+		http.Redirect(w, r, "/cart", http.StatusSeeOther)
+		return
+	}
+
+	if quantity < 0 || quantity > maxCartQuantity {
+		app.Session.Put(r.Context(), "error", fmt.Sprintf("Quantity must be between 0 and %d", maxCartQuantity))
+		http.Redirect(w, r, "/cart", http.StatusSeeOther)
+		return
+	}
+
+	cart := app.getCart(r)
+	cart.setQuantity(widget.ID, quantity)
+	app.putCart(r, cart)
+	http.Redirect(w, r, "/cart", http.StatusSeeOther)
+}
+
+// RemoveFromCart removes a widget from the cart
+func (app *application) RemoveFromCart(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+
+	widgetID, _ := strconv.Atoi(r.Form.Get("widget_id"))
+	cart := app.getCart(r)
+	cart.setQuantity(widgetID, 0)
+	app.putCart(r, cart)
+	http.Redirect(w, r, "/cart", http.StatusSeeOther)
+}
+
+// CartPaymentSucceeded places the order for the cart once the payment intent succeeded
+func (app *application) CartPaymentSucceeded(w http.ResponseWriter, r *http.Request) {
+	txnData, err := app.GetTransactionData(r)
+	if err != nil {
+		app.errorLog.Println(err)
+		if errors.Is(err, cards.ErrPaymentIncomplete) {
+			http.Error(w, err.Error(), http.StatusPaymentRequired)
+		}
+		return
+	}
+
+	if app.showPlacedOrder(w, r, txnData) {
+		return
+	}
+
+	cart := app.getCart(r)
+	if err := app.verifyOrderItems(&txnData, cart.Items); err != nil {
+		app.errorLog.Printf("payment intent %s: %s", txnData.PaymentIntentID, err)
+		http.Error(w, "The payment does not match the cart", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := app.placeOrder(txnData); err != nil {
+		if errors.Is(err, models.ErrOrderExists) && app.showPlacedOrder(w, r, txnData) {
+			return
+		}
+		app.errorLog.Printf("payment intent %s: %s", txnData.PaymentIntentID, err)
+		if orderConflict(err) {
+			http.Error(w, err.Error(), http.StatusConflict)
+		}
+		return
+	}
+
+	app.Session.Remove(r.Context(), "cart")
+	app.Session.Put(r.Context(), "receipt", txnData)
+	http.Redirect(w, r, "/receipt", http.StatusSeeOther)
+}
+
+// verifyOrderItems checks the payment intent paid for the expected lines at today's prices,
+// the lines of txnData get the widget names for the receipt
+func (app *application) verifyOrderItems(txnData *TransactionData, expected []CartItem) error {
+	if len(expected) == 0 {
+		return errors.New("nothing was ordered")
+	}
+	if len(txnData.Items) != len(expected) {
+		return fmt.Errorf("paid for %d lines, expected %d", len(txnData.Items), len(expected))
+	}
+
+	total := 0
+	for i, line := range expected {
+		item := txnData.Items[i]
+		if item.WidgetID != line.WidgetID || item.Quantity != line.Quantity || line.Quantity < 1 {
+			return fmt.Errorf("paid for widget %d x %d, expected widget %d x %d", item.WidgetID, item.Quantity, line.WidgetID, line.Quantity)
+		}
+
+		widget, err := app.DB.GetWidget(line.WidgetID)
+		if err != nil {
+			return err
+		}
+
+		price, err := app.DB.GetWidgetPrice(widget, txnData.PaymentCurrency)
+		if err != nil {
+			return err
+		}
+
+		if item.UnitPrice != price.Price {
+			return fmt.Errorf("paid %d %s for widget %d, expected %d", item.UnitPrice, txnData.PaymentCurrency, widget.ID, price.Price)
+		}
+
+		item.Widget = widget
+		total += price.Price * line.Quantity
+	}
+
+	// the discount was worked out by the api when the coupon was redeemed
+	if txnData.DiscountAmount < 0 || txnData.DiscountAmount > total || (txnData.DiscountAmount > 0 && txnData.CouponCode == "") {
+		return fmt.Errorf("invalid discount %d for coupon %q", txnData.DiscountAmount, txnData.CouponCode)
+	}
+	total -= txnData.DiscountAmount
+
+	if total != txnData.PaymentAmount {
+		return fmt.Errorf("paid %d %s, expected %d", txnData.PaymentAmount, txnData.PaymentCurrency, total)
+	}
+
+	return nil
+}
+
+// placeOrder saves the customer, the transaction and the order with its lines in one database
+// transaction, returns the order id. The card is already charged, so a failed write is recorded
+// for reconciliation.
+func (app *application) placeOrder(txnData TransactionData) (int, error) {
+	customer := models.Customer{
+		FirstName: txnData.FirstName,
+		LastName:  txnData.LastName,
+		Email:     txnData.Email,
+	}
+
+	txn := models.Transaction{
+		Amount:              txnData.PaymentAmount,
+		Currency:            txnData.PaymentCurrency,
+		LastFour:            txnData.LastFour,
+		ExpiryMonth:         txnData.ExpiryMonth,
+		ExpiryYear:          txnData.ExpiryYear,
+		BankReturnCode:      txnData.BankReturnCode,
+		PaymentIntent:       txnData.PaymentIntentID,
+		PaymentMethod:       txnData.PaymentMethodID,
+		TransactionStatusID: models.TransactionCleared,
+	}
+
+	// the order keeps the first widget and the total quantity, the lines have the detail
+	quantity := 0
+	for _, item := range txnData.Items {
+		quantity += item.Quantity
+	}
+
+	order := models.Order{
+		WidgetID:    txnData.Items[0].WidgetID,
+		Amount:      txnData.PaymentAmount,
+		StatusID:    models.OrderCharged,
+		Quantity:    quantity,
+		Items:       txnData.Items,
+		Reservation: txnData.Reservation,
+	}
+
+	var redemption *models.CouponRedemption
+	if txnData.CouponCode != "" {
+		// the coupon is found by its code when the order is saved
+		redemption = &models.CouponRedemption{
+			Code:           txnData.CouponCode,
+			CustomerEmail:  txnData.Email,
+			DiscountAmount: txnData.DiscountAmount,
+			Currency:       txnData.PaymentCurrency,
+		}
+	}
+
+	created, err := app.DB.CreateOrder(customer, txn, order, redemption)
+	if err != nil {
+		// a payment posted twice is not a payment to put right
+		if !errors.Is(err, models.ErrOrderExists) {
+			app.recordReconciliation(txnData, err)
+		}
+		return 0, err
+	}
+
+	return created.OrderID, nil
+}
+
+// showPlacedOrder redirects to the receipt when the payment intent was already turned into an order,
+// e.g. the payment form was posted again by a reload, so the order isn't placed and the stock taken twice
+func (app *application) showPlacedOrder(w http.ResponseWriter, r *http.Request, txnData TransactionData) bool {
+	_, _, err := app.DB.GetOrderIDByPaymentIntent(txnData.PaymentIntentID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			app.errorLog.Println(err)
+		}
+		return false
+	}
+
+	for _, item := range txnData.Items {
+		if widget, err := app.DB.GetWidget(item.WidgetID); err == nil {
+			item.Widget = widget
+		}
+	}
+
+	app.Session.Put(r.Context(), "receipt", txnData)
+	http.Redirect(w, r, "/receipt", http.StatusSeeOther)
+	return true
+}
+
+// orderConflict reports whether an order could not be placed because the stock or the coupon
+// was taken by another checkout after the payment was priced
+func orderConflict(err error) bool {
+	return errors.Is(err, models.ErrOutOfStock) ||
+		errors.Is(err, models.ErrCouponExhausted) ||
+		errors.Is(err, models.ErrCouponLimitReached)
+}
+
+// recordReconciliation marks a payment that was taken but not saved so it can be put right later
+func (app *application) recordReconciliation(txnData TransactionData, cause error) {
+	payload, err := json.Marshal(txnData)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+
+	_, err = app.DB.InsertPaymentReconciliation(models.PaymentReconciliation{
+		PaymentIntent: txnData.PaymentIntentID,
+		Amount:        txnData.PaymentAmount,
+		Currency:      txnData.PaymentCurrency,
+		Email:         txnData.Email,
+		Reason:        cause.Error(),
+		Payload:       string(payload),
+	})
+	if err != nil {
+		app.errorLog.Printf("payment intent %s was charged but could not be recorded: %s", txnData.PaymentIntentID, err)
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -38,6 +40,10 @@ type TransactionData struct {
 	ExpiryMonth     int
 	ExpiryYear      int
 	BankReturnCode  string
+	Items           []*models.OrderItem
+	CouponCode      string
+	DiscountAmount  int
+	Reservation     string
 }
 
 // get transaction data from post and stripe
@@ -54,22 +60,20 @@ func (app *application) GetTransactionData(r *http.Request) (TransactionData, er
 	lastName := r.Form.Get("last_name")
 	paymentIntent := r.Form.Get("payment_intent")
 	paymentMethod := r.Form.Get("payment_method")
-	paymentAmount := r.Form.Get("payment_amount")
-	paymentCurrency := r.Form.Get("payment_currency")
 	email := r.Form.Get("email")
-	amount, _ := strconv.Atoi(paymentAmount)
 
-	card := cards.Card{
-		Secret: app.config.stripe.secret,
-		Key:    app.config.stripe.key,
+	pi, err := app.Gateway.RetriveGetPaymentIntent(paymentIntent)
+	if err != nil {
+		app.errorLog.Println(err)
+		return txnData, err
 	}
 
-	pi, err := card.RetriveGetPaymentIntent(paymentIntent)
+	// an intent that still needs authentication or was declined has no charge
+	charge, err := cards.IntentCharge(pi)
 	if err != nil {
-		app.errorLog.Println(err)
 		return txnData, err
 	}
-	pm, err := card.GetPaymentMethod(paymentMethod)
+	pm, err := app.Gateway.GetPaymentMethod(paymentMethod)
 	if err != nil {
 		app.errorLog.Println(err)
 		return txnData, err
@@ -79,19 +83,30 @@ func (app *application) GetTransactionData(r *http.Request) (TransactionData, er
 	expiryMonth := pm.Card.ExpMonth
 	expiryYear := pm.Card.ExpYear
 
+	// the amount and the products come from the gateway, not from the posted form
+	items, err := models.ParseItemsMetadata(pi.Metadata["items"])
+	if err != nil {
+		app.errorLog.Println(err)
+		return txnData, err
+	}
+
 	txnData = TransactionData{
 		FirstName:       firstName,
 		LastName:        lastName,
 		Email:           email,
 		PaymentIntentID: paymentIntent,
 		PaymentMethodID: paymentMethod,
-		PaymentAmount:   amount,
-		PaymentCurrency: paymentCurrency,
+		PaymentAmount:   int(pi.Amount),
+		PaymentCurrency: pi.Currency,
 		LastFour:        lastFour,
 		ExpiryMonth:     int(expiryMonth),
 		ExpiryYear:      int(expiryYear),
-		BankReturnCode:  pi.Charges.Data[0].ID,
+		BankReturnCode:  charge.ID,
+		Items:           items,
+		CouponCode:      pi.Metadata["coupon"],
+		Reservation:     pi.Metadata["reservation"],
 	}
+	txnData.DiscountAmount, _ = strconv.Atoi(pi.Metadata["discount"])
 	return txnData, nil
 }
 
@@ -106,46 +121,37 @@ func (app *application) PaymentSucceeded(w http.ResponseWriter, r *http.Request)
 	txnData, err := app.GetTransactionData(r)
 	if err != nil {
 		app.errorLog.Println(err)
+		if errors.Is(err, cards.ErrPaymentIncomplete) {
+			http.Error(w, err.Error(), http.StatusPaymentRequired)
+		}
 		return
 	}
 
-	// create a new customer
-	customerID, err := app.SaveCustomer(txnData.FirstName, txnData.LastName, txnData.Email)
-	fmt.Println("CUSTOMER ID", customerID)
-	if err != nil {
-		app.errorLog.Println(err)
+	if app.showPlacedOrder(w, r, txnData) {
 		return
 	}
-	//create a new transaction
-	txn := models.Transaction{
-		Amount:              txnData.PaymentAmount,
-		Currency:            txnData.PaymentCurrency,
-		LastFour:            txnData.LastFour,
-		ExpiryMonth:         txnData.ExpiryMonth,
-		ExpiryYear:          txnData.ExpiryYear,
-		BankReturnCode:      txnData.BankReturnCode,
-		PaymentIntent:       txnData.PaymentIntentID,
-		PaymentMethod:       txnData.PaymentMethodID,
-		TransactionStatusID: 2,
+
+	// make sure the customer paid the price of what is being ordered
+	if len(txnData.Items) != 1 {
+		app.errorLog.Printf("payment intent %s paid for %d lines, expected one widget", txnData.PaymentIntentID, len(txnData.Items))
+		http.Error(w, "The payment does not match the order", http.StatusBadRequest)
+		return
 	}
-	txnID, err := app.SaveTransaction(txn)
-	if err != nil {
-		app.errorLog.Println(err)
+	expected := []CartItem{{WidgetID: widgetID, Quantity: txnData.Items[0].Quantity}}
+	if err := app.verifyOrderItems(&txnData, expected); err != nil {
+		app.errorLog.Printf("payment intent %s: %s", txnData.PaymentIntentID, err)
+		http.Error(w, "The payment does not match the order", http.StatusBadRequest)
 		return
 	}
 
-	// create a new order
-	order := models.Order{
-		WidgetID:      widgetID,
-		TransactionID: txnID,
-		CustomerID:    customerID,
-		Amount:        txnData.PaymentAmount,
-		StatusID:      1,
-		Quantity:      1,
-	}
-	_, err = app.SaveOrder(order)
-	if err != nil {
-		app.errorLog.Println(err)
+	if _, err := app.placeOrder(txnData); err != nil {
+		if errors.Is(err, models.ErrOrderExists) && app.showPlacedOrder(w, r, txnData) {
+			return
+		}
+		app.errorLog.Printf("payment intent %s: %s", txnData.PaymentIntentID, err)
+		if orderConflict(err) {
+			http.Error(w, err.Error(), http.StatusConflict)
+		}
 		return
 	}
 
@@ -172,6 +178,9 @@ func (app *application) VirtualTerminalPaymentSucceeded(w http.ResponseWriter, r
 	txnData, err := app.GetTransactionData(r)
 	if err != nil {
 		app.errorLog.Println(err)
+		if errors.Is(err, cards.ErrPaymentIncomplete) {
+			http.Error(w, err.Error(), http.StatusPaymentRequired)
+		}
 		return
 	}
 
@@ -185,7 +194,7 @@ func (app *application) VirtualTerminalPaymentSucceeded(w http.ResponseWriter, r
 		BankReturnCode:      txnData.BankReturnCode,
 		PaymentIntent:       txnData.PaymentIntentID,
 		PaymentMethod:       txnData.PaymentMethodID,
-		TransactionStatusID: 2,
+		TransactionStatusID: models.TransactionCleared,
 	}
 	_, err = app.SaveTransaction(txn)
 	if err != nil {
@@ -215,21 +224,6 @@ func (app *application) VirtualterminalReceipt(w http.ResponseWriter, r *http.Re
 	}
 }
 
-// save customer and returns a id
-func (app *application) SaveCustomer(firstName string, lastName string, email string) (int, error) {
-	customer := models.Customer{
-		FirstName: firstName,
-		LastName:  lastName,
-		Email:     email,
-	}
-
-	id, err := app.DB.InsertCustomer(customer)
-	if err != nil {
-		return 0, err
-	}
-	return id, nil
-}
-
 // save transaction and returns a id
 func (app *application) SaveTransaction(txn models.Transaction) (int, error) {
 	id, err := app.DB.InsertTransaction(txn)
@@ -239,15 +233,6 @@ func (app *application) SaveTransaction(txn models.Transaction) (int, error) {
 	return id, nil
 }
 
-// save order and returns a id
-func (app *application) SaveOrder(txn models.Order) (int, error) {
-	id, err := app.DB.InsertOrder(txn)
-	if err != nil {
-		return 0, err
-	}
-	return id, nil
-}
-
 // chargeOnce display the page to buy one widget
 func (app *application) ChargeOche(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
@@ -258,8 +243,15 @@ func (app *application) ChargeOche(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	prices, err := app.DB.GetWidgetPrices(widget)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+
 	data := make(map[string]interface{})
 	data["widget"] = widget
+	data["prices"] = prices
 
 	if err := app.renderTemplate(w, r, "buy-once", &templateData{
 		Data: data,
@@ -269,14 +261,36 @@ func (app *application) ChargeOche(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func (app *application) BronzePlan(w http.ResponseWriter, r *http.Request) {
-	widget, err := app.DB.GetWidget(3)
+// Plans displays the plans that can be subscribed to
+func (app *application) Plans(w http.ResponseWriter, r *http.Request) {
+	plans, err := app.DB.GetPlans()
 	if err != nil {
 		app.errorLog.Println(err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["plans"] = plans
+	if err := app.renderTemplate(w, r, "plans", &templateData{
+		Data: data,
+	}); err != nil {
+		app.errorLog.Print(err)
+	}
+}
+
+// ShowPlan displays the checkout page of a plan by its slug
+func (app *application) ShowPlan(w http.ResponseWriter, r *http.Request) {
+	widget, err := app.DB.GetPlanBySlug(chi.URLParam(r, "slug"))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.NotFound(w, r)
+			return
+		}
+		app.errorLog.Println(err)
+		return
 	}
 	data := make(map[string]interface{})
 	data["widget"] = widget
-	if err := app.renderTemplate(w, r, "bronze-plan", &templateData{
+	if err := app.renderTemplate(w, r, "plan", &templateData{
 		Data: data,
 	}); err != nil {
 		app.errorLog.Print(err)
@@ -284,9 +298,9 @@ func (app *application) BronzePlan(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (app *application) BronzePlanReceipt(w http.ResponseWriter, r *http.Request) {
+func (app *application) PlanReceipt(w http.ResponseWriter, r *http.Request) {
 
-	if err := app.renderTemplate(w, r, "receipt-plan", &templateData{}); err != nil {
+	if err := app.renderTemplate(w, r, "plan-receipt", &templateData{}); err != nil {
 		app.errorLog.Print(err)
 	}
 }
@@ -372,6 +386,49 @@ func (app *application) ShowResetPassword(w http.ResponseWriter, r *http.Request
 		app.errorLog.Println(err)
 	}
 }
+
+// updateCardLinkExpiry is how long the update card link of a dunning email can be used, in minutes
+const updateCardLinkExpiry = 7 * 24 * 60
+
+// ShowUpdateCard shows the page where a customer whose renewal failed can change the card of the subscription
+func (app *application) ShowUpdateCard(w http.ResponseWriter, r *http.Request) {
+	theUrl := r.RequestURI
+	testURL := fmt.Sprintf("%s%s", app.config.frontend, theUrl)
+	subscription := r.URL.Query().Get("subscription")
+
+	signer := urlsigner.Signer{
+		Secrect: []byte(app.config.secrectkey),
+	}
+
+	data := make(map[string]interface{})
+
+	valid := signer.VerifyToken(testURL)
+	if !valid {
+		app.errorLog.Println("Invalid url - tampering detected")
+	} else if signer.Expired(testURL, updateCardLinkExpiry) {
+		app.errorLog.Println("Link expired")
+		valid = false
+	}
+
+	if valid {
+		encryptor := encryption.Encryption{
+			Key: []byte(app.config.secrectkey),
+		}
+
+		encryptSubscription, err := encryptor.Encrypt(subscription)
+		if err != nil {
+			app.errorLog.Println("Encryption failed")
+			return
+		}
+		data["subscription"] = encryptSubscription
+	}
+
+	if err := app.renderTemplate(w, r, "update-card", &templateData{
+		Data: data,
+	}); err != nil {
+		app.errorLog.Println(err)
+	}
+}
 func (app *application) AllSales(w http.ResponseWriter, r *http.Request) {
 	if err := app.renderTemplate(w, r, "all-sales", &templateData{}); err != nil {
 		app.errorLog.Println(err)
@@ -390,6 +447,7 @@ func (app *application) ShowSale(w http.ResponseWriter, r *http.Request) {
 	stringMap["refund-btn"] = "Order Refund"
 	stringMap["refund-badge"] = "Refunded"
 	stringMap["refund-msg"] = "Charge refunded"
+	stringMap["refund-partial"] = "1"
 	if err := app.renderTemplate(w, r, "sale", &templateData{
 		StringMap: stringMap,
 	}); err != nil {
@@ -404,8 +462,19 @@ func (app *application) ShowSubscription(w http.ResponseWriter, r *http.Request)
 	stringMap["refund-btn"] = "Cancel Subscription"
 	stringMap["refund-badge"] = "Cancelled"
 	stringMap["refund-msg"] = "Subscription Cancelled"
+	stringMap["subscription"] = "1"
+
+	plans, err := app.DB.GetPlans()
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["plans"] = plans
+
 	if err := app.renderTemplate(w, r, "sale", &templateData{
 		StringMap: stringMap,
+		Data:      data,
 	}); err != nil {
 		app.errorLog.Print(err)
 	}
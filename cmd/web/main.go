@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/gob"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
@@ -12,7 +13,9 @@ import (
 
 	"github.com/alexedwards/scs/mysqlstore"
 	"github.com/alexedwards/scs/v2"
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/cards"
 	"github.com/fajarcahyadiputra/udemy-web-application/internal/driver"
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/migrations"
 	"github.com/fajarcahyadiputra/udemy-web-application/internal/models"
 )
 
@@ -26,7 +29,8 @@ type config struct {
 	env  string
 	api  string
 	db   struct {
-		dsn string
+		dsn             string
+		checkMigrations bool
 	}
 	stripe struct {
 		secret string
@@ -34,6 +38,7 @@ type config struct {
 	}
 	secrectkey string
 	frontend   string
+	gateway    string
 }
 type application struct {
 	config        config
@@ -43,6 +48,7 @@ type application struct {
 	version       string
 	DB            models.DBModel
 	Session       *scs.SessionManager
+	Gateway       cards.PaymentGateway
 }
 
 func (app *application) Serve() error {
@@ -61,13 +67,16 @@ func (app *application) Serve() error {
 
 func main() {
 	gob.Register(TransactionData{})
+	gob.Register(Cart{})
 	var cfg config
 	flag.IntVar(&cfg.port, "port", 4000, "Server Port To Listen On")
 	flag.StringVar(&cfg.env, "env", "development", "Application Environment {development|prodyction|testing}")
 	flag.StringVar(&cfg.db.dsn, "dsn", "root:12345678@tcp(localhost:3306)/learning_widgets?parseTime=true&tls=false", "DSN")
+	flag.BoolVar(&cfg.db.checkMigrations, "check-migrations", true, "Refuse to start when database migrations are pending or not tracked, false only logs it")
 	flag.StringVar(&cfg.api, "api", "http://localhost:4001", "URL to API")
 	flag.StringVar(&cfg.secrectkey, "secrectkey", "jdu73tdjruplcjry36ahsyebncmxkipe", "secrect key")
 	flag.StringVar(&cfg.frontend, "frontend", "http://localhost:4000", "domain frontend")
+	flag.StringVar(&cfg.gateway, "gateway", "stripe", "Payment gateway {stripe|fake}")
 
 	flag.Parse()
 
@@ -82,6 +91,21 @@ func main() {
 	}
 	defer conn.Close()
 
+	if err = migrations.Check(conn); err != nil {
+		if cfg.db.checkMigrations {
+			if errors.Is(err, migrations.ErrUntracked) {
+				errorfoLog.Fatalf("%v, run go run ./cmd/migrate baseline N with the version the schema is at, or up on an empty database", err)
+			}
+			errorfoLog.Fatalf("%v, run go run ./cmd/migrate up", err)
+		}
+		errorfoLog.Println(err)
+	}
+
+	gateway, err := cards.NewGateway(cfg.gateway, cfg.stripe.secret, cfg.stripe.key)
+	if err != nil {
+		errorfoLog.Fatal(err)
+	}
+
 	//set up session
 	session = scs.New()
 	session.Lifetime = 24 * time.Hour
@@ -98,6 +122,7 @@ func main() {
 			DB: conn,
 		},
 		Session: session,
+		Gateway: gateway,
 	}
 
 	go app.ListenToWSChannel()
@@ -6,6 +6,8 @@ import (
 	"html/template"
 	"net/http"
 	"strings"
+
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/currency"
 )
 
 type templateData struct {
@@ -29,9 +31,12 @@ var functions = template.FuncMap{
 	"formatCurrency": formatCurrency,
 }
 
-func formatCurrency(n int) string {
-	f := float32(n) / float32(100)
-	return fmt.Sprintf("$%.2f", f)
+// formatCurrency formats an amount in minor units, the currency defaults to cad
+func formatCurrency(n int, code ...string) string {
+	if len(code) > 0 && code[0] != "" {
+		return currency.Format(n, code[0])
+	}
+	return currency.Format(n, currency.DefaultCurrency)
 }
 
 //go:embed templates
@@ -41,6 +46,9 @@ func (app *application) addDefaultData(td *templateData, r *http.Request) *templ
 	td.API = app.config.api
 	td.StripeSecrectKey = app.config.stripe.secret
 	td.StripePublishableKey = app.config.stripe.key
+	td.Flash = app.Session.PopString(r.Context(), "flash")
+	td.Warning = app.Session.PopString(r.Context(), "warning")
+	td.Error = app.Session.PopString(r.Context(), "error")
 
 	if app.Session.Exists(r.Context(), "userID") {
 		td.IsAuthenticated = 1
@@ -17,6 +17,12 @@ func (app *application) routes() http.Handler {
 	mux.Get("/receipt", app.Receipt)
 	mux.Get("/widget/{id}", app.ChargeOche)
 
+	mux.Get("/cart", app.ShowCart)
+	mux.Post("/cart/add", app.AddToCart)
+	mux.Post("/cart/update", app.UpdateCartItem)
+	mux.Post("/cart/remove", app.RemoveFromCart)
+	mux.Post("/cart/payment-succeeded", app.CartPaymentSucceeded)
+
 	mux.Route("/admin", func(mux chi.Router) {
 		mux.Use(app.Auth)
 		mux.Get("/virtual-terminal", app.VirtualTerminal)
@@ -28,14 +34,16 @@ func (app *application) routes() http.Handler {
 		mux.Get("/all-users/{id}", app.OneUser)
 	})
 
-	mux.Get("/plans/bronze", app.BronzePlan)
-	mux.Get("/receipt/bronze", app.BronzePlanReceipt)
+	mux.Get("/plans", app.Plans)
+	mux.Get("/plans/{slug}", app.ShowPlan)
+	mux.Get("/receipt/plan", app.PlanReceipt)
 
 	mux.Post("/login", app.PostLoginPage)
 	mux.Get("/login", app.LoginPage)
 	mux.Get("/logout", app.Logout)
 	mux.Get("/forget-password", app.ForgetPassword)
 	mux.Get("/reset-password", app.ShowResetPassword)
+	mux.Get("/update-card", app.ShowUpdateCard)
 
 	fileServer := http.FileServer(http.Dir("./static"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
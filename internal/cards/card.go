@@ -1,12 +1,22 @@
 package cards
 
 import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
 	"github.com/stripe/stripe-go"
+	"github.com/stripe/stripe-go/charge"
 	"github.com/stripe/stripe-go/customer"
+	"github.com/stripe/stripe-go/dispute"
+	"github.com/stripe/stripe-go/file"
+	"github.com/stripe/stripe-go/invoice"
 	"github.com/stripe/stripe-go/paymentintent"
 	"github.com/stripe/stripe-go/paymentmethod"
 	"github.com/stripe/stripe-go/refund"
 	"github.com/stripe/stripe-go/sub"
+	"github.com/stripe/stripe-go/usagerecord"
 )
 
 type Card struct {
@@ -23,32 +33,75 @@ type Transaction struct {
 	BankReturnCode      string
 }
 
-func (c *Card) Charge(currency string, amount int) (*stripe.PaymentIntent, string, error) {
-	return c.CreatePaymentItent(currency, amount)
+func (c *Card) Charge(currency string, amount int, metadata map[string]string, idempotencyKey string) (*stripe.PaymentIntent, string, error) {
+	return c.CreatePaymentItent(currency, amount, metadata, idempotencyKey)
+}
+
+func (c *Card) CreatePaymentItent(currency string, amount int, metadata map[string]string, idempotencyKey string) (*stripe.PaymentIntent, string, error) {
+	return c.createPaymentIntent(currency, amount, metadata, idempotencyKey, stripe.PaymentIntentCaptureMethodAutomatic)
+}
+
+// Authorize creates an intent that only holds the amount on the card once confirmed,
+// it is taken with CapturePaymentIntent or released with CancelPaymentIntent
+func (c *Card) Authorize(currency string, amount int, metadata map[string]string, idempotencyKey string) (*stripe.PaymentIntent, string, error) {
+	return c.createPaymentIntent(currency, amount, metadata, idempotencyKey, stripe.PaymentIntentCaptureMethodManual)
 }
 
-func (c *Card) CreatePaymentItent(currency string, amount int) (*stripe.PaymentIntent, string, error) {
+func (c *Card) createPaymentIntent(currency string, amount int, metadata map[string]string, idempotencyKey string, capture stripe.PaymentIntentCaptureMethod) (*stripe.PaymentIntent, string, error) {
 	stripe.Key = c.Secret
 
 	//create payment intent
 	params := &stripe.PaymentIntentParams{
-		Amount:   stripe.Int64(int64(amount)),
-		Currency: stripe.String(currency),
+		Amount:        stripe.Int64(int64(amount)),
+		Currency:      stripe.String(currency),
+		CaptureMethod: stripe.String(string(capture)),
 	}
 
-	// params.AddMetadata("key", "value")
+	if idempotencyKey != "" {
+		params.SetIdempotencyKey(idempotencyKey)
+	}
+
+	for k, v := range metadata {
+		params.AddMetadata(k, v)
+	}
 
 	pi, err := paymentintent.New(params)
 	if err != nil {
-		msg := ""
-		if stripeErr, ok := err.(*stripe.Error); ok {
-			msg = cardErrorMessage(stripeErr.Code)
-		}
-		return nil, msg, err
+		perr := NewPaymentError(err)
+		return nil, perr.Message, perr
 	}
 	return pi, "", nil
 }
 
+// CapturePaymentIntent takes an authorized amount, an amount of 0 captures all of it and
+// the rest of a partial capture is released
+func (c *Card) CapturePaymentIntent(id string, amount int, idempotencyKey string) (*stripe.PaymentIntent, error) {
+	stripe.Key = c.Secret
+	params := &stripe.PaymentIntentCaptureParams{}
+	if amount > 0 {
+		params.AmountToCapture = stripe.Int64(int64(amount))
+	}
+	if idempotencyKey != "" {
+		params.SetIdempotencyKey(idempotencyKey)
+	}
+
+	pi, err := paymentintent.Capture(id, params)
+	if err != nil {
+		return nil, NewPaymentError(err)
+	}
+	return pi, nil
+}
+
+// CancelPaymentIntent voids an authorization or an intent that was never paid
+func (c *Card) CancelPaymentIntent(id string) (*stripe.PaymentIntent, error) {
+	stripe.Key = c.Secret
+	pi, err := paymentintent.Cancel(id, nil)
+	if err != nil {
+		return nil, NewPaymentError(err)
+	}
+	return pi, nil
+}
+
 // get payment method by payment intent id
 func (c *Card) GetPaymentMethod(s string) (*stripe.PaymentMethod, error) {
 	stripe.Key = c.Secret
@@ -69,7 +122,10 @@ func (c *Card) RetriveGetPaymentIntent(id string) (*stripe.PaymentIntent, error)
 	return pi, nil
 }
 
-func (c *Card) SubscribeToPlan(cust *stripe.Customer, plan, email, last4, cardType string) (*stripe.Subscription, error) {
+// SubscribeToPlan subscribes the customer to a plan, with trial days the first invoice is
+// only collected when the trial ends
+func (c *Card) SubscribeToPlan(cust *stripe.Customer, plan, email, last4, cardType, coupon string, trialDays int, idempotencyKey string) (*stripe.Subscription, error) {
+	stripe.Key = c.Secret
 	stripeCustomerID := cust.ID
 	items := []*stripe.SubscriptionItemsParams{
 		{
@@ -82,19 +138,36 @@ func (c *Card) SubscribeToPlan(cust *stripe.Customer, plan, email, last4, cardTy
 		Items:    items,
 	}
 
+	if coupon != "" {
+		params.Coupon = stripe.String(coupon)
+	}
+	if trialDays > 0 {
+		params.TrialPeriodDays = stripe.Int64(int64(trialDays))
+	}
+
 	params.AddMetadata("last_four", last4)
 	params.AddMetadata("card_type", cardType)
 	params.AddExpand("latest_invoice.payment_intent")
+	if idempotencyKey != "" {
+		params.SetIdempotencyKey(idempotencyKey)
+	}
 	subscription, err := sub.New(params)
 	if err != nil {
-		return nil, err
+		return nil, NewPaymentError(err)
 	}
 
 	return subscription, nil
 }
 
-func (c *Card) CreateCustomer(pm, email string) (*stripe.Customer, string, error) {
+// CreateCustomer creates a gateway customer with the payment method as default, when customerID
+// is set the payment method is added to that customer instead. Only pass a customerID whose
+// owner was verified, the payment method becomes its default.
+func (c *Card) CreateCustomer(customerID, pm, email, idempotencyKey string) (*stripe.Customer, string, error) {
 	stripe.Key = c.Secret
+	if customerID != "" {
+		return c.updateCustomer(customerID, pm, idempotencyKey)
+	}
+
 	customerParams := &stripe.CustomerParams{
 		PaymentMethod: stripe.String(pm),
 		Email:         stripe.String(email),
@@ -102,18 +175,45 @@ func (c *Card) CreateCustomer(pm, email string) (*stripe.Customer, string, error
 			DefaultPaymentMethod: stripe.String(pm),
 		},
 	}
+	if idempotencyKey != "" {
+		customerParams.SetIdempotencyKey(idempotencyKey)
+	}
 
 	cust, err := customer.New(customerParams)
 	if err != nil {
-		msg := ""
-		if stripeErr, ok := err.(*stripe.Error); ok {
-			msg = cardErrorMessage(stripeErr.Code)
-		}
-		return nil, msg, err
+		perr := NewPaymentError(err)
+		return nil, perr.Message, perr
 	}
 	return cust, "", nil
 }
-func (c *Card) Refund(pi string, amount int) error {
+
+// updateCustomer attaches the payment method to a returning customer and makes it the default
+func (c *Card) updateCustomer(customerID, pm, idempotencyKey string) (*stripe.Customer, string, error) {
+	_, err := paymentmethod.Attach(pm, &stripe.PaymentMethodAttachParams{
+		Customer: stripe.String(customerID),
+	})
+	if err != nil {
+		perr := NewPaymentError(err)
+		return nil, perr.Message, perr
+	}
+
+	customerParams := &stripe.CustomerParams{
+		InvoiceSettings: &stripe.CustomerInvoiceSettingsParams{
+			DefaultPaymentMethod: stripe.String(pm),
+		},
+	}
+	if idempotencyKey != "" {
+		customerParams.SetIdempotencyKey(idempotencyKey)
+	}
+
+	cust, err := customer.Update(customerID, customerParams)
+	if err != nil {
+		return nil, "", err
+	}
+	return cust, "", nil
+}
+
+func (c *Card) Refund(pi string, amount int, idempotencyKey string) (*stripe.Refund, error) {
 	stripe.Key = c.Secret
 	amountRefund := int64(amount)
 
@@ -121,14 +221,16 @@ func (c *Card) Refund(pi string, amount int) error {
 		Amount:        &amountRefund,
 		PaymentIntent: &pi,
 	}
+	if idempotencyKey != "" {
+		refundParams.SetIdempotencyKey(idempotencyKey)
+	}
 
-	_, err := refund.New(refundParams)
-
+	r, err := refund.New(refundParams)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return r, nil
 
 }
 
@@ -144,26 +246,268 @@ func (c *Card) CancelSubscription(subID string) error {
 	return nil
 }
 
-func cardErrorMessage(code stripe.ErrorCode) string {
-	var msg string = ""
-
-	switch code {
-	case stripe.ErrorCodeCardDeclined:
-		msg = "Your card was declined"
-	case stripe.ErrorCodeExpiredCard:
-		msg = "Your card is expired"
-	case stripe.ErrorCodeIncorrectCVC:
-		msg = "Incorrect zip/postal code"
-	case stripe.ErrorCodeAmountTooLarge:
-		msg = "The amount is too large to charge to your card"
-	case stripe.ErrorCodeAmountTooSmall:
-		msg = "The amount is too small to charge to your card"
-	case stripe.ErrorCodeBalanceInsufficient:
-		msg = "Insufficent balance"
-	case stripe.ErrorCodePostalCodeInvalid:
-		msg = "Your postal code is invalid"
-	default:
-		msg = "Your card was declined"
-	}
-	return msg
+// ChangeSubscriptionPlan moves the subscription to another plan, the difference for the
+// rest of the period is prorated on the next invoice
+func (c *Card) ChangeSubscriptionPlan(subID, plan, idempotencyKey string) (*stripe.Subscription, error) {
+	stripe.Key = c.Secret
+	subscription, err := sub.Get(subID, nil)
+	if err != nil {
+		return nil, err
+	}
+	if subscription.Items == nil || len(subscription.Items.Data) == 0 {
+		return nil, fmt.Errorf("subscription %s has no items", subID)
+	}
+
+	params := &stripe.SubscriptionParams{
+		Items: []*stripe.SubscriptionItemsParams{
+			{
+				ID:   stripe.String(subscription.Items.Data[0].ID),
+				Plan: stripe.String(plan),
+			},
+		},
+		ProrationBehavior: stripe.String(string(stripe.SubscriptionProrationBehaviorCreateProrations)),
+	}
+	if idempotencyKey != "" {
+		params.SetIdempotencyKey(idempotencyKey)
+	}
+
+	return sub.Update(subID, params)
+}
+
+// PauseSubscription stops collecting payments, invoices made while paused are voided
+func (c *Card) PauseSubscription(subID string) (*stripe.Subscription, error) {
+	stripe.Key = c.Secret
+	params := &stripe.SubscriptionParams{
+		PauseCollection: &stripe.SubscriptionPauseCollectionParams{
+			Behavior: stripe.String(string(stripe.SubscriptionPauseCollectionBehaviorVoid)),
+		},
+	}
+	return sub.Update(subID, params)
+}
+
+// ResumeSubscription collects payments of a paused subscription again
+func (c *Card) ResumeSubscription(subID string) (*stripe.Subscription, error) {
+	stripe.Key = c.Secret
+	params := &stripe.SubscriptionParams{}
+	// an empty pause_collection clears it
+	params.AddExtra("pause_collection", "")
+	return sub.Update(subID, params)
+}
+
+// GetSubscription returns the subscription as the gateway has it
+func (c *Card) GetSubscription(subID string) (*stripe.Subscription, error) {
+	stripe.Key = c.Secret
+	return sub.Get(subID, nil)
+}
+
+// RetryInvoice tries to collect an open invoice again with the default payment method of the customer
+func (c *Card) RetryInvoice(invoiceID string) (*stripe.Invoice, error) {
+	stripe.Key = c.Secret
+	params := &stripe.InvoicePayParams{
+		OffSession: stripe.Bool(true),
+	}
+	return invoice.Pay(invoiceID, params)
+}
+
+// CancelSubscriptionNow ends the subscription right away instead of at the end of the period
+func (c *Card) CancelSubscriptionNow(subID string) (*stripe.Subscription, error) {
+	stripe.Key = c.Secret
+	return sub.Cancel(subID, nil)
+}
+
+// ExtendTrial moves the end of the trial of a subscription, nothing is prorated as nothing was paid yet
+func (c *Card) ExtendTrial(subID string, trialEnd time.Time) (*stripe.Subscription, error) {
+	stripe.Key = c.Secret
+	params := &stripe.SubscriptionParams{
+		TrialEnd:          stripe.Int64(trialEnd.Unix()),
+		ProrationBehavior: stripe.String(string(stripe.SubscriptionProrationBehaviorNone)),
+	}
+	return sub.Update(subID, params)
+}
+
+// EndTrial ends the trial of a subscription now, the first invoice is collected right away
+func (c *Card) EndTrial(subID string) (*stripe.Subscription, error) {
+	stripe.Key = c.Secret
+	params := &stripe.SubscriptionParams{
+		TrialEndNow:       stripe.Bool(true),
+		ProrationBehavior: stripe.String(string(stripe.SubscriptionProrationBehaviorNone)),
+	}
+	return sub.Update(subID, params)
+}
+
+// ReportUsage adds usage to the metered item of a subscription, the gateway bills it with the
+// invoice of the period the timestamp falls in
+func (c *Card) ReportUsage(subID string, quantity int, timestamp time.Time, idempotencyKey string) (*stripe.UsageRecord, error) {
+	stripe.Key = c.Secret
+	subscription, err := sub.Get(subID, nil)
+	if err != nil {
+		return nil, NewPaymentError(err)
+	}
+
+	itemID := ""
+	if subscription.Items != nil {
+		for _, item := range subscription.Items.Data {
+			if item.Plan != nil && item.Plan.UsageType == stripe.PlanUsageTypeMetered {
+				itemID = item.ID
+				break
+			}
+		}
+	}
+	if itemID == "" {
+		return nil, fmt.Errorf("subscription %s has no metered item", subID)
+	}
+
+	params := &stripe.UsageRecordParams{
+		SubscriptionItem: stripe.String(itemID),
+		Quantity:         stripe.Int64(int64(quantity)),
+		Timestamp:        stripe.Int64(timestamp.Unix()),
+		Action:           stripe.String(stripe.UsageRecordActionIncrement),
+	}
+	if idempotencyKey != "" {
+		params.SetIdempotencyKey(idempotencyKey)
+	}
+
+	record, err := usagerecord.New(params)
+	if err != nil {
+		return nil, NewPaymentError(err)
+	}
+	return record, nil
+}
+
+// GetSettlement returns the balance transaction of the charge of a payment intent, or of an invoice
+// for an invoice id, nil while the gateway has not settled the charge yet
+func (c *Card) GetSettlement(reference string) (*stripe.BalanceTransaction, error) {
+	stripe.Key = c.Secret
+
+	if strings.HasPrefix(reference, "in_") {
+		params := &stripe.InvoiceParams{}
+		params.AddExpand("charge.balance_transaction")
+		inv, err := invoice.Get(reference, params)
+		if err != nil {
+			return nil, err
+		}
+		if inv.Charge == nil {
+			return nil, nil
+		}
+		return inv.Charge.BalanceTransaction, nil
+	}
+
+	params := &stripe.PaymentIntentParams{}
+	params.AddExpand("charges.data.balance_transaction")
+	pi, err := paymentintent.Get(reference, params)
+	if err != nil {
+		return nil, err
+	}
+	if pi.Charges == nil {
+		return nil, nil
+	}
+	for _, ch := range pi.Charges.Data {
+		if ch.Captured && ch.BalanceTransaction != nil {
+			return ch.BalanceTransaction, nil
+		}
+	}
+	return nil, nil
+}
+
+// UploadDisputeFile uploads a file to be used as evidence of a dispute
+func (c *Card) UploadDisputeFile(filename string, r io.Reader) (*stripe.File, error) {
+	stripe.Key = c.Secret
+	params := &stripe.FileParams{
+		FileReader: r,
+		Filename:   stripe.String(filename),
+		Purpose:    stripe.String(string(stripe.FilePurposeDisputeEvidence)),
+	}
+	return file.New(params)
+}
+
+// UpdateDisputeEvidence saves the evidence of a dispute, files maps an evidence kind such as receipt
+// to an uploaded file id. Submit sends the evidence to the bank, it can't be changed after that.
+func (c *Card) UpdateDisputeEvidence(disputeID, text string, files map[string]string, submit bool) (*stripe.Dispute, error) {
+	stripe.Key = c.Secret
+
+	evidence := &stripe.DisputeEvidenceParams{}
+	if text != "" {
+		evidence.UncategorizedText = stripe.String(text)
+	}
+	for kind, id := range files {
+		switch kind {
+		case DisputeFileReceipt:
+			evidence.Receipt = stripe.String(id)
+		case DisputeFileCustomerCommunication:
+			evidence.CustomerCommunication = stripe.String(id)
+		case DisputeFileShippingDocumentation:
+			evidence.ShippingDocumentation = stripe.String(id)
+		case DisputeFileServiceDocumentation:
+			evidence.ServiceDocumentation = stripe.String(id)
+		case DisputeFileRefundPolicy:
+			evidence.RefundPolicy = stripe.String(id)
+		case DisputeFileCancellationPolicy:
+			evidence.CancellationPolicy = stripe.String(id)
+		default:
+			evidence.UncategorizedFile = stripe.String(id)
+		}
+	}
+
+	params := &stripe.DisputeParams{
+		Evidence: evidence,
+		Submit:   stripe.Bool(submit),
+	}
+	return dispute.Update(disputeID, params)
+}
+
+// createdRange is the gateway filter for objects created from the start up to, not including, the end
+func createdRange(from, to time.Time) *stripe.RangeQueryParams {
+	return &stripe.RangeQueryParams{
+		GreaterThanOrEqual: from.Unix(),
+		LesserThan:         to.Unix(),
+	}
+}
+
+// ListCharges returns the charges created in the range, all pages are read
+func (c *Card) ListCharges(from, to time.Time) ([]*stripe.Charge, error) {
+	stripe.Key = c.Secret
+	params := &stripe.ChargeListParams{
+		CreatedRange: createdRange(from, to),
+	}
+	params.Filters.AddFilter("limit", "", "100")
+
+	var charges []*stripe.Charge
+	i := charge.List(params)
+	for i.Next() {
+		charges = append(charges, i.Charge())
+	}
+	return charges, i.Err()
+}
+
+// ListRefunds returns the refunds created in the range, all pages are read
+func (c *Card) ListRefunds(from, to time.Time) ([]*stripe.Refund, error) {
+	stripe.Key = c.Secret
+	params := &stripe.RefundListParams{
+		CreatedRange: createdRange(from, to),
+	}
+	params.Filters.AddFilter("limit", "", "100")
+
+	var refunds []*stripe.Refund
+	i := refund.List(params)
+	for i.Next() {
+		refunds = append(refunds, i.Refund())
+	}
+	return refunds, i.Err()
+}
+
+// ListSubscriptions returns the subscriptions created in the range whatever their status, all pages are read
+func (c *Card) ListSubscriptions(from, to time.Time) ([]*stripe.Subscription, error) {
+	stripe.Key = c.Secret
+	params := &stripe.SubscriptionListParams{
+		CreatedRange: createdRange(from, to),
+		Status:       "all",
+	}
+	params.Filters.AddFilter("limit", "", "100")
+
+	var subscriptions []*stripe.Subscription
+	i := sub.List(params)
+	for i.Next() {
+		subscriptions = append(subscriptions, i.Subscription())
+	}
+	return subscriptions, i.Err()
 }
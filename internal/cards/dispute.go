@@ -0,0 +1,23 @@
+package cards
+
+// kinds of dispute evidence files, any other kind is sent as an uncategorized file
+const (
+	DisputeFileReceipt               = "receipt"
+	DisputeFileCustomerCommunication = "customer_communication"
+	DisputeFileShippingDocumentation = "shipping_documentation"
+	DisputeFileServiceDocumentation  = "service_documentation"
+	DisputeFileRefundPolicy          = "refund_policy"
+	DisputeFileCancellationPolicy    = "cancellation_policy"
+	DisputeFileUncategorized         = "uncategorized_file"
+)
+
+// DisputeFileKinds are the evidence file kinds the gateway knows
+var DisputeFileKinds = []string{
+	DisputeFileReceipt,
+	DisputeFileCustomerCommunication,
+	DisputeFileShippingDocumentation,
+	DisputeFileServiceDocumentation,
+	DisputeFileRefundPolicy,
+	DisputeFileCancellationPolicy,
+	DisputeFileUncategorized,
+}
@@ -0,0 +1,174 @@
+package cards
+
+import (
+	"errors"
+	"net"
+
+	"github.com/stripe/stripe-go"
+)
+
+// categories of payment errors, they tell the front end what kind of failure it is
+const (
+	CategoryDeclined       = "declined"
+	CategoryFraud          = "fraud"
+	CategoryInvalidCard    = "invalid_card"
+	CategoryAuthentication = "authentication_required"
+	CategoryInvalidRequest = "invalid_request"
+	CategoryRateLimited    = "rate_limited"
+	CategoryNetwork        = "network"
+	CategoryGateway        = "gateway"
+)
+
+// messages are the user-safe messages by message key, a fraud block gets the generic decline
+// message so the customer is not told why
+var messages = map[string]string{
+	"payment.declined":           "Your card was declined",
+	"payment.insufficient_funds": "Your card has insufficient funds",
+	"payment.limit_exceeded":     "Your card has exceeded its limit, please try another card",
+	"payment.expired_card":       "Your card is expired",
+	"payment.incorrect_cvc":      "Your card's security code is incorrect",
+	"payment.incorrect_number":   "Your card number is incorrect",
+	"payment.incorrect_zip":      "Your postal code is invalid",
+	"payment.invalid_expiry":     "Your card's expiration date is invalid",
+	"payment.processing_error":   "An error occurred while processing your card, please try again",
+	"payment.try_again_later":    "Your bank could not be reached, please try again later",
+	"payment.authentication":     "Your bank needs you to authenticate this payment",
+	"payment.amount_too_small":   "The amount is too small to charge to your card",
+	"payment.amount_too_large":   "The amount is too large to charge to your card",
+	"payment.rate_limited":       "Too many payment attempts, please wait a moment and try again",
+	"payment.network":            "We could not reach the payment gateway, please try again",
+	"payment.gateway":            "The payment gateway had a problem, please try again",
+	"payment.invalid_request":    "The payment could not be processed",
+}
+
+// PaymentError is a gateway failure classified for the front end and the logs, code and
+// decline code are the gateway ones, retryable is set when the same request may succeed later
+type PaymentError struct {
+	Category    string `json:"category"`
+	Code        string `json:"code,omitempty"`
+	DeclineCode string `json:"decline_code,omitempty"`
+	Retryable   bool   `json:"retryable"`
+	Message     string `json:"message"`
+	MessageKey  string `json:"message_key"`
+	err         error
+}
+
+func (e *PaymentError) Error() string {
+	if e.err != nil {
+		return e.err.Error()
+	}
+	return e.Message
+}
+
+func (e *PaymentError) Unwrap() error {
+	return e.err
+}
+
+func newPaymentError(category, key string, retryable bool, err error) *PaymentError {
+	return &PaymentError{
+		Category:   category,
+		Retryable:  retryable,
+		Message:    messages[key],
+		MessageKey: key,
+		err:        err,
+	}
+}
+
+// NewPaymentError classifies an error returned by the gateway, nil stays nil
+func NewPaymentError(err error) *PaymentError {
+	if err == nil {
+		return nil
+	}
+
+	var perr *PaymentError
+	if errors.As(err, &perr) {
+		return perr
+	}
+
+	var stripeErr *stripe.Error
+	if !errors.As(err, &stripeErr) {
+		var netErr net.Error
+		if errors.As(err, &netErr) {
+			return newPaymentError(CategoryNetwork, "payment.network", true, err)
+		}
+		return newPaymentError(CategoryGateway, "payment.gateway", false, err)
+	}
+
+	perr = classifyStripeError(stripeErr)
+	perr.Code = string(stripeErr.Code)
+	perr.DeclineCode = string(stripeErr.DeclineCode)
+	perr.err = err
+	return perr
+}
+
+func classifyStripeError(e *stripe.Error) *PaymentError {
+	switch e.Type {
+	case stripe.ErrorTypeAPIConnection:
+		return newPaymentError(CategoryNetwork, "payment.network", true, nil)
+	case stripe.ErrorTypeRateLimit:
+		return newPaymentError(CategoryRateLimited, "payment.rate_limited", true, nil)
+	case stripe.ErrorTypeAPI:
+		return newPaymentError(CategoryGateway, "payment.gateway", true, nil)
+	case stripe.ErrorTypeAuthentication, stripe.ErrorTypePermission:
+		// our keys are wrong, nothing the customer can do
+		return newPaymentError(CategoryGateway, "payment.gateway", false, nil)
+	case stripe.ErrorTypeCard:
+		return classifyDecline(e)
+	}
+
+	switch e.Code {
+	case stripe.ErrorCodeAmountTooSmall:
+		return newPaymentError(CategoryInvalidRequest, "payment.amount_too_small", false, nil)
+	case stripe.ErrorCodeAmountTooLarge:
+		return newPaymentError(CategoryInvalidRequest, "payment.amount_too_large", false, nil)
+	case stripe.ErrorCodeRateLimit:
+		return newPaymentError(CategoryRateLimited, "payment.rate_limited", true, nil)
+	}
+	if e.DeclineCode != "" {
+		return classifyDecline(e)
+	}
+	return newPaymentError(CategoryInvalidRequest, "payment.invalid_request", false, nil)
+}
+
+// classifyDecline sorts a card error by decline code first as it is the more precise one
+func classifyDecline(e *stripe.Error) *PaymentError {
+	switch e.DeclineCode {
+	case stripe.DeclineCodeFraudulent, stripe.DeclineCodeStolenCard, stripe.DeclineCodeLostCard,
+		stripe.DeclineCodePickupCard, stripe.DeclineCodeMerchantBlacklist, stripe.DeclineCodeSecurityViolation,
+		stripe.DeclineCodeRestrictedCard:
+		return newPaymentError(CategoryFraud, "payment.declined", false, nil)
+	case stripe.DeclineCodeInsufficientFunds:
+		return newPaymentError(CategoryDeclined, "payment.insufficient_funds", false, nil)
+	case stripe.DeclineCodeCardVelocityExceeded, stripe.DeclineCodeWithdrawalCountLimitExceeded:
+		return newPaymentError(CategoryDeclined, "payment.limit_exceeded", false, nil)
+	case stripe.DeclineCodeAuthenticationRequired:
+		return newPaymentError(CategoryAuthentication, "payment.authentication", false, nil)
+	case stripe.DeclineCodeIssuerNotAvailable, stripe.DeclineCodeTryAgainLater, stripe.DeclineCodeReenterTransaction:
+		return newPaymentError(CategoryDeclined, "payment.try_again_later", true, nil)
+	case stripe.DeclineCodeProcessingError:
+		return newPaymentError(CategoryDeclined, "payment.processing_error", true, nil)
+	}
+
+	switch e.Code {
+	case stripe.ErrorCodeExpiredCard:
+		return newPaymentError(CategoryInvalidCard, "payment.expired_card", false, nil)
+	case stripe.ErrorCodeIncorrectCVC, stripe.ErrorCodeInvalidCVC:
+		return newPaymentError(CategoryInvalidCard, "payment.incorrect_cvc", false, nil)
+	case stripe.ErrorCodeIncorrectNumber, stripe.ErrorCodeInvalidNumber:
+		return newPaymentError(CategoryInvalidCard, "payment.incorrect_number", false, nil)
+	case stripe.ErrorCodeIncorrectZip, stripe.ErrorCodePostalCodeInvalid:
+		return newPaymentError(CategoryInvalidCard, "payment.incorrect_zip", false, nil)
+	case stripe.ErrorCodeInvalidExpiryMonth, stripe.ErrorCodeInvalidExpiryYear:
+		return newPaymentError(CategoryInvalidCard, "payment.invalid_expiry", false, nil)
+	case stripe.ErrorCodeProcessingError:
+		return newPaymentError(CategoryDeclined, "payment.processing_error", true, nil)
+	case stripe.ErrorCodeAuthenticationRequired:
+		return newPaymentError(CategoryAuthentication, "payment.authentication", false, nil)
+	}
+	return newPaymentError(CategoryDeclined, "payment.declined", false, nil)
+}
+
+// errorMessage returns the user-safe message of a gateway error code
+func errorMessage(code stripe.ErrorCode, declineCode stripe.DeclineCode) string {
+	return classifyStripeError(&stripe.Error{Type: stripe.ErrorTypeCard, Code: code, DeclineCode: declineCode}).Message
+}
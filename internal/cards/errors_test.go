@@ -0,0 +1,63 @@
+package cards
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stripe/stripe-go"
+)
+
+func TestNewPaymentError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		category  string
+		key       string
+		retryable bool
+	}{
+		{"connection", &stripe.Error{Type: stripe.ErrorTypeAPIConnection}, CategoryNetwork, "payment.network", true},
+		{"rate limit", &stripe.Error{Type: stripe.ErrorTypeRateLimit}, CategoryRateLimited, "payment.rate_limited", true},
+		{"gateway", &stripe.Error{Type: stripe.ErrorTypeAPI}, CategoryGateway, "payment.gateway", true},
+		{"bad keys", &stripe.Error{Type: stripe.ErrorTypeAuthentication}, CategoryGateway, "payment.gateway", false},
+		{"no permission", &stripe.Error{Type: stripe.ErrorTypePermission}, CategoryGateway, "payment.gateway", false},
+		{"fraud", &stripe.Error{Type: stripe.ErrorTypeCard, DeclineCode: stripe.DeclineCodeStolenCard}, CategoryFraud, "payment.declined", false},
+		{"insufficient funds", &stripe.Error{Type: stripe.ErrorTypeCard, DeclineCode: stripe.DeclineCodeInsufficientFunds}, CategoryDeclined, "payment.insufficient_funds", false},
+		{"try again later", &stripe.Error{Type: stripe.ErrorTypeCard, DeclineCode: stripe.DeclineCodeTryAgainLater}, CategoryDeclined, "payment.try_again_later", true},
+		{"expired card", &stripe.Error{Type: stripe.ErrorTypeCard, Code: stripe.ErrorCodeExpiredCard}, CategoryInvalidCard, "payment.expired_card", false},
+		{"incorrect cvc", &stripe.Error{Type: stripe.ErrorTypeCard, Code: stripe.ErrorCodeIncorrectCVC}, CategoryInvalidCard, "payment.incorrect_cvc", false},
+		{"authentication", &stripe.Error{Type: stripe.ErrorTypeCard, Code: stripe.ErrorCodeAuthenticationRequired}, CategoryAuthentication, "payment.authentication", false},
+		{"other decline", &stripe.Error{Type: stripe.ErrorTypeCard, DeclineCode: stripe.DeclineCodeGenericDecline}, CategoryDeclined, "payment.declined", false},
+		{"amount too small", &stripe.Error{Type: stripe.ErrorTypeInvalidRequest, Code: stripe.ErrorCodeAmountTooSmall}, CategoryInvalidRequest, "payment.amount_too_small", false},
+		{"invalid request", &stripe.Error{Type: stripe.ErrorTypeInvalidRequest}, CategoryInvalidRequest, "payment.invalid_request", false},
+		{"network", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, CategoryNetwork, "payment.network", true},
+		{"other error", errors.New("boom"), CategoryGateway, "payment.gateway", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			perr := NewPaymentError(tt.err)
+			if perr.Category != tt.category || perr.MessageKey != tt.key || perr.Retryable != tt.retryable {
+				t.Errorf("got %s %s retryable %t, want %s %s retryable %t",
+					perr.Category, perr.MessageKey, perr.Retryable, tt.category, tt.key, tt.retryable)
+			}
+			if perr.Message == "" {
+				t.Errorf("no message for %s", perr.MessageKey)
+			}
+			if !errors.Is(perr, tt.err) {
+				t.Error("the gateway error is not wrapped")
+			}
+		})
+	}
+}
+
+func TestNewPaymentErrorPassesThrough(t *testing.T) {
+	if NewPaymentError(nil) != nil {
+		t.Error("nil error is not nil")
+	}
+
+	perr := newPaymentError(CategoryDeclined, "payment.declined", false, nil)
+	if got := NewPaymentError(perr); got != perr {
+		t.Errorf("got %v, want the same payment error", got)
+	}
+}
@@ -0,0 +1,867 @@
+package cards
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-go"
+)
+
+// fakeCard describes a test payment method known by the fake gateway
+type fakeCard struct {
+	Brand       stripe.PaymentMethodCardBrand
+	Last4       string
+	Code        stripe.ErrorCode
+	DeclineCode stripe.DeclineCode
+	// Authenticate cards need a 3-D Secure challenge before the payment succeeds
+	Authenticate bool
+}
+
+// fakeCards mirrors the stripe test payment methods, unknown ids behave like pm_card_visa
+var fakeCards = map[string]fakeCard{
+	"pm_card_visa":                   {Brand: stripe.PaymentMethodCardBrandVisa, Last4: "4242"},
+	"pm_card_mastercard":             {Brand: stripe.PaymentMethodCardBrandMastercard, Last4: "4444"},
+	"pm_card_amex":                   {Brand: stripe.PaymentMethodCardBrandAmex, Last4: "8431"},
+	"pm_card_authenticationRequired": {Brand: stripe.PaymentMethodCardBrandVisa, Last4: "3184", Authenticate: true},
+	"pm_card_threeDSecure2Required":  {Brand: stripe.PaymentMethodCardBrandVisa, Last4: "3220", Authenticate: true},
+	"pm_card_chargeDeclined": {
+		Brand: stripe.PaymentMethodCardBrandVisa, Last4: "0002",
+		Code: stripe.ErrorCodeCardDeclined, DeclineCode: stripe.DeclineCodeGenericDecline,
+	},
+	"pm_card_chargeDeclinedInsufficientFunds": {
+		Brand: stripe.PaymentMethodCardBrandVisa, Last4: "9995",
+		Code: stripe.ErrorCodeCardDeclined, DeclineCode: stripe.DeclineCodeInsufficientFunds,
+	},
+	"pm_card_chargeDeclinedFraudulent": {
+		Brand: stripe.PaymentMethodCardBrandVisa, Last4: "0019",
+		Code: stripe.ErrorCodeCardDeclined, DeclineCode: stripe.DeclineCodeFraudulent,
+	},
+	"pm_card_chargeDeclinedExpiredCard": {
+		Brand: stripe.PaymentMethodCardBrandVisa, Last4: "0069",
+		Code: stripe.ErrorCodeExpiredCard, DeclineCode: stripe.DeclineCodeExpiredCard,
+	},
+	"pm_card_chargeDeclinedIncorrectCvc": {
+		Brand: stripe.PaymentMethodCardBrandVisa, Last4: "0127",
+		Code: stripe.ErrorCodeIncorrectCVC, DeclineCode: stripe.DeclineCodeIncorrectCVC,
+	},
+	"pm_card_chargeDeclinedProcessingError": {
+		Brand: stripe.PaymentMethodCardBrandVisa, Last4: "0119",
+		Code: stripe.ErrorCodeProcessingError,
+	},
+}
+
+// FakeGateway is an in-memory PaymentGateway, it simulates intents, customers,
+// subscriptions, refunds and declines so the flows can run without stripe
+type FakeGateway struct {
+	mu            sync.Mutex
+	seq           int
+	intents       map[string]*stripe.PaymentIntent
+	customers     map[string]*stripe.Customer
+	subscriptions map[string]*stripe.Subscription
+	refunds       map[string][]*stripe.Refund
+	usage         map[string]int64
+	idempotent    map[string]string
+	// replies are the subscriptions returned by plan changes, replayed as they were sent
+	replies map[string]*stripe.Subscription
+}
+
+// NewFakeGateway returns an empty fake gateway
+func NewFakeGateway() *FakeGateway {
+	return &FakeGateway{
+		intents:       make(map[string]*stripe.PaymentIntent),
+		customers:     make(map[string]*stripe.Customer),
+		subscriptions: make(map[string]*stripe.Subscription),
+		refunds:       make(map[string][]*stripe.Refund),
+		usage:         make(map[string]int64),
+		idempotent:    make(map[string]string),
+		replies:       make(map[string]*stripe.Subscription),
+	}
+}
+
+// nextID returns a new unique id with the given prefix, callers must hold the lock
+func (f *FakeGateway) nextID(prefix string) string {
+	f.seq++
+	return fmt.Sprintf("%s_fake_%d", prefix, f.seq)
+}
+
+// replayed returns the id of the object created earlier with the idempotency key, callers must hold the lock
+func (f *FakeGateway) replayed(idempotencyKey string) (string, bool) {
+	if idempotencyKey == "" {
+		return "", false
+	}
+	id, ok := f.idempotent[idempotencyKey]
+	return id, ok
+}
+
+// remember stores the object id created with the idempotency key, callers must hold the lock
+func (f *FakeGateway) remember(idempotencyKey, id string) {
+	if idempotencyKey != "" {
+		f.idempotent[idempotencyKey] = id
+	}
+}
+
+func fakeError(code stripe.ErrorCode, declineCode stripe.DeclineCode, msg string) *stripe.Error {
+	errType := stripe.ErrorTypeInvalidRequest
+	status := http.StatusBadRequest
+	if declineCode != "" || code == stripe.ErrorCodeCardDeclined || code == stripe.ErrorCodeExpiredCard ||
+		code == stripe.ErrorCodeIncorrectCVC || code == stripe.ErrorCodeProcessingError {
+		errType = stripe.ErrorTypeCard
+		status = http.StatusPaymentRequired
+	}
+	return &stripe.Error{
+		Type:           errType,
+		Code:           code,
+		DeclineCode:    declineCode,
+		Msg:            msg,
+		HTTPStatusCode: status,
+	}
+}
+
+func fakeCardFor(pm string) fakeCard {
+	if c, ok := fakeCards[pm]; ok {
+		return c
+	}
+	return fakeCards["pm_card_visa"]
+}
+
+func (f *FakeGateway) Charge(currency string, amount int, metadata map[string]string, idempotencyKey string) (*stripe.PaymentIntent, string, error) {
+	return f.CreatePaymentItent(currency, amount, metadata, idempotencyKey)
+}
+
+func (f *FakeGateway) CreatePaymentItent(currency string, amount int, metadata map[string]string, idempotencyKey string) (*stripe.PaymentIntent, string, error) {
+	return f.createPaymentIntent(currency, amount, metadata, idempotencyKey, stripe.PaymentIntentCaptureMethodAutomatic)
+}
+
+func (f *FakeGateway) Authorize(currency string, amount int, metadata map[string]string, idempotencyKey string) (*stripe.PaymentIntent, string, error) {
+	return f.createPaymentIntent(currency, amount, metadata, idempotencyKey, stripe.PaymentIntentCaptureMethodManual)
+}
+
+func (f *FakeGateway) createPaymentIntent(currency string, amount int, metadata map[string]string, idempotencyKey string, capture stripe.PaymentIntentCaptureMethod) (*stripe.PaymentIntent, string, error) {
+	if amount < 50 {
+		perr := NewPaymentError(fakeError(stripe.ErrorCodeAmountTooSmall, "", "Amount must be at least 50 cents"))
+		return nil, perr.Message, perr
+	}
+	if amount > 99999999 {
+		perr := NewPaymentError(fakeError(stripe.ErrorCodeAmountTooLarge, "", "Amount must be no more than 999,999.99"))
+		return nil, perr.Message, perr
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if id, ok := f.replayed(idempotencyKey); ok {
+		cp := *f.intents[id]
+		return &cp, "", nil
+	}
+
+	id := f.nextID("pi")
+	pi := &stripe.PaymentIntent{
+		ID:            id,
+		Amount:        int64(amount),
+		Currency:      currency,
+		ClientSecret:  id + "_secret_fake",
+		Created:       time.Now().Unix(),
+		Status:        stripe.PaymentIntentStatusRequiresPaymentMethod,
+		CaptureMethod: capture,
+		Charges:       &stripe.ChargeList{},
+		Metadata:      map[string]string{},
+	}
+	for k, v := range metadata {
+		pi.Metadata[k] = v
+	}
+	f.intents[id] = pi
+	f.remember(idempotencyKey, id)
+
+	cp := *pi
+	return &cp, "", nil
+}
+
+// ConfirmPaymentIntent simulates stripe.js confirming an intent with a payment method, cards that
+// need authentication stop at requires_action and succeed when confirmed again after the challenge
+func (f *FakeGateway) ConfirmPaymentIntent(id, pm string) (*stripe.PaymentIntent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pi, ok := f.intents[id]
+	if !ok {
+		return nil, fakeError(stripe.ErrorCodeResourceMissing, "", fmt.Sprintf("No such payment_intent: '%s'", id))
+	}
+	if pi.Status != stripe.PaymentIntentStatusRequiresPaymentMethod && pi.Status != stripe.PaymentIntentStatusRequiresAction {
+		return nil, fakeError(stripe.ErrorCodePaymentIntentUnexpectedState, "", fmt.Sprintf("This PaymentIntent's status is %s", pi.Status))
+	}
+
+	if err := f.confirm(pi, pm); err != nil {
+		return nil, err
+	}
+
+	cp := *pi
+	return &cp, nil
+}
+
+// confirm charges the payment method for the intent, callers must hold the lock
+func (f *FakeGateway) confirm(pi *stripe.PaymentIntent, pm string) error {
+	card := fakeCardFor(pm)
+	if card.Code != "" {
+		stripeErr := fakeError(card.Code, card.DeclineCode, errorMessage(card.Code, card.DeclineCode))
+		pi.LastPaymentError = stripeErr
+		pi.Status = stripe.PaymentIntentStatusRequiresPaymentMethod
+		return NewPaymentError(stripeErr)
+	}
+
+	if card.Authenticate && pi.Status == stripe.PaymentIntentStatusRequiresPaymentMethod {
+		pi.PaymentMethod = &stripe.PaymentMethod{ID: pm}
+		pi.Status = stripe.PaymentIntentStatusRequiresAction
+		pi.NextAction = &stripe.PaymentIntentNextAction{Type: "use_stripe_sdk"}
+		return nil
+	}
+	pi.NextAction = nil
+
+	charge := &stripe.Charge{
+		ID:            f.nextID("ch"),
+		Amount:        pi.Amount,
+		Currency:      stripe.Currency(pi.Currency),
+		Captured:      true,
+		Paid:          true,
+		Status:        "succeeded",
+		Created:       time.Now().Unix(),
+		PaymentIntent: pi.ID,
+		Refunds:       &stripe.RefundList{},
+	}
+	pi.PaymentMethod = &stripe.PaymentMethod{ID: pm}
+	pi.Charges.Data = append(pi.Charges.Data, charge)
+	pi.LastPaymentError = nil
+
+	if pi.CaptureMethod == stripe.PaymentIntentCaptureMethodManual {
+		// the amount is only held until it is captured
+		charge.Captured = false
+		pi.AmountCapturable = pi.Amount
+		pi.Status = stripe.PaymentIntentStatusRequiresCapture
+	} else {
+		pi.AmountReceived = pi.Amount
+		pi.Status = stripe.PaymentIntentStatusSucceeded
+	}
+
+	f.updateInvoice(pi)
+	return nil
+}
+
+func (f *FakeGateway) CapturePaymentIntent(id string, amount int, idempotencyKey string) (*stripe.PaymentIntent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pi, ok := f.intents[id]
+	if !ok {
+		return nil, fakeError(stripe.ErrorCodeResourceMissing, "", fmt.Sprintf("No such payment_intent: '%s'", id))
+	}
+	if _, ok := f.replayed(idempotencyKey); ok {
+		cp := *pi
+		return &cp, nil
+	}
+	if pi.Status != stripe.PaymentIntentStatusRequiresCapture {
+		return nil, fakeError(stripe.ErrorCodePaymentIntentUnexpectedState, "", fmt.Sprintf("This PaymentIntent could not be captured because it has a status of %s", pi.Status))
+	}
+	if amount == 0 {
+		amount = int(pi.AmountCapturable)
+	}
+	if amount < 0 || int64(amount) > pi.AmountCapturable {
+		return nil, fakeError(stripe.ErrorCodeAmountTooLarge, "", fmt.Sprintf("The amount to capture (%d) is greater than the capturable amount (%d)", amount, pi.AmountCapturable))
+	}
+
+	charge := pi.Charges.Data[0]
+	charge.Captured = true
+	charge.Amount = int64(amount)
+	pi.AmountCapturable = 0
+	pi.AmountReceived = int64(amount)
+	pi.Status = stripe.PaymentIntentStatusSucceeded
+	f.remember(idempotencyKey, pi.ID)
+
+	cp := *pi
+	return &cp, nil
+}
+
+func (f *FakeGateway) CancelPaymentIntent(id string) (*stripe.PaymentIntent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pi, ok := f.intents[id]
+	if !ok {
+		return nil, fakeError(stripe.ErrorCodeResourceMissing, "", fmt.Sprintf("No such payment_intent: '%s'", id))
+	}
+	if pi.Status == stripe.PaymentIntentStatusSucceeded || pi.Status == stripe.PaymentIntentStatusCanceled {
+		return nil, fakeError(stripe.ErrorCodePaymentIntentUnexpectedState, "", fmt.Sprintf("You cannot cancel this PaymentIntent because it has a status of %s", pi.Status))
+	}
+
+	pi.AmountCapturable = 0
+	pi.Status = stripe.PaymentIntentStatusCanceled
+	pi.CanceledAt = time.Now().Unix()
+
+	cp := *pi
+	return &cp, nil
+}
+
+// get payment method by id
+func (f *FakeGateway) GetPaymentMethod(s string) (*stripe.PaymentMethod, error) {
+	card := fakeCardFor(s)
+	return &stripe.PaymentMethod{
+		ID:   s,
+		Type: stripe.PaymentMethodTypeCard,
+		Card: &stripe.PaymentMethodCard{
+			Brand:    card.Brand,
+			Last4:    card.Last4,
+			ExpMonth: 12,
+			ExpYear:  uint64(time.Now().Year() + 3),
+		},
+	}, nil
+}
+
+// retrive payment intent gets an existing by id
+func (f *FakeGateway) RetriveGetPaymentIntent(id string) (*stripe.PaymentIntent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pi, ok := f.intents[id]
+	if !ok {
+		return nil, fakeError(stripe.ErrorCodeResourceMissing, "", fmt.Sprintf("No such payment_intent: '%s'", id))
+	}
+	cp := *pi
+	return &cp, nil
+}
+
+func (f *FakeGateway) SubscribeToPlan(cust *stripe.Customer, plan, email, last4, cardType, coupon string, trialDays int, idempotencyKey string) (*stripe.Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if id, ok := f.replayed(idempotencyKey); ok {
+		cp := *f.subscriptions[id]
+		return &cp, nil
+	}
+
+	if cust == nil {
+		return nil, fakeError(stripe.ErrorCodeParameterMissing, "", "Missing required param: customer")
+	}
+	if _, ok := f.customers[cust.ID]; !ok {
+		return nil, fakeError(stripe.ErrorCodeResourceMissing, "", fmt.Sprintf("No such customer: '%s'", cust.ID))
+	}
+	if plan == "" {
+		return nil, fakeError(stripe.ErrorCodeParameterMissing, "", "Missing required param: items[0][plan]")
+	}
+
+	now := time.Now()
+	subscription := &stripe.Subscription{
+		ID:                 f.nextID("sub"),
+		Object:             "subscription",
+		Customer:           &stripe.Customer{ID: cust.ID},
+		Plan:               &stripe.Plan{ID: plan},
+		Status:             stripe.SubscriptionStatusActive,
+		Created:            now.Unix(),
+		StartDate:          now.Unix(),
+		CurrentPeriodStart: now.Unix(),
+		CurrentPeriodEnd:   now.AddDate(0, 1, 0).Unix(),
+		Metadata: map[string]string{
+			"last_four": last4,
+			"card_type": cardType,
+		},
+	}
+	if coupon != "" {
+		subscription.Discount = &stripe.Discount{Coupon: &stripe.Coupon{ID: coupon}}
+	}
+	f.subscriptions[subscription.ID] = subscription
+	if trialDays > 0 {
+		trialEnd := now.AddDate(0, 0, trialDays)
+		subscription.Status = stripe.SubscriptionStatusTrialing
+		subscription.TrialStart = now.Unix()
+		subscription.TrialEnd = trialEnd.Unix()
+		subscription.CurrentPeriodEnd = trialEnd.Unix()
+	} else {
+		f.payFirstInvoice(subscription, f.customers[cust.ID])
+	}
+	f.remember(idempotencyKey, subscription.ID)
+
+	cp := *subscription
+	return &cp, nil
+}
+
+// payFirstInvoice invoices a new subscription and charges the default payment method of the
+// customer, a card that needs authentication leaves the subscription incomplete until its
+// invoice payment intent is confirmed again. Callers must hold the lock.
+func (f *FakeGateway) payFirstInvoice(subscription *stripe.Subscription, cust *stripe.Customer) {
+	pm := "pm_card_visa"
+	if cust.InvoiceSettings != nil && cust.InvoiceSettings.DefaultPaymentMethod != nil {
+		pm = cust.InvoiceSettings.DefaultPaymentMethod.ID
+	}
+
+	invoiceID := f.nextID("in")
+	id := f.nextID("pi")
+	// the fake gateway doesn't know the price of a plan, the invoice is for 0
+	pi := &stripe.PaymentIntent{
+		ID:            id,
+		Currency:      string(stripe.CurrencyUSD),
+		ClientSecret:  id + "_secret_fake",
+		Created:       time.Now().Unix(),
+		Status:        stripe.PaymentIntentStatusRequiresPaymentMethod,
+		CaptureMethod: stripe.PaymentIntentCaptureMethodAutomatic,
+		Charges:       &stripe.ChargeList{},
+		Metadata:      map[string]string{},
+		Invoice:       &stripe.Invoice{ID: invoiceID, Subscription: &stripe.Subscription{ID: subscription.ID}},
+	}
+	f.intents[id] = pi
+
+	subscription.Status = stripe.SubscriptionStatusIncomplete
+	subscription.LatestInvoice = &stripe.Invoice{
+		ID:           invoiceID,
+		Customer:     &stripe.Customer{ID: cust.ID},
+		Subscription: &stripe.Subscription{ID: subscription.ID},
+		Status:       stripe.InvoiceStatusOpen,
+	}
+	// a declined card stays on the invoice as its last payment error
+	_ = f.confirm(pi, pm)
+	f.updateInvoice(pi)
+}
+
+// updateInvoice puts the state of an invoice payment intent on the latest invoice of its
+// subscription, a paid invoice makes the subscription active. Callers must hold the lock.
+func (f *FakeGateway) updateInvoice(pi *stripe.PaymentIntent) {
+	if pi.Invoice == nil || pi.Invoice.Subscription == nil {
+		return
+	}
+	subscription, ok := f.subscriptions[pi.Invoice.Subscription.ID]
+	if !ok || subscription.LatestInvoice == nil || subscription.LatestInvoice.ID != pi.Invoice.ID {
+		return
+	}
+
+	// copies handed out before keep the invoice as it was
+	invoice := *subscription.LatestInvoice
+	intent := *pi
+	invoice.PaymentIntent = &intent
+	if pi.Status == stripe.PaymentIntentStatusSucceeded {
+		invoice.Paid = true
+		invoice.Status = stripe.InvoiceStatusPaid
+		invoice.AmountPaid = pi.Amount
+		invoice.Charge = &stripe.Charge{ID: pi.Charges.Data[0].ID}
+		subscription.Status = stripe.SubscriptionStatusActive
+	}
+	subscription.LatestInvoice = &invoice
+}
+
+func (f *FakeGateway) CreateCustomer(customerID, pm, email, idempotencyKey string) (*stripe.Customer, string, error) {
+	card := fakeCardFor(pm)
+	if card.Code != "" {
+		perr := NewPaymentError(fakeError(card.Code, card.DeclineCode, errorMessage(card.Code, card.DeclineCode)))
+		return nil, perr.Message, perr
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if customerID != "" {
+		cust, ok := f.customers[customerID]
+		if !ok {
+			return nil, "", fakeError(stripe.ErrorCodeResourceMissing, "", fmt.Sprintf("No such customer: '%s'", customerID))
+		}
+		cust.InvoiceSettings = &stripe.CustomerInvoiceSettings{DefaultPaymentMethod: &stripe.PaymentMethod{ID: pm}}
+		cp := *cust
+		return &cp, "", nil
+	}
+
+	if id, ok := f.replayed(idempotencyKey); ok {
+		cp := *f.customers[id]
+		return &cp, "", nil
+	}
+
+	cust := &stripe.Customer{
+		ID:              f.nextID("cus"),
+		Email:           email,
+		Created:         time.Now().Unix(),
+		InvoiceSettings: &stripe.CustomerInvoiceSettings{DefaultPaymentMethod: &stripe.PaymentMethod{ID: pm}},
+	}
+	f.customers[cust.ID] = cust
+	f.remember(idempotencyKey, cust.ID)
+
+	cp := *cust
+	return &cp, "", nil
+}
+
+func (f *FakeGateway) Refund(pi string, amount int, idempotencyKey string) (*stripe.Refund, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if id, ok := f.replayed(idempotencyKey); ok {
+		for _, r := range f.refunds[pi] {
+			if r.ID == id {
+				cp := *r
+				return &cp, nil
+			}
+		}
+	}
+
+	intent, ok := f.intents[pi]
+	if !ok {
+		return nil, fakeError(stripe.ErrorCodeResourceMissing, "", fmt.Sprintf("No such payment_intent: '%s'", pi))
+	}
+	if intent.Status != stripe.PaymentIntentStatusSucceeded || len(intent.Charges.Data) == 0 {
+		return nil, fakeError(stripe.ErrorCodePaymentIntentUnexpectedState, "", "This PaymentIntent does not have a successful charge to refund")
+	}
+
+	charge := intent.Charges.Data[0]
+	if charge.Refunded {
+		return nil, fakeError(stripe.ErrorCodeChargeAlreadyRefunded, "", fmt.Sprintf("Charge %s has already been refunded", charge.ID))
+	}
+	if amount <= 0 || int64(amount) > charge.Amount-charge.AmountRefunded {
+		return nil, fakeError(stripe.ErrorCodeAmountTooLarge, "", fmt.Sprintf("Refund amount (%d) is greater than unrefunded amount on charge (%d)", amount, charge.Amount-charge.AmountRefunded))
+	}
+
+	r := &stripe.Refund{
+		ID:            f.nextID("re"),
+		Amount:        int64(amount),
+		Currency:      charge.Currency,
+		Charge:        &stripe.Charge{ID: charge.ID},
+		PaymentIntent: &stripe.PaymentIntent{ID: pi},
+		Status:        stripe.RefundStatusSucceeded,
+		Created:       time.Now().Unix(),
+	}
+	f.refunds[pi] = append(f.refunds[pi], r)
+	f.remember(idempotencyKey, r.ID)
+
+	charge.AmountRefunded += int64(amount)
+	charge.Refunded = charge.AmountRefunded == charge.Amount
+	charge.Refunds.Data = append(charge.Refunds.Data, r)
+
+	cp := *r
+	return &cp, nil
+}
+
+func (f *FakeGateway) CancelSubscription(subID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	subscription, ok := f.subscriptions[subID]
+	if !ok {
+		return fakeError(stripe.ErrorCodeResourceMissing, "", fmt.Sprintf("No such subscription: '%s'", subID))
+	}
+	if subscription.Status == stripe.SubscriptionStatusCanceled {
+		return fakeError(stripe.ErrorCodeResourceMissing, "", "A canceled subscription can only update its cancellation_details and metadata")
+	}
+	subscription.CancelAtPeriodEnd = true
+	return nil
+}
+
+func (f *FakeGateway) ChangeSubscriptionPlan(subID, plan, idempotencyKey string) (*stripe.Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if id, ok := f.replayed(idempotencyKey); ok {
+		if reply, ok := f.replies[idempotencyKey]; ok {
+			cp := *reply
+			return &cp, nil
+		}
+		return nil, fakeError(stripe.ErrorCodeResourceMissing, "", fmt.Sprintf("No such subscription: '%s'", id))
+	}
+
+	subscription, err := f.activeSubscription(subID)
+	if err != nil {
+		return nil, err
+	}
+	if plan == "" {
+		return nil, fakeError(stripe.ErrorCodeParameterMissing, "", "Missing required param: items[0][plan]")
+	}
+
+	subscription.Plan = &stripe.Plan{ID: plan}
+	f.remember(idempotencyKey, subscription.ID)
+	if idempotencyKey != "" {
+		reply := *subscription
+		f.replies[idempotencyKey] = &reply
+	}
+
+	cp := *subscription
+	return &cp, nil
+}
+
+func (f *FakeGateway) PauseSubscription(subID string) (*stripe.Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	subscription, err := f.activeSubscription(subID)
+	if err != nil {
+		return nil, err
+	}
+	subscription.PauseCollection = stripe.SubscriptionPauseCollection{Behavior: stripe.SubscriptionPauseCollectionBehaviorVoid}
+
+	cp := *subscription
+	return &cp, nil
+}
+
+func (f *FakeGateway) ResumeSubscription(subID string) (*stripe.Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	subscription, err := f.activeSubscription(subID)
+	if err != nil {
+		return nil, err
+	}
+	subscription.PauseCollection = stripe.SubscriptionPauseCollection{}
+
+	cp := *subscription
+	return &cp, nil
+}
+
+func (f *FakeGateway) GetSubscription(subID string) (*stripe.Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	subscription, ok := f.subscriptions[subID]
+	if !ok {
+		return nil, fakeError(stripe.ErrorCodeResourceMissing, "", fmt.Sprintf("No such subscription: '%s'", subID))
+	}
+
+	cp := *subscription
+	return &cp, nil
+}
+
+// RetryInvoice always collects the invoice, renewals are not invoiced by the fake gateway
+func (f *FakeGateway) RetryInvoice(invoiceID string) (*stripe.Invoice, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if invoiceID == "" {
+		return nil, fakeError(stripe.ErrorCodeResourceMissing, "", "No such invoice: ''")
+	}
+
+	return &stripe.Invoice{
+		ID:     invoiceID,
+		Paid:   true,
+		Status: stripe.InvoiceStatusPaid,
+	}, nil
+}
+
+func (f *FakeGateway) CancelSubscriptionNow(subID string) (*stripe.Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	subscription, err := f.activeSubscription(subID)
+	if err != nil {
+		return nil, err
+	}
+	subscription.Status = stripe.SubscriptionStatusCanceled
+	subscription.CanceledAt = time.Now().Unix()
+
+	cp := *subscription
+	return &cp, nil
+}
+
+func (f *FakeGateway) ExtendTrial(subID string, trialEnd time.Time) (*stripe.Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	subscription, err := f.activeSubscription(subID)
+	if err != nil {
+		return nil, err
+	}
+	if !trialEnd.After(time.Now()) {
+		return nil, fakeError(stripe.ErrorCodeParameterInvalidInteger, "", "Invalid timestamp: must be an integer Unix timestamp in the future")
+	}
+	subscription.Status = stripe.SubscriptionStatusTrialing
+	subscription.TrialEnd = trialEnd.Unix()
+	subscription.CurrentPeriodEnd = trialEnd.Unix()
+
+	cp := *subscription
+	return &cp, nil
+}
+
+// EndTrial starts the first paid period now, the fake gateway always collects its invoice
+func (f *FakeGateway) EndTrial(subID string) (*stripe.Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	subscription, err := f.activeSubscription(subID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	subscription.Status = stripe.SubscriptionStatusActive
+	subscription.TrialEnd = now.Unix()
+	subscription.CurrentPeriodStart = now.Unix()
+	subscription.CurrentPeriodEnd = now.AddDate(0, 1, 0).Unix()
+
+	cp := *subscription
+	return &cp, nil
+}
+
+// ReportUsage adds to the usage of a subscription, every fake subscription is taken to be metered
+func (f *FakeGateway) ReportUsage(subID string, quantity int, timestamp time.Time, idempotencyKey string) (*stripe.UsageRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if id, ok := f.replayed(idempotencyKey); ok {
+		return &stripe.UsageRecord{ID: id, Quantity: int64(quantity), SubscriptionItem: subID, Timestamp: timestamp.Unix()}, nil
+	}
+
+	subscription, err := f.activeSubscription(subID)
+	if err != nil {
+		return nil, err
+	}
+	if quantity < 0 {
+		return nil, fakeError(stripe.ErrorCodeParameterInvalidInteger, "", "Invalid integer: quantity must be positive")
+	}
+	if timestamp.Unix() < subscription.CurrentPeriodStart || timestamp.Unix() > subscription.CurrentPeriodEnd {
+		return nil, fakeError(stripe.ErrorCodeParameterInvalidInteger, "", "Cannot create the usage record with this timestamp because timestamps must be after the subscription's current period")
+	}
+
+	record := &stripe.UsageRecord{
+		ID:               f.nextID("mbur"),
+		Quantity:         int64(quantity),
+		SubscriptionItem: subID,
+		Timestamp:        timestamp.Unix(),
+	}
+	f.usage[subID] += int64(quantity)
+	f.remember(idempotencyKey, record.ID)
+
+	return record, nil
+}
+
+// ListCharges returns the charges of the intents created in the range
+func (f *FakeGateway) ListCharges(from, to time.Time) ([]*stripe.Charge, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var charges []*stripe.Charge
+	for _, pi := range f.intents {
+		if pi.Charges == nil {
+			continue
+		}
+		for _, c := range pi.Charges.Data {
+			if fakeCreatedIn(c.Created, from, to) {
+				cp := *c
+				charges = append(charges, &cp)
+			}
+		}
+	}
+	return charges, nil
+}
+
+func (f *FakeGateway) ListRefunds(from, to time.Time) ([]*stripe.Refund, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var refunds []*stripe.Refund
+	for _, list := range f.refunds {
+		for _, r := range list {
+			if fakeCreatedIn(r.Created, from, to) {
+				cp := *r
+				refunds = append(refunds, &cp)
+			}
+		}
+	}
+	return refunds, nil
+}
+
+func (f *FakeGateway) ListSubscriptions(from, to time.Time) ([]*stripe.Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var subscriptions []*stripe.Subscription
+	for _, s := range f.subscriptions {
+		if fakeCreatedIn(s.Created, from, to) {
+			cp := *s
+			subscriptions = append(subscriptions, &cp)
+		}
+	}
+	return subscriptions, nil
+}
+
+// GetSettlement settles captured charges at the standard card rate of 2.9% plus 30 cents,
+// available two days later. Invoices are not kept by the fake gateway so they are never settled.
+func (f *FakeGateway) GetSettlement(reference string) (*stripe.BalanceTransaction, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if strings.HasPrefix(reference, "in_") {
+		return nil, nil
+	}
+
+	pi, ok := f.intents[reference]
+	if !ok {
+		return nil, fakeError(stripe.ErrorCodeResourceMissing, "", fmt.Sprintf("No such payment_intent: '%s'", reference))
+	}
+	if pi.Charges == nil {
+		return nil, nil
+	}
+
+	for _, c := range pi.Charges.Data {
+		if !c.Captured {
+			continue
+		}
+		fee := c.Amount*29/1000 + 30
+		return &stripe.BalanceTransaction{
+			ID:          "txn_" + c.ID,
+			Amount:      c.Amount,
+			Currency:    c.Currency,
+			Fee:         fee,
+			Net:         c.Amount - fee,
+			Created:     c.Created,
+			AvailableOn: time.Unix(c.Created, 0).AddDate(0, 0, 2).Unix(),
+			Status:      stripe.BalanceTransactionStatusPending,
+			Type:        stripe.BalanceTransactionTypeCharge,
+		}, nil
+	}
+	return nil, nil
+}
+
+// UploadDisputeFile reads the file and returns a new file id
+func (f *FakeGateway) UploadDisputeFile(filename string, r io.Reader) (*stripe.File, error) {
+	size, err := io.Copy(io.Discard, r)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return &stripe.File{
+		ID:       f.nextID("file"),
+		Filename: filename,
+		Purpose:  stripe.FilePurposeDisputeEvidence,
+		Size:     size,
+		Created:  time.Now().Unix(),
+	}, nil
+}
+
+// UpdateDisputeEvidence accepts any evidence, disputes are never opened by the fake gateway so
+// the dispute is only as far as the evidence takes it
+func (f *FakeGateway) UpdateDisputeEvidence(disputeID, text string, files map[string]string, submit bool) (*stripe.Dispute, error) {
+	if disputeID == "" {
+		return nil, fakeError(stripe.ErrorCodeResourceMissing, "", "No such dispute: ''")
+	}
+
+	d := &stripe.Dispute{
+		ID:     disputeID,
+		Status: stripe.DisputeStatusNeedsResponse,
+		EvidenceDetails: &stripe.EvidenceDetails{
+			HasEvidence: text != "" || len(files) > 0,
+		},
+	}
+	if submit {
+		d.Status = stripe.DisputeStatusUnderReview
+		d.EvidenceDetails.SubmissionCount = 1
+	}
+	return d, nil
+}
+
+func fakeCreatedIn(created int64, from, to time.Time) bool {
+	return created >= from.Unix() && created < to.Unix()
+}
+
+// activeSubscription returns a subscription that can still be changed, callers must hold the lock
+func (f *FakeGateway) activeSubscription(subID string) (*stripe.Subscription, error) {
+	subscription, ok := f.subscriptions[subID]
+	if !ok {
+		return nil, fakeError(stripe.ErrorCodeResourceMissing, "", fmt.Sprintf("No such subscription: '%s'", subID))
+	}
+	if subscription.Status == stripe.SubscriptionStatusCanceled {
+		return nil, fakeError(stripe.ErrorCodeResourceMissing, "", "A canceled subscription can only update its cancellation_details and metadata")
+	}
+	return subscription, nil
+}
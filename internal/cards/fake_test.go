@@ -0,0 +1,215 @@
+package cards
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stripe/stripe-go"
+)
+
+func TestFakeGatewayConfirmPaymentIntent(t *testing.T) {
+	tests := []struct {
+		name     string
+		pm       string
+		step     string
+		category string
+	}{
+		{"visa", "pm_card_visa", StepSucceeded, ""},
+		{"unknown card", "pm_card_unknown", StepSucceeded, ""},
+		{"authentication", "pm_card_authenticationRequired", StepAuthenticate, ""},
+		{"declined", "pm_card_chargeDeclined", StepRetry, CategoryDeclined},
+		{"insufficient funds", "pm_card_chargeDeclinedInsufficientFunds", StepRetry, CategoryDeclined},
+		{"fraud", "pm_card_chargeDeclinedFraudulent", StepRetry, CategoryFraud},
+		{"expired card", "pm_card_chargeDeclinedExpiredCard", StepRetry, CategoryInvalidCard},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewFakeGateway()
+			pi, _, err := f.Charge("usd", 1000, nil, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = f.ConfirmPaymentIntent(pi.ID, tt.pm)
+			var perr *PaymentError
+			if tt.category != "" {
+				if !errors.As(err, &perr) || perr.Category != tt.category {
+					t.Fatalf("got error %v, want a %s payment error", err, tt.category)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			pi, err = f.RetriveGetPaymentIntent(pi.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := NextStep(pi); got != tt.step {
+				t.Errorf("got step %s, want %s", got, tt.step)
+			}
+		})
+	}
+}
+
+func TestFakeGatewayAuthentication(t *testing.T) {
+	f := NewFakeGateway()
+	pi, _, err := f.Charge("usd", 1000, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pm := "pm_card_threeDSecure2Required"
+	pi, err = f.ConfirmPaymentIntent(pi.ID, pm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if NextStep(pi) != StepAuthenticate || pi.NextAction == nil {
+		t.Fatalf("got status %s, want a challenge", pi.Status)
+	}
+
+	// the challenge passed, stripe.js confirms again
+	pi, err = f.ConfirmPaymentIntent(pi.ID, pm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	charge, err := IntentCharge(pi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if charge.Amount != 1000 {
+		t.Errorf("got charge of %d, want 1000", charge.Amount)
+	}
+}
+
+func TestFakeGatewayCapture(t *testing.T) {
+	f := NewFakeGateway()
+	pi, _, err := f.Authorize("usd", 1000, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pi, err = f.ConfirmPaymentIntent(pi.ID, "pm_card_visa"); err != nil {
+		t.Fatal(err)
+	}
+	if NextStep(pi) != StepAuthorized {
+		t.Fatalf("got status %s, want requires_capture", pi.Status)
+	}
+
+	if pi, err = f.CapturePaymentIntent(pi.ID, 600, "capture"); err != nil {
+		t.Fatal(err)
+	}
+	if pi.AmountReceived != 600 || pi.Status != stripe.PaymentIntentStatusSucceeded {
+		t.Fatalf("got %d received with status %s, want 600 succeeded", pi.AmountReceived, pi.Status)
+	}
+
+	if _, err = f.Refund(pi.ID, 700, ""); err == nil {
+		t.Error("refunded more than was captured")
+	}
+	if _, err = f.Refund(pi.ID, 600, ""); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFakeGatewaySubscribeToPlan(t *testing.T) {
+	tests := []struct {
+		name      string
+		pm        string
+		trialDays int
+		status    stripe.SubscriptionStatus
+		step      string
+	}{
+		{"paid", "pm_card_visa", 0, stripe.SubscriptionStatusActive, StepSucceeded},
+		{"authentication", "pm_card_authenticationRequired", 0, stripe.SubscriptionStatusIncomplete, StepAuthenticate},
+		{"trial", "pm_card_authenticationRequired", 14, stripe.SubscriptionStatusTrialing, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewFakeGateway()
+			cust, _, err := f.CreateCustomer("", tt.pm, "me@here.com", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			subscription, err := f.SubscribeToPlan(cust, "price_fake", "me@here.com", "4242", "visa", "", tt.trialDays, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if subscription.Status != tt.status {
+				t.Errorf("got status %s, want %s", subscription.Status, tt.status)
+			}
+			if tt.step == "" {
+				if subscription.LatestInvoice != nil {
+					t.Error("a trial was invoiced")
+				}
+				return
+			}
+			if subscription.LatestInvoice == nil || subscription.LatestInvoice.PaymentIntent == nil {
+				t.Fatal("no invoice payment intent")
+			}
+			if got := NextStep(subscription.LatestInvoice.PaymentIntent); got != tt.step {
+				t.Errorf("got step %s, want %s", got, tt.step)
+			}
+		})
+	}
+}
+
+func TestFakeGatewaySubscriptionAuthentication(t *testing.T) {
+	f := NewFakeGateway()
+	pm := "pm_card_authenticationRequired"
+	cust, _, err := f.CreateCustomer("", pm, "me@here.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscription, err := f.SubscribeToPlan(cust, "price_fake", "me@here.com", "3184", "visa", "", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pi := subscription.LatestInvoice.PaymentIntent
+	if _, err = f.ConfirmPaymentIntent(pi.ID, pm); err != nil {
+		t.Fatal(err)
+	}
+
+	subscription, err = f.GetSubscription(subscription.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subscription.Status != stripe.SubscriptionStatusActive {
+		t.Errorf("got status %s, want active", subscription.Status)
+	}
+	if !subscription.LatestInvoice.Paid || NextStep(subscription.LatestInvoice.PaymentIntent) != StepSucceeded {
+		t.Error("the invoice was not paid")
+	}
+}
+
+func TestFakeGatewayChangeSubscriptionPlanReplay(t *testing.T) {
+	f := NewFakeGateway()
+	cust, _, err := f.CreateCustomer("", "pm_card_visa", "me@here.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscription, err := f.SubscribeToPlan(cust, "price_basic", "me@here.com", "4242", "visa", "", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := f.ChangeSubscriptionPlan(subscription.ID, "price_pro", "change")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.ChangeSubscriptionPlan(subscription.ID, "price_team", "other-change"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the replay returns what the key returned the first time, whatever it is sent with
+	for _, subID := range []string{subscription.ID, "sub_unknown"} {
+		replayed, err := f.ChangeSubscriptionPlan(subID, "price_team", "change")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if replayed.ID != changed.ID || replayed.Plan.ID != "price_pro" {
+			t.Errorf("got %s on %s, want %s on price_pro", replayed.ID, replayed.Plan.ID, changed.ID)
+		}
+	}
+}
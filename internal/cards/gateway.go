@@ -0,0 +1,58 @@
+package cards
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/stripe/stripe-go"
+)
+
+// PaymentGateway is the set of payment operations used by the handlers, an empty
+// idempotency key means the call is not idempotent
+type PaymentGateway interface {
+	Charge(currency string, amount int, metadata map[string]string, idempotencyKey string) (*stripe.PaymentIntent, string, error)
+	CreatePaymentItent(currency string, amount int, metadata map[string]string, idempotencyKey string) (*stripe.PaymentIntent, string, error)
+	Authorize(currency string, amount int, metadata map[string]string, idempotencyKey string) (*stripe.PaymentIntent, string, error)
+	CapturePaymentIntent(id string, amount int, idempotencyKey string) (*stripe.PaymentIntent, error)
+	CancelPaymentIntent(id string) (*stripe.PaymentIntent, error)
+	GetPaymentMethod(s string) (*stripe.PaymentMethod, error)
+	RetriveGetPaymentIntent(id string) (*stripe.PaymentIntent, error)
+	SubscribeToPlan(cust *stripe.Customer, plan, email, last4, cardType, coupon string, trialDays int, idempotencyKey string) (*stripe.Subscription, error)
+	CreateCustomer(customerID, pm, email, idempotencyKey string) (*stripe.Customer, string, error)
+	Refund(pi string, amount int, idempotencyKey string) (*stripe.Refund, error)
+	CancelSubscription(subID string) error
+	ChangeSubscriptionPlan(subID, plan, idempotencyKey string) (*stripe.Subscription, error)
+	PauseSubscription(subID string) (*stripe.Subscription, error)
+	ResumeSubscription(subID string) (*stripe.Subscription, error)
+	GetSubscription(subID string) (*stripe.Subscription, error)
+	RetryInvoice(invoiceID string) (*stripe.Invoice, error)
+	CancelSubscriptionNow(subID string) (*stripe.Subscription, error)
+	ExtendTrial(subID string, trialEnd time.Time) (*stripe.Subscription, error)
+	EndTrial(subID string) (*stripe.Subscription, error)
+	ReportUsage(subID string, quantity int, timestamp time.Time, idempotencyKey string) (*stripe.UsageRecord, error)
+	ListCharges(from, to time.Time) ([]*stripe.Charge, error)
+	ListRefunds(from, to time.Time) ([]*stripe.Refund, error)
+	ListSubscriptions(from, to time.Time) ([]*stripe.Subscription, error)
+	GetSettlement(reference string) (*stripe.BalanceTransaction, error)
+	UploadDisputeFile(filename string, r io.Reader) (*stripe.File, error)
+	UpdateDisputeEvidence(disputeID, text string, files map[string]string, submit bool) (*stripe.Dispute, error)
+}
+
+var _ PaymentGateway = (*Card)(nil)
+var _ PaymentGateway = (*FakeGateway)(nil)
+
+// NewGateway returns the payment gateway by name {stripe|fake}
+func NewGateway(name, secret, key string) (PaymentGateway, error) {
+	switch name {
+	case "", "stripe":
+		return &Card{
+			Secret: secret,
+			Key:    key,
+		}, nil
+	case "fake":
+		return NewFakeGateway(), nil
+	default:
+		return nil, fmt.Errorf("unknown payment gateway %q", name)
+	}
+}
@@ -0,0 +1,81 @@
+package cards
+
+import (
+	"errors"
+	"time"
+
+	"github.com/stripe/stripe-go"
+)
+
+// ErrPaymentIncomplete is returned when an order is placed for a payment intent that has not succeeded
+var ErrPaymentIncomplete = errors.New("the payment has not been completed")
+
+// what the client has to do next with a payment intent
+const (
+	StepSucceeded    = "succeeded"
+	StepAuthenticate = "authenticate"
+	StepRetry        = "retry"
+	StepProcessing   = "processing"
+	StepCanceled     = "canceled"
+	StepAuthorized   = "authorized"
+)
+
+// AuthorizationValidity is how long the gateway holds an authorized amount before it is released
+const AuthorizationValidity = 7 * 24 * time.Hour
+
+// NextStep tells from the status of a payment intent what the client has to do next,
+// a card that failed authentication or was declined goes back to requires_payment_method
+func NextStep(pi *stripe.PaymentIntent) string {
+	switch pi.Status {
+	case stripe.PaymentIntentStatusSucceeded:
+		return StepSucceeded
+	case stripe.PaymentIntentStatusRequiresAction, stripe.PaymentIntentStatusRequiresConfirmation:
+		return StepAuthenticate
+	case stripe.PaymentIntentStatusProcessing:
+		return StepProcessing
+	case stripe.PaymentIntentStatusCanceled:
+		return StepCanceled
+	case stripe.PaymentIntentStatusRequiresCapture:
+		return StepAuthorized
+	default:
+		return StepRetry
+	}
+}
+
+// IntentMessage returns the message to show the customer for an intent that did not succeed
+func IntentMessage(pi *stripe.PaymentIntent) string {
+	switch NextStep(pi) {
+	case StepAuthenticate:
+		return "Your bank needs you to authenticate this payment"
+	case StepProcessing:
+		return "Your payment is processing"
+	case StepCanceled:
+		return "The payment was cancelled"
+	case StepAuthorized:
+		return "The amount is held on the card until it is captured"
+	case StepRetry:
+		if pi.LastPaymentError != nil {
+			return NewPaymentError(pi.LastPaymentError).Message
+		}
+		return "Please try another card"
+	}
+	return ""
+}
+
+// IntentCharge returns the charge of a succeeded payment intent, or ErrPaymentIncomplete
+func IntentCharge(pi *stripe.PaymentIntent) (*stripe.Charge, error) {
+	if pi.Status != stripe.PaymentIntentStatusSucceeded || pi.Charges == nil || len(pi.Charges.Data) == 0 {
+		return nil, ErrPaymentIncomplete
+	}
+	return pi.Charges.Data[0], nil
+}
+
+// IntentAuthorization returns the charge of an authorized intent waiting to be captured and when
+// the authorization expires, or ErrPaymentIncomplete
+func IntentAuthorization(pi *stripe.PaymentIntent) (*stripe.Charge, time.Time, error) {
+	if pi.Status != stripe.PaymentIntentStatusRequiresCapture || pi.Charges == nil || len(pi.Charges.Data) == 0 {
+		return nil, time.Time{}, ErrPaymentIncomplete
+	}
+	charge := pi.Charges.Data[0]
+	return charge, time.Unix(charge.Created, 0).Add(AuthorizationValidity), nil
+}
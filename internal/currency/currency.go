@@ -0,0 +1,102 @@
+package currency
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultCurrency is the currency of Widget.Price
+const DefaultCurrency = "cad"
+
+// Currency is the type for a currency we sell in
+type Currency struct {
+	Code       string `json:"code"`
+	Name       string `json:"name"`
+	Symbol     string `json:"symbol"`
+	MinorUnits int    `json:"minor_units"`
+}
+
+// registry holds the supported currencies by lower case code, amounts are always
+// stored and charged in minor units, zero-decimal currencies have no minor units
+var registry = map[string]Currency{
+	"cad": {Code: "cad", Name: "Canadian Dollar", Symbol: "$", MinorUnits: 2},
+	"usd": {Code: "usd", Name: "US Dollar", Symbol: "US$", MinorUnits: 2},
+	"eur": {Code: "eur", Name: "Euro", Symbol: "€", MinorUnits: 2},
+	"idr": {Code: "idr", Name: "Indonesian Rupiah", Symbol: "Rp", MinorUnits: 2},
+	"jpy": {Code: "jpy", Name: "Japanese Yen", Symbol: "¥", MinorUnits: 0},
+}
+
+// Get returns a supported currency by code
+func Get(code string) (Currency, bool) {
+	c, ok := registry[strings.ToLower(code)]
+	return c, ok
+}
+
+// Valid reports whether the currency code is supported
+func Valid(code string) bool {
+	_, ok := Get(code)
+	return ok
+}
+
+// All returns the supported currencies sorted by code
+func All() []Currency {
+	all := make([]Currency, 0, len(registry))
+	for _, c := range registry {
+		all = append(all, c)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Code < all[j].Code
+	})
+	return all
+}
+
+// Format formats an amount in minor units, e.g. 1000 cad is $10.00 and 1000 jpy is ¥1,000
+func (c Currency) Format(amount int) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	factor := 1
+	for i := 0; i < c.MinorUnits; i++ {
+		factor *= 10
+	}
+
+	major := groupThousands(amount / factor)
+	if c.MinorUnits == 0 {
+		return fmt.Sprintf("%s%s%s", sign, c.Symbol, major)
+	}
+	return fmt.Sprintf("%s%s%s.%0*d", sign, c.Symbol, major, c.MinorUnits, amount%factor)
+}
+
+// Format formats an amount in minor units of the currency code, unknown codes
+// are shown with the code instead of a symbol
+func Format(amount int, code string) string {
+	c, ok := Get(code)
+	if !ok {
+		c = Currency{Code: code, Symbol: strings.ToUpper(code) + " ", MinorUnits: 2}
+	}
+	return c.Format(amount)
+}
+
+func groupThousands(n int) string {
+	s := fmt.Sprintf("%d", n)
+	if len(s) <= 3 {
+		return s
+	}
+
+	var b strings.Builder
+	pre := len(s) % 3
+	if pre > 0 {
+		b.WriteString(s[:pre])
+	}
+	for i := pre; i < len(s); i += 3 {
+		if b.Len() > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(s[i : i+3])
+	}
+	return b.String()
+}
@@ -0,0 +1,408 @@
+// Package migrations keeps the database schema in ordered up and down SQL files embedded in
+// the binaries. A file is named like 0001_create_widgets.up.sql, its statements end with a
+// semicolon at the end of a line and are run one by one.
+//
+// A database made before the migrations has its tables but no schema_migrations table, running
+// up on it fails on the first table that exists. Baseline records the migrations its schema
+// already has as applied, after that up only runs the newer ones.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// Dir is where the migrations are kept in the repository, new ones are created there
+const Dir = "internal/migrations/sql"
+
+// ErrPending is returned by Check when the database is behind the migrations of the binary
+var ErrPending = errors.New("database migrations are pending")
+
+// ErrDirty is returned when a migration failed half way, MySQL can't roll back schema changes
+// so the database has to be put right by hand and then either the row removed from
+// schema_migrations or the migration marked as applied with Baseline
+var ErrDirty = errors.New("a database migration failed half way")
+
+// ErrUntracked is returned by Check when the database has no schema_migrations table yet, it
+// was made before the migrations or they were never run on it
+var ErrUntracked = errors.New("database migrations are not tracked yet")
+
+var fileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is one version of the schema
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a migration and whether it was applied to the database
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+	Dirty     bool
+}
+
+// applied is a row of the schema_migrations table
+type applied struct {
+	at    time.Time
+	dirty bool
+}
+
+// All returns the embedded migrations ordered by version
+func All() ([]Migration, error) {
+	entries, err := files.ReadDir("sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		m := fileName.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("migration %s is not named like 0001_name.up.sql", e.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+
+		body, err := files.ReadFile("sql/" + e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has two names, %s and %s", version, migration.Name, m[2])
+		}
+		if m[3] == "up" {
+			migration.Up = string(body)
+		} else {
+			migration.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Statuses returns every migration with when it was applied, migrations the database has but
+// the binary doesn't are left out
+func Statuses(db *sql.DB) ([]Status, error) {
+	migrations, err := All()
+	if err != nil {
+		return nil, err
+	}
+
+	done, err := appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(migrations))
+	for _, m := range migrations {
+		s := Status{Migration: m}
+		if a, ok := done[m.Version]; ok {
+			at := a.at
+			s.AppliedAt = &at
+			s.Dirty = a.dirty
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+// Check returns ErrPending when a migration has not been applied yet and ErrDirty when one failed,
+// the applications call it at start so they don't run against an older schema. A database
+// without a schema_migrations table returns ErrUntracked, Check does not create the table.
+func Check(db *sql.DB) error {
+	ok, err := tracked(db)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrUntracked
+	}
+
+	statuses, err := Statuses(db)
+	if err != nil {
+		return err
+	}
+
+	var pending []string
+	for _, s := range statuses {
+		if s.Dirty {
+			return fmt.Errorf("%w: %04d_%s", ErrDirty, s.Version, s.Name)
+		}
+		if s.AppliedAt == nil {
+			pending = append(pending, fmt.Sprintf("%04d_%s", s.Version, s.Name))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: %s", ErrPending, strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// Up applies the pending migrations in order, at most steps of them when steps is more than 0,
+// and returns the ones applied
+func Up(db *sql.DB, steps int) ([]Migration, error) {
+	statuses, err := Statuses(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, s := range statuses {
+		if s.Dirty {
+			return done, fmt.Errorf("%w: %04d_%s", ErrDirty, s.Version, s.Name)
+		}
+		if s.AppliedAt != nil {
+			continue
+		}
+		if steps > 0 && len(done) == steps {
+			break
+		}
+		if err := run(db, s.Migration, true); err != nil {
+			return done, err
+		}
+		done = append(done, s.Migration)
+	}
+	return done, nil
+}
+
+// Down reverts the last steps applied migrations, newest first, and returns the ones reverted
+func Down(db *sql.DB, steps int) ([]Migration, error) {
+	statuses, err := Statuses(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(statuses) - 1; i >= 0 && len(done) < steps; i-- {
+		s := statuses[i]
+		if s.Dirty {
+			return done, fmt.Errorf("%w: %04d_%s", ErrDirty, s.Version, s.Name)
+		}
+		if s.AppliedAt == nil {
+			continue
+		}
+		if err := run(db, s.Migration, false); err != nil {
+			return done, err
+		}
+		done = append(done, s.Migration)
+	}
+	return done, nil
+}
+
+// Baseline marks every migration up to version as applied without running it and returns the
+// ones marked. It is for a schema that already has these changes, made before the migrations
+// or put right by hand after a dirty one, so pending and dirty migrations are both marked.
+func Baseline(db *sql.DB, version int) ([]Migration, error) {
+	statuses, err := Statuses(db)
+	if err != nil {
+		return nil, err
+	}
+
+	known := false
+	for _, s := range statuses {
+		if s.Version == version {
+			known = true
+		}
+	}
+	if !known {
+		return nil, fmt.Errorf("there is no migration %d", version)
+	}
+
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	var done []Migration
+	for _, s := range statuses {
+		if s.Version > version {
+			break
+		}
+		if s.AppliedAt != nil && !s.Dirty {
+			continue
+		}
+		_, err := db.ExecContext(ctx, `
+			INSERT INTO schema_migrations (version, name, dirty, applied_at) VALUES(?, ?, 0, ?)
+			ON DUPLICATE KEY UPDATE dirty = 0, applied_at = VALUES(applied_at)
+		`, s.Version, s.Name, time.Now())
+		if err != nil {
+			return done, err
+		}
+		done = append(done, s.Migration)
+	}
+	return done, nil
+}
+
+// Create writes empty up and down files for a new migration in dir, numbered after the last one
+func Create(dir, name string) ([]string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	name = strings.Join(strings.FieldsFunc(name, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	}), "_")
+	if name == "" {
+		return nil, errors.New("a migration needs a name")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	last := 0
+	for _, e := range entries {
+		if m := fileName.FindStringSubmatch(e.Name()); m != nil {
+			if v, _ := strconv.Atoi(m[1]); v > last {
+				last = v
+			}
+		}
+	}
+
+	var created []string
+	for _, direction := range []string{"up", "down"} {
+		path := filepath.Join(dir, fmt.Sprintf("%04d_%s.%s.sql", last+1, name, direction))
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return created, err
+		}
+		f.Close()
+		created = append(created, path)
+	}
+	return created, nil
+}
+
+// run applies or reverts one migration. The version is marked dirty while its statements run,
+// so a migration that stops half way is not taken for applied or pending.
+func run(db *sql.DB, m Migration, up bool) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancle()
+
+	body := m.Down
+	if up {
+		body = m.Up
+		_, err := db.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, dirty, applied_at) VALUES(?, ?, 1, ?)`,
+			m.Version, m.Name, time.Now())
+		if err != nil {
+			return err
+		}
+	} else {
+		_, err := db.ExecContext(ctx, `UPDATE schema_migrations SET dirty = 1 WHERE version = ?`, m.Version)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, stmt := range statements(body) {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("migration %04d_%s, statement %d: %w", m.Version, m.Name, i+1, err)
+		}
+	}
+
+	var err error
+	if up {
+		_, err = db.ExecContext(ctx, `UPDATE schema_migrations SET dirty = 0, applied_at = ? WHERE version = ?`, time.Now(), m.Version)
+	} else {
+		_, err = db.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = ?`, m.Version)
+	}
+	return err
+}
+
+// statements splits a migration on the semicolons that end a line, comment lines are dropped
+func statements(body string) []string {
+	var stmts []string
+	var current []string
+	for _, line := range strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current = append(current, line)
+		if strings.HasSuffix(trimmed, ";") {
+			stmts = append(stmts, strings.TrimSuffix(strings.TrimSpace(strings.Join(current, "\n")), ";"))
+			current = nil
+		}
+	}
+	if len(current) > 0 {
+		stmts = append(stmts, strings.TrimSpace(strings.Join(current, "\n")))
+	}
+	return stmts
+}
+
+// tracked reports whether the database has the schema_migrations table
+func tracked(db *sql.DB) (bool, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	var count int
+	row := db.QueryRowContext(ctx, `
+		SELECT count(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = 'schema_migrations'
+	`)
+	if err := row.Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// appliedVersions reads the schema_migrations table, it is created when missing
+func appliedVersions(db *sql.DB) (map[int]applied, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	_, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version int NOT NULL,
+			name varchar(255) NOT NULL,
+			dirty tinyint(1) NOT NULL DEFAULT 0,
+			applied_at datetime NOT NULL,
+			PRIMARY KEY (version)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT version, dirty, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done := make(map[int]applied)
+	for rows.Next() {
+		var version int
+		var a applied
+		if err := rows.Scan(&version, &a.dirty, &a.at); err != nil {
+			return nil, err
+		}
+		done[version] = a
+	}
+	return done, rows.Err()
+}
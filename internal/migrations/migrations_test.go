@@ -0,0 +1,59 @@
+package migrations
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStatements(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"empty", "", nil},
+		{"only comments", "-- nothing to do\n\n", nil},
+		{"one statement", "DROP TABLE coupons;\n", []string{"DROP TABLE coupons"}},
+		{
+			"several lines",
+			"-- coupons\nCREATE TABLE coupons (\n  id int\n);\n",
+			[]string{"CREATE TABLE coupons (\n  id int\n)"},
+		},
+		{
+			"several statements",
+			"ALTER TABLE orders ADD COLUMN a int;\r\n\r\nALTER TABLE orders ADD COLUMN b int;\r\n",
+			[]string{"ALTER TABLE orders ADD COLUMN a int", "ALTER TABLE orders ADD COLUMN b int"},
+		},
+		{"no final semicolon", "DROP TABLE a;\nDROP TABLE b", []string{"DROP TABLE a", "DROP TABLE b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := statements(tt.body); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAll(t *testing.T) {
+	all, err := All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) == 0 {
+		t.Fatal("no migrations")
+	}
+
+	for i, m := range all {
+		if m.Version != i+1 {
+			t.Fatalf("migration %d_%s is at position %d, versions must follow each other from 1", m.Version, m.Name, i+1)
+		}
+		if len(statements(m.Up)) == 0 {
+			t.Errorf("migration %04d_%s has no up statements", m.Version, m.Name)
+		}
+		if len(statements(m.Down)) == 0 {
+			t.Errorf("migration %04d_%s has no down statements", m.Version, m.Name)
+		}
+	}
+}
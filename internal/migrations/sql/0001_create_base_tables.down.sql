@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS tokens;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS orders;
+DROP TABLE IF EXISTS customers;
+DROP TABLE IF EXISTS transactions;
+DROP TABLE IF EXISTS transaction_statuses;
+DROP TABLE IF EXISTS statuses;
+DROP TABLE IF EXISTS widgets;
@@ -0,0 +1,123 @@
+-- the tables of the first version of the shop, IF NOT EXISTS lets a database made before
+-- the migrations take this version as applied
+CREATE TABLE IF NOT EXISTS widgets (
+    id int NOT NULL AUTO_INCREMENT,
+    name varchar(255) NOT NULL DEFAULT '',
+    description text,
+    inventory_level int NOT NULL DEFAULT 0,
+    price int NOT NULL DEFAULT 0,
+    image varchar(255) DEFAULT NULL,
+    is_recurring tinyint(1) NOT NULL DEFAULT 0,
+    plan_id varchar(255) NOT NULL DEFAULT '',
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS statuses (
+    id int NOT NULL AUTO_INCREMENT,
+    name varchar(255) NOT NULL,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS transaction_statuses (
+    id int NOT NULL AUTO_INCREMENT,
+    name varchar(255) NOT NULL,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS transactions (
+    id int NOT NULL AUTO_INCREMENT,
+    amount int NOT NULL,
+    currency varchar(10) NOT NULL DEFAULT '',
+    last_four varchar(4) NOT NULL DEFAULT '',
+    bank_return_code varchar(255) NOT NULL DEFAULT '',
+    transaction_status_id int NOT NULL,
+    expiry_month int NOT NULL DEFAULT 0,
+    expiry_year int NOT NULL DEFAULT 0,
+    payment_intent varchar(255) NOT NULL DEFAULT '',
+    payment_method varchar(255) NOT NULL DEFAULT '',
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY transactions_payment_intent_idx (payment_intent),
+    CONSTRAINT transactions_transaction_status_id_fk FOREIGN KEY (transaction_status_id) REFERENCES transaction_statuses (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS customers (
+    id int NOT NULL AUTO_INCREMENT,
+    first_name varchar(255) NOT NULL DEFAULT '',
+    last_name varchar(255) NOT NULL DEFAULT '',
+    email varchar(255) NOT NULL DEFAULT '',
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS orders (
+    id int NOT NULL AUTO_INCREMENT,
+    widget_id int NOT NULL,
+    transaction_id int NOT NULL,
+    customer_id int NOT NULL,
+    status_id int NOT NULL,
+    quantity int NOT NULL DEFAULT 1,
+    amount int NOT NULL,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    CONSTRAINT orders_widget_id_fk FOREIGN KEY (widget_id) REFERENCES widgets (id),
+    CONSTRAINT orders_transaction_id_fk FOREIGN KEY (transaction_id) REFERENCES transactions (id),
+    CONSTRAINT orders_customer_id_fk FOREIGN KEY (customer_id) REFERENCES customers (id),
+    CONSTRAINT orders_status_id_fk FOREIGN KEY (status_id) REFERENCES statuses (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS users (
+    id int NOT NULL AUTO_INCREMENT,
+    first_name varchar(255) NOT NULL DEFAULT '',
+    last_name varchar(255) NOT NULL DEFAULT '',
+    email varchar(255) NOT NULL,
+    password varchar(60) NOT NULL,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY users_email_idx (email)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS tokens (
+    id int NOT NULL AUTO_INCREMENT,
+    user_id int NOT NULL,
+    name varchar(255) NOT NULL DEFAULT '',
+    email varchar(255) NOT NULL DEFAULT '',
+    token_hash varbinary(255) NOT NULL,
+    expiry datetime NOT NULL,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY tokens_token_hash_idx (token_hash),
+    CONSTRAINT tokens_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- the session store of the web application
+CREATE TABLE IF NOT EXISTS sessions (
+    token char(43) NOT NULL,
+    data blob NOT NULL,
+    expiry timestamp(6) NOT NULL,
+    PRIMARY KEY (token),
+    KEY sessions_expiry_idx (expiry)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+INSERT IGNORE INTO statuses (id, name) VALUES
+    (1, 'Charged'),
+    (2, 'Refunded'),
+    (3, 'Cancelled');
+
+INSERT IGNORE INTO transaction_statuses (id, name) VALUES
+    (1, 'Pending'),
+    (2, 'Cleared'),
+    (3, 'Declined'),
+    (4, 'Refunded'),
+    (5, 'Partially refunded');
@@ -0,0 +1,9 @@
+CREATE TABLE webhook_events (
+    id int NOT NULL AUTO_INCREMENT,
+    event_id varchar(255) NOT NULL,
+    type varchar(255) NOT NULL DEFAULT '',
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY webhook_events_event_id_idx (event_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,13 @@
+CREATE TABLE idempotency_keys (
+    id int NOT NULL AUTO_INCREMENT,
+    idem_key varchar(255) NOT NULL,
+    user_id int NOT NULL DEFAULT 0,
+    request_hash varchar(64) NOT NULL,
+    status_code int NOT NULL DEFAULT 0,
+    response_body mediumtext,
+    expires_at datetime NOT NULL,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY idempotency_keys_idem_key_user_id_idx (idem_key, user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,3 @@
+DELETE FROM statuses WHERE id = 4;
+
+DROP TABLE refunds;
@@ -0,0 +1,21 @@
+-- user id is 0 for refunds made on the gateway dashboard
+CREATE TABLE refunds (
+    id int NOT NULL AUTO_INCREMENT,
+    order_id int NOT NULL,
+    transaction_id int NOT NULL,
+    user_id int NOT NULL DEFAULT 0,
+    amount int NOT NULL,
+    currency varchar(10) NOT NULL DEFAULT '',
+    reason varchar(255) NOT NULL DEFAULT '',
+    gateway_refund_id varchar(255) NOT NULL,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY refunds_gateway_refund_id_idx (gateway_refund_id),
+    KEY refunds_order_id_idx (order_id),
+    KEY refunds_created_at_idx (created_at),
+    CONSTRAINT refunds_order_id_fk FOREIGN KEY (order_id) REFERENCES orders (id),
+    CONSTRAINT refunds_transaction_id_fk FOREIGN KEY (transaction_id) REFERENCES transactions (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+INSERT IGNORE INTO statuses (id, name) VALUES (4, 'Partially refunded');
@@ -0,0 +1 @@
+DROP TABLE widget_prices;
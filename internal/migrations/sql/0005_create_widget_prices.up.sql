@@ -0,0 +1,12 @@
+CREATE TABLE widget_prices (
+    id int NOT NULL AUTO_INCREMENT,
+    widget_id int NOT NULL,
+    currency varchar(10) NOT NULL,
+    price int NOT NULL,
+    plan_id varchar(255) DEFAULT NULL,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY widget_prices_widget_id_currency_idx (widget_id, currency),
+    CONSTRAINT widget_prices_widget_id_fk FOREIGN KEY (widget_id) REFERENCES widgets (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
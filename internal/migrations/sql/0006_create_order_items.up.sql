@@ -0,0 +1,14 @@
+CREATE TABLE order_items (
+    id int NOT NULL AUTO_INCREMENT,
+    order_id int NOT NULL,
+    widget_id int NOT NULL,
+    quantity int NOT NULL,
+    unit_price int NOT NULL,
+    amount int NOT NULL,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY order_items_order_id_idx (order_id),
+    CONSTRAINT order_items_order_id_fk FOREIGN KEY (order_id) REFERENCES orders (id) ON DELETE CASCADE,
+    CONSTRAINT order_items_widget_id_fk FOREIGN KEY (widget_id) REFERENCES widgets (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
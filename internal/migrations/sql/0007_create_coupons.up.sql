@@ -0,0 +1,37 @@
+-- widget id is 0 for coupons that apply to every widget
+CREATE TABLE coupons (
+    id int NOT NULL AUTO_INCREMENT,
+    code varchar(255) NOT NULL,
+    description varchar(255) NOT NULL DEFAULT '',
+    percent_off int NOT NULL DEFAULT 0,
+    amount_off int NOT NULL DEFAULT 0,
+    currency varchar(10) NOT NULL DEFAULT '',
+    expires_at datetime DEFAULT NULL,
+    max_redemptions int NOT NULL DEFAULT 0,
+    per_customer_limit int NOT NULL DEFAULT 0,
+    widget_id int NOT NULL DEFAULT 0,
+    applies_to varchar(20) NOT NULL DEFAULT 'all',
+    gateway_coupon_id varchar(255) NOT NULL DEFAULT '',
+    active tinyint(1) NOT NULL DEFAULT 1,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY coupons_code_idx (code)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE coupon_redemptions (
+    id int NOT NULL AUTO_INCREMENT,
+    coupon_id int NOT NULL,
+    order_id int NOT NULL,
+    code varchar(255) NOT NULL,
+    customer_email varchar(255) NOT NULL DEFAULT '',
+    discount_amount int NOT NULL DEFAULT 0,
+    currency varchar(10) NOT NULL DEFAULT '',
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY coupon_redemptions_coupon_id_customer_email_idx (coupon_id, customer_email),
+    KEY coupon_redemptions_order_id_idx (order_id),
+    CONSTRAINT coupon_redemptions_coupon_id_fk FOREIGN KEY (coupon_id) REFERENCES coupons (id),
+    CONSTRAINT coupon_redemptions_order_id_fk FOREIGN KEY (order_id) REFERENCES orders (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,13 @@
+CREATE TABLE inventory_reservations (
+    id int NOT NULL AUTO_INCREMENT,
+    reference varchar(255) NOT NULL,
+    widget_id int NOT NULL,
+    quantity int NOT NULL,
+    expires_at datetime NOT NULL,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY inventory_reservations_reference_idx (reference),
+    KEY inventory_reservations_widget_id_expires_at_idx (widget_id, expires_at),
+    CONSTRAINT inventory_reservations_widget_id_fk FOREIGN KEY (widget_id) REFERENCES widgets (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,14 @@
+-- payments the gateway took that could not be saved as an order
+CREATE TABLE payment_reconciliations (
+    id int NOT NULL AUTO_INCREMENT,
+    payment_intent varchar(255) NOT NULL DEFAULT '',
+    amount int NOT NULL DEFAULT 0,
+    currency varchar(10) NOT NULL DEFAULT '',
+    email varchar(255) NOT NULL DEFAULT '',
+    reason text,
+    payload text,
+    resolved_at datetime DEFAULT NULL,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,13 @@
+-- user id is 0 for changes made by the gateway
+CREATE TABLE order_history (
+    id int NOT NULL AUTO_INCREMENT,
+    order_id int NOT NULL,
+    user_id int NOT NULL DEFAULT 0,
+    action varchar(50) NOT NULL,
+    description varchar(255) NOT NULL DEFAULT '',
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY order_history_order_id_idx (order_id),
+    CONSTRAINT order_history_order_id_fk FOREIGN KEY (order_id) REFERENCES orders (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
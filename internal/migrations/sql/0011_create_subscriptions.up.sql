@@ -0,0 +1,23 @@
+CREATE TABLE subscriptions (
+    id int NOT NULL AUTO_INCREMENT,
+    order_id int NOT NULL,
+    customer_id int NOT NULL,
+    widget_id int NOT NULL,
+    gateway_subscription_id varchar(255) NOT NULL,
+    gateway_plan_id varchar(255) NOT NULL DEFAULT '',
+    status varchar(50) NOT NULL,
+    current_period_start datetime NOT NULL,
+    current_period_end datetime NOT NULL,
+    cancel_at_period_end tinyint(1) NOT NULL DEFAULT 0,
+    paused tinyint(1) NOT NULL DEFAULT 0,
+    trial_end datetime DEFAULT NULL,
+    canceled_at datetime DEFAULT NULL,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY subscriptions_gateway_subscription_id_idx (gateway_subscription_id),
+    KEY subscriptions_order_id_idx (order_id),
+    CONSTRAINT subscriptions_order_id_fk FOREIGN KEY (order_id) REFERENCES orders (id),
+    CONSTRAINT subscriptions_customer_id_fk FOREIGN KEY (customer_id) REFERENCES customers (id),
+    CONSTRAINT subscriptions_widget_id_fk FOREIGN KEY (widget_id) REFERENCES widgets (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,3 @@
+ALTER TABLE customers
+    DROP KEY customers_email_idx,
+    DROP COLUMN gateway_customer_id;
@@ -0,0 +1,3 @@
+ALTER TABLE customers
+    ADD COLUMN gateway_customer_id varchar(255) DEFAULT NULL AFTER email,
+    ADD KEY customers_email_idx (email);
@@ -0,0 +1,17 @@
+CREATE TABLE subscription_dunning (
+    id int NOT NULL AUTO_INCREMENT,
+    order_id int NOT NULL,
+    gateway_subscription_id varchar(255) NOT NULL,
+    invoice_id varchar(255) NOT NULL DEFAULT '',
+    state varchar(50) NOT NULL,
+    attempts int NOT NULL DEFAULT 0,
+    next_attempt_at datetime DEFAULT NULL,
+    last_error varchar(255) NOT NULL DEFAULT '',
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY subscription_dunning_gateway_subscription_id_idx (gateway_subscription_id),
+    KEY subscription_dunning_order_id_idx (order_id),
+    KEY subscription_dunning_next_attempt_at_idx (next_attempt_at),
+    CONSTRAINT subscription_dunning_order_id_fk FOREIGN KEY (order_id) REFERENCES orders (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,15 @@
+CREATE TABLE reconciliation_reports (
+    id int NOT NULL AUTO_INCREMENT,
+    date_from datetime NOT NULL,
+    date_to datetime NOT NULL,
+    status varchar(50) NOT NULL,
+    matched int NOT NULL DEFAULT 0,
+    missing int NOT NULL DEFAULT 0,
+    extra int NOT NULL DEFAULT 0,
+    mismatched int NOT NULL DEFAULT 0,
+    issues longtext,
+    error text,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
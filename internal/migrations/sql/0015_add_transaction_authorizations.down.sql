@@ -0,0 +1,5 @@
+DELETE FROM transaction_statuses WHERE id IN (6, 7);
+
+ALTER TABLE transactions
+    DROP COLUMN authorization_expires_at,
+    DROP COLUMN authorized_amount;
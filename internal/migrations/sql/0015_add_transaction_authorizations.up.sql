@@ -0,0 +1,7 @@
+ALTER TABLE transactions
+    ADD COLUMN authorized_amount int NOT NULL DEFAULT 0 AFTER payment_method,
+    ADD COLUMN authorization_expires_at datetime DEFAULT NULL AFTER authorized_amount;
+
+INSERT IGNORE INTO transaction_statuses (id, name) VALUES
+    (6, 'Authorized'),
+    (7, 'Voided');
@@ -0,0 +1 @@
+DROP TABLE orders_status_history;
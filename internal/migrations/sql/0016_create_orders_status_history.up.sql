@@ -0,0 +1,16 @@
+-- user id is 0 for changes made by the gateway or the app itself
+CREATE TABLE orders_status_history (
+    id int NOT NULL AUTO_INCREMENT,
+    order_id int NOT NULL,
+    from_status_id int NOT NULL,
+    to_status_id int NOT NULL,
+    user_id int NOT NULL DEFAULT 0,
+    reason varchar(255) NOT NULL DEFAULT '',
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY orders_status_history_order_id_idx (order_id),
+    CONSTRAINT orders_status_history_order_id_fk FOREIGN KEY (order_id) REFERENCES orders (id) ON DELETE CASCADE,
+    CONSTRAINT orders_status_history_from_status_id_fk FOREIGN KEY (from_status_id) REFERENCES statuses (id),
+    CONSTRAINT orders_status_history_to_status_id_fk FOREIGN KEY (to_status_id) REFERENCES statuses (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,8 @@
+ALTER TABLE transactions
+    DROP KEY transactions_balance_transaction_created_at_idx,
+    DROP COLUMN available_on,
+    DROP COLUMN exchange_rate,
+    DROP COLUMN settlement_currency,
+    DROP COLUMN net_amount,
+    DROP COLUMN gateway_fee,
+    DROP COLUMN balance_transaction;
@@ -0,0 +1,9 @@
+-- the balance transaction is empty until the gateway settles the charge
+ALTER TABLE transactions
+    ADD COLUMN balance_transaction varchar(255) NOT NULL DEFAULT '' AFTER authorization_expires_at,
+    ADD COLUMN gateway_fee int NOT NULL DEFAULT 0 AFTER balance_transaction,
+    ADD COLUMN net_amount int NOT NULL DEFAULT 0 AFTER gateway_fee,
+    ADD COLUMN settlement_currency varchar(10) NOT NULL DEFAULT '' AFTER net_amount,
+    ADD COLUMN exchange_rate decimal(18,9) NOT NULL DEFAULT 0 AFTER settlement_currency,
+    ADD COLUMN available_on datetime DEFAULT NULL AFTER exchange_rate,
+    ADD KEY transactions_balance_transaction_created_at_idx (balance_transaction, created_at);
@@ -0,0 +1,3 @@
+DROP TABLE dispute_files;
+
+DROP TABLE disputes;
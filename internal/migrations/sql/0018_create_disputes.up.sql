@@ -0,0 +1,33 @@
+-- transaction and order id are 0 for disputes of charges that are not ours
+CREATE TABLE disputes (
+    id int NOT NULL AUTO_INCREMENT,
+    gateway_dispute_id varchar(255) NOT NULL,
+    transaction_id int NOT NULL DEFAULT 0,
+    order_id int NOT NULL DEFAULT 0,
+    amount int NOT NULL DEFAULT 0,
+    currency varchar(10) NOT NULL DEFAULT '',
+    status varchar(50) NOT NULL,
+    reason varchar(50) NOT NULL DEFAULT '',
+    evidence_due_by datetime DEFAULT NULL,
+    outcome varchar(50) NOT NULL DEFAULT '',
+    evidence_text text NOT NULL,
+    evidence_submitted_at datetime DEFAULT NULL,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY disputes_gateway_dispute_id_idx (gateway_dispute_id),
+    KEY disputes_order_id_idx (order_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE dispute_files (
+    id int NOT NULL AUTO_INCREMENT,
+    dispute_id int NOT NULL,
+    kind varchar(50) NOT NULL,
+    gateway_file_id varchar(255) NOT NULL,
+    filename varchar(255) NOT NULL DEFAULT '',
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY dispute_files_dispute_id_idx (dispute_id),
+    CONSTRAINT dispute_files_dispute_id_fk FOREIGN KEY (dispute_id) REFERENCES disputes (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
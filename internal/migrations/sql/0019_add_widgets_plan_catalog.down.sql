@@ -0,0 +1,6 @@
+ALTER TABLE widgets
+    DROP KEY widgets_slug_idx,
+    DROP COLUMN features,
+    DROP COLUMN plan_interval_count,
+    DROP COLUMN plan_interval,
+    DROP COLUMN slug;
@@ -0,0 +1,10 @@
+-- features are kept one per line
+ALTER TABLE widgets
+    ADD COLUMN slug varchar(255) DEFAULT NULL AFTER plan_id,
+    ADD COLUMN plan_interval varchar(20) NOT NULL DEFAULT 'month' AFTER slug,
+    ADD COLUMN plan_interval_count int NOT NULL DEFAULT 1 AFTER plan_interval,
+    ADD COLUMN features text AFTER plan_interval_count,
+    ADD UNIQUE KEY widgets_slug_idx (slug);
+
+-- the bronze plan used to be found by its id
+UPDATE widgets SET slug = 'bronze' WHERE id = 3 AND is_recurring = 1;
@@ -0,0 +1,6 @@
+ALTER TABLE subscriptions
+    DROP KEY subscriptions_status_trial_end_idx,
+    DROP COLUMN trial_reminder_sent_at;
+
+ALTER TABLE widgets
+    DROP COLUMN trial_period_days;
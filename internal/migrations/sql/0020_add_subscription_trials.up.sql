@@ -0,0 +1,6 @@
+ALTER TABLE widgets
+    ADD COLUMN trial_period_days int NOT NULL DEFAULT 0 AFTER features;
+
+ALTER TABLE subscriptions
+    ADD COLUMN trial_reminder_sent_at datetime DEFAULT NULL AFTER trial_end,
+    ADD KEY subscriptions_status_trial_end_idx (status, trial_end);
@@ -0,0 +1,4 @@
+DROP TABLE usage_records;
+
+ALTER TABLE widgets
+    DROP COLUMN usage_unit;
@@ -0,0 +1,22 @@
+-- a plan is metered when it has a usage unit, e.g. API call
+ALTER TABLE widgets
+    ADD COLUMN usage_unit varchar(50) NOT NULL DEFAULT '' AFTER trial_period_days;
+
+CREATE TABLE usage_records (
+    id int NOT NULL AUTO_INCREMENT,
+    order_id int NOT NULL,
+    event_id varchar(255) NOT NULL,
+    quantity int NOT NULL,
+    occurred_at datetime NOT NULL,
+    period_start datetime NOT NULL,
+    period_end datetime NOT NULL,
+    reported_at datetime DEFAULT NULL,
+    gateway_usage_record_id varchar(255) NOT NULL DEFAULT '',
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY usage_records_order_id_event_id_idx (order_id, event_id),
+    KEY usage_records_order_id_period_start_idx (order_id, period_start),
+    KEY usage_records_reported_at_period_end_idx (reported_at, period_end),
+    CONSTRAINT usage_records_order_id_fk FOREIGN KEY (order_id) REFERENCES orders (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,7 @@
+-- the amount of a transaction stays what was authorized, as the gateway reports it on the charge
+ALTER TABLE transactions
+    ADD COLUMN captured_amount int NOT NULL DEFAULT 0 AFTER authorization_expires_at;
+
+-- captures made before kept what was taken in amount, authorized (6) and voided (7) took nothing
+UPDATE transactions SET captured_amount = amount
+WHERE authorized_amount > 0 AND transaction_status_id NOT IN (6, 7);
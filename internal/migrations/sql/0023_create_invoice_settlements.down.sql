@@ -0,0 +1,4 @@
+DROP TABLE invoice_settlements;
+
+ALTER TABLE transactions
+    DROP COLUMN settlement_attempts;
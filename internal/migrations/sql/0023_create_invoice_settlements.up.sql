@@ -0,0 +1,26 @@
+-- charges the gateway never settles are given up on after a number of attempts
+ALTER TABLE transactions
+    ADD COLUMN settlement_attempts int NOT NULL DEFAULT 0 AFTER available_on;
+
+-- a subscription has one transaction, each of its invoices is charged and settled on its own
+CREATE TABLE invoice_settlements (
+    id int NOT NULL AUTO_INCREMENT,
+    transaction_id int NOT NULL,
+    invoice_id varchar(255) NOT NULL,
+    amount int NOT NULL,
+    currency varchar(10) NOT NULL DEFAULT '',
+    paid_at datetime NOT NULL,
+    balance_transaction varchar(255) NOT NULL DEFAULT '',
+    gateway_fee int NOT NULL DEFAULT 0,
+    net_amount int NOT NULL DEFAULT 0,
+    settlement_currency varchar(10) NOT NULL DEFAULT '',
+    exchange_rate decimal(18,9) NOT NULL DEFAULT 0,
+    available_on datetime DEFAULT NULL,
+    settlement_attempts int NOT NULL DEFAULT 0,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY invoice_settlements_invoice_id_idx (invoice_id),
+    KEY invoice_settlements_balance_transaction_paid_at_idx (balance_transaction, paid_at),
+    CONSTRAINT invoice_settlements_transaction_id_fk FOREIGN KEY (transaction_id) REFERENCES transactions (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,2 @@
+ALTER TABLE transactions
+    DROP COLUMN payment_intent_key;
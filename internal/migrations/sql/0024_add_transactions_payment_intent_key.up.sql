@@ -0,0 +1,5 @@
+-- a payment intent is turned into one transaction, transactions without one keep NULL in the
+-- key so they don't collide. Duplicates already in the table have to be removed first.
+ALTER TABLE transactions
+    ADD COLUMN payment_intent_key varchar(255) GENERATED ALWAYS AS (NULLIF(payment_intent, '')) VIRTUAL AFTER payment_intent,
+    ADD UNIQUE KEY transactions_payment_intent_key_uniq (payment_intent_key);
@@ -0,0 +1,2 @@
+ALTER TABLE usage_records
+    DROP COLUMN batch_key;
@@ -0,0 +1,3 @@
+-- the idempotency key a batch of usage is reported with, set before it is sent so a retry uses the same one
+ALTER TABLE usage_records
+    ADD COLUMN batch_key varchar(64) NOT NULL DEFAULT '' AFTER reported_at;
@@ -0,0 +1,132 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrNotAuthorized is returned when a transaction is no longer waiting to be captured
+var ErrNotAuthorized = errors.New("the transaction is not an open authorization")
+
+const authorizationColumns = `
+	id, amount, currency, last_four, expiry_month, expiry_year, bank_return_code, transaction_status_id,
+	payment_intent, payment_method, authorized_amount, authorization_expires_at, captured_amount,
+	created_at, updated_at
+`
+
+func scanAuthorization(row rowScanner) (Transaction, error) {
+	var t Transaction
+	var expiresAt sql.NullTime
+	err := row.Scan(
+		&t.ID,
+		&t.Amount,
+		&t.Currency,
+		&t.LastFour,
+		&t.ExpiryMonth,
+		&t.ExpiryYear,
+		&t.BankReturnCode,
+		&t.TransactionStatusID,
+		&t.PaymentIntent,
+		&t.PaymentMethod,
+		&t.AuthorizedAmount,
+		&expiresAt,
+		&t.CapturedAmount,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+	)
+	if expiresAt.Valid {
+		t.AuthorizationExpiresAt = &expiresAt.Time
+	}
+	return t, err
+}
+
+// GetTransaction returns a transaction by id
+func (m *DBModel) GetTransaction(id int) (Transaction, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	row := m.DB.QueryRowContext(ctx, `SELECT `+authorizationColumns+` FROM transactions WHERE id = ?`, id)
+	return scanAuthorization(row)
+}
+
+// GetPendingAuthorizations returns the authorizations waiting to be captured, the ones expiring first come first
+func (m *DBModel) GetPendingAuthorizations() ([]*Transaction, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	query := `
+		SELECT ` + authorizationColumns + `
+		FROM transactions
+		WHERE transaction_status_id = ?
+		ORDER BY authorization_expires_at, id
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, TransactionAuthorized)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	txns := []*Transaction{}
+	for rows.Next() {
+		t, err := scanAuthorization(rows)
+		if err != nil {
+			return nil, err
+		}
+		txns = append(txns, &t)
+	}
+
+	return txns, rows.Err()
+}
+
+// CaptureAuthorization clears an authorization with the amount that was captured, the amount of the
+// transaction is left as authorized since the gateway keeps it on the charge after a partial capture
+func (m *DBModel) CaptureAuthorization(id, amount int) error {
+	return m.closeAuthorization(`
+		UPDATE transactions SET captured_amount = ?, transaction_status_id = ?, updated_at = ?
+		WHERE id = ? AND transaction_status_id = ?`,
+		amount, TransactionCleared, time.Now(), id, TransactionAuthorized)
+}
+
+// VoidAuthorization marks an authorization as released without capture
+func (m *DBModel) VoidAuthorization(id int) error {
+	return m.closeAuthorization(`
+		UPDATE transactions SET transaction_status_id = ?, updated_at = ?
+		WHERE id = ? AND transaction_status_id = ?`,
+		TransactionVoided, time.Now(), id, TransactionAuthorized)
+}
+
+// VoidAuthorizationByPaymentIntent marks the authorization of a payment intent the gateway released,
+// transactions that are not authorizations are left alone
+func (m *DBModel) VoidAuthorizationByPaymentIntent(pi string) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	stmt := `
+		UPDATE transactions SET transaction_status_id = ?, updated_at = ?
+		WHERE payment_intent = ? AND transaction_status_id = ?
+	`
+	_, err := m.DB.ExecContext(ctx, stmt, TransactionVoided, time.Now(), pi, TransactionAuthorized)
+	return err
+}
+
+func (m *DBModel) closeAuthorization(stmt string, args ...interface{}) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	result, err := m.DB.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotAuthorized
+	}
+	return nil
+}
@@ -0,0 +1,382 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// what a coupon may be used for
+const (
+	CouponAppliesToAll       = "all"
+	CouponAppliesToOneTime   = "one_time"
+	CouponAppliesToRecurring = "recurring"
+)
+
+var (
+	ErrCouponNotFound      = errors.New("coupon code is not valid")
+	ErrCouponExpired       = errors.New("coupon code has expired")
+	ErrCouponExhausted     = errors.New("coupon code has been fully redeemed")
+	ErrCouponLimitReached  = errors.New("coupon code was already used by this customer")
+	ErrCouponNotApplicable = errors.New("coupon code does not apply to this order")
+	ErrCouponFreeOrder     = errors.New("coupon code cannot take the whole amount off the order")
+)
+
+// Coupon is the type for a promotion code, it takes either a percentage or a fixed amount off
+type Coupon struct {
+	ID               int        `json:"id"`
+	Code             string     `json:"code"`
+	Description      string     `json:"description"`
+	PercentOff       int        `json:"percent_off"`
+	AmountOff        int        `json:"amount_off"`
+	Currency         string     `json:"currency"`
+	ExpiresAt        *time.Time `json:"expires_at"`
+	MaxRedemptions   int        `json:"max_redemptions"`
+	PerCustomerLimit int        `json:"per_customer_limit"`
+	WidgetID         int        `json:"widget_id"`
+	AppliesTo        string     `json:"applies_to"`
+	GatewayCouponID  string     `json:"gateway_coupon_id"`
+	Active           bool       `json:"active"`
+	TimesRedeemed    int        `json:"times_redeemed"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// CouponRedemption is the type for a coupon applied to an order
+type CouponRedemption struct {
+	ID             int       `json:"id"`
+	CouponID       int       `json:"coupon_id"`
+	OrderID        int       `json:"order_id"`
+	Code           string    `json:"code"`
+	CustomerEmail  string    `json:"customer_email"`
+	DiscountAmount int       `json:"discount_amount"`
+	Currency       string    `json:"currency"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// NormalizeCouponCode returns the code as it is stored
+func NormalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// applies reports whether the coupon may be used on a widget or plan
+func (c Coupon) applies(widget Widget) bool {
+	if c.WidgetID != 0 && c.WidgetID != widget.ID {
+		return false
+	}
+	switch c.AppliesTo {
+	case CouponAppliesToOneTime:
+		return !widget.IsRecurring
+	case CouponAppliesToRecurring:
+		return widget.IsRecurring
+	}
+	return true
+}
+
+// Discount returns the amount taken off the order lines, the lines need their widget set.
+// A discount that leaves nothing to pay is refused, the gateway can't charge 0.
+func (c Coupon) Discount(items []*OrderItem, currency string) (int, error) {
+	total, eligible := 0, 0
+	for _, i := range items {
+		total += i.Amount
+		if c.applies(i.Widget) {
+			eligible += i.Amount
+		}
+	}
+	if eligible == 0 {
+		return 0, ErrCouponNotApplicable
+	}
+
+	discount := eligible * c.PercentOff / 100
+	if c.PercentOff == 0 {
+		if !strings.EqualFold(c.Currency, currency) {
+			return 0, ErrCouponNotApplicable
+		}
+		discount = c.AmountOff
+		if discount > eligible {
+			discount = eligible
+		}
+	}
+
+	if discount >= total {
+		return 0, ErrCouponFreeOrder
+	}
+	return discount, nil
+}
+
+const couponColumns = `
+	c.id, c.code, c.description, c.percent_off, c.amount_off, c.currency, c.expires_at,
+	c.max_redemptions, c.per_customer_limit, c.widget_id, c.applies_to, c.gateway_coupon_id,
+	c.active, (SELECT count(id) FROM coupon_redemptions WHERE coupon_id = c.id), c.created_at, c.updated_at
+`
+
+func scanCoupon(row rowScanner) (Coupon, error) {
+	var c Coupon
+	var expiresAt sql.NullTime
+	err := row.Scan(
+		&c.ID,
+		&c.Code,
+		&c.Description,
+		&c.PercentOff,
+		&c.AmountOff,
+		&c.Currency,
+		&expiresAt,
+		&c.MaxRedemptions,
+		&c.PerCustomerLimit,
+		&c.WidgetID,
+		&c.AppliesTo,
+		&c.GatewayCouponID,
+		&c.Active,
+		&c.TimesRedeemed,
+		&c.CreatedAt,
+		&c.UpdatedAt,
+	)
+	if expiresAt.Valid {
+		c.ExpiresAt = &expiresAt.Time
+	}
+	return c, err
+}
+
+// GetAllCoupons returns all coupons, newest first
+func (m *DBModel) GetAllCoupons() ([]*Coupon, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	coupons := []*Coupon{}
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT `+couponColumns+` FROM coupons c ORDER BY c.created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c, err := scanCoupon(rows)
+		if err != nil {
+			return nil, err
+		}
+		coupons = append(coupons, &c)
+	}
+
+	return coupons, rows.Err()
+}
+
+// GetCoupon returns one coupon by id
+func (m *DBModel) GetCoupon(id int) (Coupon, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	row := m.DB.QueryRowContext(ctx, `SELECT `+couponColumns+` FROM coupons c WHERE c.id = ?`, id)
+	return scanCoupon(row)
+}
+
+// GetCouponByCode returns one coupon by code
+func (m *DBModel) GetCouponByCode(code string) (Coupon, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	row := m.DB.QueryRowContext(ctx, `SELECT `+couponColumns+` FROM coupons c WHERE c.code = ?`, NormalizeCouponCode(code))
+	c, err := scanCoupon(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c, ErrCouponNotFound
+	}
+	return c, err
+}
+
+// InsertCoupon insert a coupon and returns the id
+func (m *DBModel) InsertCoupon(c Coupon) (int, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	stmt := `
+		INSERT INTO coupons
+		(code, description, percent_off, amount_off, currency, expires_at, max_redemptions,
+			per_customer_limit, widget_id, applies_to, gateway_coupon_id, active, created_at, updated_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	result, err := m.DB.ExecContext(ctx, stmt,
+		NormalizeCouponCode(c.Code),
+		c.Description,
+		c.PercentOff,
+		c.AmountOff,
+		strings.ToLower(c.Currency),
+		c.ExpiresAt,
+		c.MaxRedemptions,
+		c.PerCustomerLimit,
+		c.WidgetID,
+		c.AppliesTo,
+		c.GatewayCouponID,
+		c.Active,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// UpdateCoupon updates a coupon
+func (m *DBModel) UpdateCoupon(c Coupon) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	stmt := `
+		UPDATE coupons SET
+			code = ?, description = ?, percent_off = ?, amount_off = ?, currency = ?, expires_at = ?,
+			max_redemptions = ?, per_customer_limit = ?, widget_id = ?, applies_to = ?,
+			gateway_coupon_id = ?, active = ?, updated_at = ?
+		WHERE id = ?
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		NormalizeCouponCode(c.Code),
+		c.Description,
+		c.PercentOff,
+		c.AmountOff,
+		strings.ToLower(c.Currency),
+		c.ExpiresAt,
+		c.MaxRedemptions,
+		c.PerCustomerLimit,
+		c.WidgetID,
+		c.AppliesTo,
+		c.GatewayCouponID,
+		c.Active,
+		time.Now(),
+		c.ID,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteCoupon deactivates a coupon so it can't be redeemed anymore. The row is kept for the
+// redemptions that point at it, including those of checkouts paid for while it was deleted.
+func (m *DBModel) DeleteCoupon(id int) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE coupons SET active = 0, updated_at = ? WHERE id = ?`, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ValidateCoupon returns the coupon for a code if it can still be redeemed by the customer
+func (m *DBModel) ValidateCoupon(code, email string) (Coupon, error) {
+	c, err := m.GetCouponByCode(code)
+	if err != nil {
+		return c, err
+	}
+
+	used := 0
+	if c.PerCustomerLimit > 0 {
+		ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancle()
+
+		row := m.DB.QueryRowContext(ctx, `SELECT count(id) FROM coupon_redemptions WHERE coupon_id = ? AND customer_email = ?`,
+			c.ID, strings.ToLower(email))
+		if err := row.Scan(&used); err != nil {
+			return c, err
+		}
+	}
+
+	return c, c.redeemable(time.Now(), used)
+}
+
+// redeemable checks the coupon can still be used at now by a customer who already used it used times
+func (c Coupon) redeemable(now time.Time, used int) error {
+	if !c.Active {
+		return ErrCouponNotFound
+	}
+	if c.ExpiresAt != nil && !c.ExpiresAt.After(now) {
+		return ErrCouponExpired
+	}
+	if c.MaxRedemptions > 0 && c.TimesRedeemed >= c.MaxRedemptions {
+		return ErrCouponExhausted
+	}
+	if c.PerCustomerLimit > 0 && used >= c.PerCustomerLimit {
+		return ErrCouponLimitReached
+	}
+	return nil
+}
+
+// InsertCouponRedemption records the coupon applied to an order
+func (m *DBModel) InsertCouponRedemption(cr CouponRedemption) (int, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	return insertCouponRedemption(ctx, m.DB, cr)
+}
+
+// redeemCoupon locks the coupon of the redemption by its code and checks again the limits that
+// ValidateCoupon checked before the payment, so two checkouts can't both take the last use.
+// Expiry and active are not checked, the customer already paid the discounted price.
+func redeemCoupon(ctx context.Context, tx *sql.Tx, cr CouponRedemption) (int, error) {
+	var maxRedemptions, perCustomerLimit int
+	row := tx.QueryRowContext(ctx, `SELECT id, max_redemptions, per_customer_limit FROM coupons WHERE code = ? FOR UPDATE`,
+		NormalizeCouponCode(cr.Code))
+	if err := row.Scan(&cr.CouponID, &maxRedemptions, &perCustomerLimit); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrCouponNotFound
+		}
+		return 0, err
+	}
+
+	var redeemed, used int
+	row = tx.QueryRowContext(ctx, `
+		SELECT count(id), coalesce(sum(customer_email = ?), 0) FROM coupon_redemptions WHERE coupon_id = ?
+	`, strings.ToLower(cr.CustomerEmail), cr.CouponID)
+	if err := row.Scan(&redeemed, &used); err != nil {
+		return 0, err
+	}
+	if maxRedemptions > 0 && redeemed >= maxRedemptions {
+		return 0, ErrCouponExhausted
+	}
+	if perCustomerLimit > 0 && used >= perCustomerLimit {
+		return 0, ErrCouponLimitReached
+	}
+
+	return insertCouponRedemption(ctx, tx, cr)
+}
+
+func insertCouponRedemption(ctx context.Context, db execer, cr CouponRedemption) (int, error) {
+	stmt := `
+		INSERT INTO coupon_redemptions
+		(coupon_id, order_id, code, customer_email, discount_amount, currency, created_at, updated_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	result, err := db.ExecContext(ctx, stmt,
+		cr.CouponID,
+		cr.OrderID,
+		NormalizeCouponCode(cr.Code),
+		strings.ToLower(cr.CustomerEmail),
+		cr.DiscountAmount,
+		cr.Currency,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
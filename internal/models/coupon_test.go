@@ -0,0 +1,78 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCouponDiscount(t *testing.T) {
+	oneTime := Widget{ID: 1}
+	plan := Widget{ID: 2, IsRecurring: true}
+	items := []*OrderItem{
+		{Amount: 1000, Widget: oneTime},
+		{Amount: 3000, Widget: plan},
+	}
+
+	tests := []struct {
+		name     string
+		coupon   Coupon
+		items    []*OrderItem
+		currency string
+		want     int
+		err      error
+	}{
+		{"percent of all", Coupon{PercentOff: 10}, items, "usd", 400, nil},
+		{"percent of one time", Coupon{PercentOff: 50, AppliesTo: CouponAppliesToOneTime}, items, "usd", 500, nil},
+		{"percent of recurring", Coupon{PercentOff: 50, AppliesTo: CouponAppliesToRecurring}, items, "usd", 1500, nil},
+		{"percent of widget", Coupon{PercentOff: 20, WidgetID: 2}, items, "usd", 600, nil},
+		{"amount", Coupon{AmountOff: 500, Currency: "USD"}, items, "usd", 500, nil},
+		{"amount capped to eligible lines", Coupon{AmountOff: 5000, Currency: "usd", WidgetID: 1}, items, "usd", 1000, nil},
+		{"amount in other currency", Coupon{AmountOff: 500, Currency: "eur"}, items, "usd", 0, ErrCouponNotApplicable},
+		{"no eligible line", Coupon{PercentOff: 10, WidgetID: 3}, items, "usd", 0, ErrCouponNotApplicable},
+		{"whole order by percent", Coupon{PercentOff: 100}, items, "usd", 0, ErrCouponFreeOrder},
+		{"whole order by amount", Coupon{AmountOff: 5000, Currency: "usd"}, items, "usd", 0, ErrCouponFreeOrder},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.coupon.Discount(tt.items, tt.currency)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCouponRedeemable(t *testing.T) {
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	later := now.Add(time.Hour)
+
+	tests := []struct {
+		name   string
+		coupon Coupon
+		used   int
+		err    error
+	}{
+		{"active", Coupon{Active: true}, 0, nil},
+		{"inactive", Coupon{}, 0, ErrCouponNotFound},
+		{"not expired", Coupon{Active: true, ExpiresAt: &later}, 0, nil},
+		{"expires now", Coupon{Active: true, ExpiresAt: &now}, 0, ErrCouponExpired},
+		{"uses left", Coupon{Active: true, MaxRedemptions: 5, TimesRedeemed: 4}, 0, nil},
+		{"fully redeemed", Coupon{Active: true, MaxRedemptions: 5, TimesRedeemed: 5}, 0, ErrCouponExhausted},
+		{"customer uses left", Coupon{Active: true, PerCustomerLimit: 2}, 1, nil},
+		{"customer limit reached", Coupon{Active: true, PerCustomerLimit: 2}, 2, ErrCouponLimitReached},
+		{"no customer limit", Coupon{Active: true}, 10, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.coupon.redeemable(now, tt.used); !errors.Is(err, tt.err) {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// errDuplicateKey is the MySQL error number of an insert that breaks a unique key
+const errDuplicateKey = 1062
+
+// ErrOrderExists is returned by CreateOrder when the payment intent already paid for an order
+var ErrOrderExists = errors.New("an order was already placed for this payment")
+
+// CreatedOrder holds the ids of the rows written by CreateOrder
+type CreatedOrder struct {
+	CustomerID    int `json:"customer_id"`
+	TransactionID int `json:"transaction_id"`
+	OrderID       int `json:"order_id"`
+}
+
+// CreateOrder writes the customer, the transaction, the order with its lines and the coupon
+// redemption in one database transaction, either all of them are saved or none are.
+// The ids in txn and order are set from the rows written before them, the coupon of the
+// redemption is found by its code. A payment intent is only turned into one order.
+func (m *DBModel) CreateOrder(customer Customer, txn Transaction, order Order, redemption *CouponRedemption) (CreatedOrder, error) {
+	var created CreatedOrder
+
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return created, err
+	}
+	defer tx.Rollback()
+
+	if txn.PaymentIntent != "" {
+		var id int
+		// not locked, a gap lock on the index would make unrelated checkouts deadlock. Two saves
+		// racing past it are stopped by the unique key on the payment intent.
+		row := tx.QueryRowContext(ctx, `SELECT id FROM transactions WHERE payment_intent = ? LIMIT 1`, txn.PaymentIntent)
+		err = row.Scan(&id)
+		if err == nil {
+			return created, ErrOrderExists
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return created, err
+		}
+	}
+
+	created.CustomerID, err = insertCustomer(ctx, tx, customer)
+	if err != nil {
+		return created, err
+	}
+
+	created.TransactionID, err = insertTransaction(ctx, tx, txn)
+	if err != nil {
+		if isDuplicateKey(err) {
+			return created, ErrOrderExists
+		}
+		return created, err
+	}
+
+	order.CustomerID = created.CustomerID
+	order.TransactionID = created.TransactionID
+	created.OrderID, err = insertOrder(ctx, tx, order)
+	if err != nil {
+		return created, err
+	}
+
+	if redemption != nil {
+		redemption.OrderID = created.OrderID
+		if _, err = redeemCoupon(ctx, tx, *redemption); err != nil {
+			return created, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return CreatedOrder{}, err
+	}
+
+	return created, nil
+}
+
+// isDuplicateKey reports whether err is MySQL refusing a row that breaks a unique key
+func isDuplicateKey(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == errDuplicateKey
+}
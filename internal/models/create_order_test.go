@@ -0,0 +1,31 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestIsDuplicateKey(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"duplicate key", &mysql.MySQLError{Number: 1062}, true},
+		{"wrapped duplicate key", fmt.Errorf("insert: %w", &mysql.MySQLError{Number: 1062}), true},
+		{"other mysql error", &mysql.MySQLError{Number: 1213}, false},
+		{"other error", errors.New("boom"), false},
+		{"no error", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isDuplicateKey(tt.err); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
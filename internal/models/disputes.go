@@ -0,0 +1,314 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Dispute is the type for a chargeback opened by the bank of a customer, transaction and order id
+// are 0 when the charge is not one of ours. Outcome is set once the dispute is closed.
+type Dispute struct {
+	ID                  int            `json:"id"`
+	GatewayDisputeID    string         `json:"gateway_dispute_id"`
+	TransactionID       int            `json:"transaction_id"`
+	OrderID             int            `json:"order_id"`
+	PaymentIntent       string         `json:"payment_intent"`
+	Amount              int            `json:"amount"`
+	Currency            string         `json:"currency"`
+	Status              string         `json:"status"`
+	Reason              string         `json:"reason"`
+	EvidenceDueBy       *time.Time     `json:"evidence_due_by"`
+	Outcome             string         `json:"outcome"`
+	EvidenceText        string         `json:"evidence_text"`
+	EvidenceSubmittedAt *time.Time     `json:"evidence_submitted_at"`
+	Files               []*DisputeFile `json:"files"`
+	CreatedAt           time.Time      `json:"created_at"`
+	UpdatedAt           time.Time      `json:"updated_at"`
+}
+
+// DisputeFile is a file uploaded to the gateway as evidence of a dispute
+type DisputeFile struct {
+	ID            int       `json:"id"`
+	DisputeID     int       `json:"dispute_id"`
+	Kind          string    `json:"kind"`
+	GatewayFileID string    `json:"gateway_file_id"`
+	Filename      string    `json:"filename"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+const disputeColumns = `
+	d.id, d.gateway_dispute_id, d.transaction_id, d.order_id, coalesce(t.payment_intent, ''), d.amount, d.currency,
+	d.status, d.reason, d.evidence_due_by, d.outcome, d.evidence_text, d.evidence_submitted_at, d.created_at, d.updated_at
+`
+
+func scanDispute(row rowScanner) (*Dispute, error) {
+	var d Dispute
+	var dueBy, submittedAt sql.NullTime
+	err := row.Scan(
+		&d.ID,
+		&d.GatewayDisputeID,
+		&d.TransactionID,
+		&d.OrderID,
+		&d.PaymentIntent,
+		&d.Amount,
+		&d.Currency,
+		&d.Status,
+		&d.Reason,
+		&dueBy,
+		&d.Outcome,
+		&d.EvidenceText,
+		&submittedAt,
+		&d.CreatedAt,
+		&d.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if dueBy.Valid {
+		d.EvidenceDueBy = &dueBy.Time
+	}
+	if submittedAt.Valid {
+		d.EvidenceSubmittedAt = &submittedAt.Time
+	}
+	d.Files = []*DisputeFile{}
+	return &d, nil
+}
+
+// SaveDispute records a dispute from the gateway or updates the one already recorded, the transaction
+// and order are looked up by payment intent
+func (m *DBModel) SaveDispute(d Dispute) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	if d.PaymentIntent != "" {
+		query := `
+			SELECT t.id, coalesce(o.id, 0)
+			FROM transactions t
+				LEFT JOIN orders o ON (o.transaction_id = t.id)
+			WHERE t.payment_intent = ?
+			ORDER BY t.id
+			LIMIT 1
+		`
+		err := m.DB.QueryRowContext(ctx, query, d.PaymentIntent).Scan(&d.TransactionID, &d.OrderID)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+	}
+
+	stmt := `
+		INSERT INTO disputes
+		(gateway_dispute_id, transaction_id, order_id, amount, currency, status, reason, evidence_due_by, outcome,
+			evidence_text, created_at, updated_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, '', ?, ?)
+		ON DUPLICATE KEY UPDATE
+			amount = VALUES(amount), status = VALUES(status), reason = VALUES(reason),
+			evidence_due_by = VALUES(evidence_due_by), outcome = VALUES(outcome), updated_at = VALUES(updated_at)
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		d.GatewayDisputeID,
+		d.TransactionID,
+		d.OrderID,
+		d.Amount,
+		d.Currency,
+		d.Status,
+		d.Reason,
+		d.EvidenceDueBy,
+		d.Outcome,
+		time.Now(),
+		time.Now(),
+	)
+	return err
+}
+
+// GetDisputes returns all disputes, the ones waiting for evidence first and then the newest
+func (m *DBModel) GetDisputes() ([]*Dispute, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	query := `
+		SELECT ` + disputeColumns + `
+		FROM disputes d
+			LEFT JOIN transactions t ON (d.transaction_id = t.id)
+		ORDER BY d.outcome <> '', d.evidence_due_by IS NULL, d.evidence_due_by, d.id DESC
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	disputes := []*Dispute{}
+	for rows.Next() {
+		d, err := scanDispute(rows)
+		if err != nil {
+			return nil, err
+		}
+		disputes = append(disputes, d)
+	}
+
+	return disputes, rows.Err()
+}
+
+// GetDispute returns a dispute with its evidence files
+func (m *DBModel) GetDispute(id int) (*Dispute, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	query := `
+		SELECT ` + disputeColumns + `
+		FROM disputes d
+			LEFT JOIN transactions t ON (d.transaction_id = t.id)
+		WHERE d.id = ?
+	`
+
+	d, err := scanDispute(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `
+		SELECT id, dispute_id, kind, gateway_file_id, filename, created_at, updated_at
+		FROM dispute_files
+		WHERE dispute_id = ?
+		ORDER BY id
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var f DisputeFile
+		err := rows.Scan(&f.ID, &f.DisputeID, &f.Kind, &f.GatewayFileID, &f.Filename, &f.CreatedAt, &f.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		d.Files = append(d.Files, &f)
+	}
+
+	return d, rows.Err()
+}
+
+// GetDisputesForOrder returns the disputes of an order, oldest first
+func (m *DBModel) GetDisputesForOrder(orderID int) ([]*Dispute, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	query := `
+		SELECT ` + disputeColumns + `
+		FROM disputes d
+			LEFT JOIN transactions t ON (d.transaction_id = t.id)
+		WHERE d.order_id = ?
+		ORDER BY d.id
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	disputes := []*Dispute{}
+	for rows.Next() {
+		d, err := scanDispute(rows)
+		if err != nil {
+			return nil, err
+		}
+		disputes = append(disputes, d)
+	}
+
+	return disputes, rows.Err()
+}
+
+// SaveDisputeEvidence stores the evidence sent to the gateway and its files in one database
+// transaction, submitted is set when the evidence was submitted to the bank
+func (m *DBModel) SaveDisputeEvidence(id int, text, status string, files []DisputeFile, submitted bool) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var submittedAt *time.Time
+	if submitted {
+		now := time.Now()
+		submittedAt = &now
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE disputes SET
+			evidence_text = ?, status = ?, evidence_submitted_at = coalesce(?, evidence_submitted_at), updated_at = ?
+		WHERE id = ?
+	`, text, status, submittedAt, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO dispute_files
+			(dispute_id, kind, gateway_file_id, filename, created_at, updated_at)
+			VALUES(?, ?, ?, ?, ?, ?)
+		`, id, f.Kind, f.GatewayFileID, f.Filename, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// attachDisputeStatus sets the status of the last dispute of each order with one query
+func (m *DBModel) attachDisputeStatus(orders []*Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	byID := make(map[int]*Order, len(orders))
+	placeholders := make([]string, 0, len(orders))
+	args := make([]interface{}, 0, len(orders))
+	for _, o := range orders {
+		byID[o.ID] = o
+		placeholders = append(placeholders, "?")
+		args = append(args, o.ID)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT order_id, status
+		FROM disputes
+		WHERE order_id IN (%s)
+		ORDER BY order_id, id
+	`, strings.Join(placeholders, ","))
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var orderID int
+		var status string
+		if err = rows.Scan(&orderID, &status); err != nil {
+			return err
+		}
+		// the last dispute wins
+		if o, ok := byID[orderID]; ok {
+			o.DisputeStatus = status
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,205 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// dunning states of a subscription whose renewal failed
+const (
+	DunningPastDue   = "past_due"
+	DunningRetrying  = "retrying"
+	DunningUnpaid    = "unpaid"
+	DunningCancelled = "cancelled"
+	DunningRecovered = "recovered"
+)
+
+// Dunning is the type for the collection of a failed renewal invoice, attempts counts the
+// retries made by us, not the ones made by the gateway
+type Dunning struct {
+	ID                    int        `json:"id"`
+	OrderID               int        `json:"order_id"`
+	GatewaySubscriptionID string     `json:"gateway_subscription_id"`
+	InvoiceID             string     `json:"invoice_id"`
+	State                 string     `json:"state"`
+	Attempts              int        `json:"attempts"`
+	NextAttemptAt         *time.Time `json:"next_attempt_at"`
+	LastError             string     `json:"last_error"`
+	CreatedAt             time.Time  `json:"created_at"`
+	UpdatedAt             time.Time  `json:"updated_at"`
+}
+
+const dunningColumns = `
+	id, order_id, gateway_subscription_id, invoice_id, state, attempts, next_attempt_at,
+	last_error, created_at, updated_at
+`
+
+func scanDunning(row rowScanner) (Dunning, error) {
+	var d Dunning
+	var nextAttemptAt sql.NullTime
+	err := row.Scan(
+		&d.ID,
+		&d.OrderID,
+		&d.GatewaySubscriptionID,
+		&d.InvoiceID,
+		&d.State,
+		&d.Attempts,
+		&nextAttemptAt,
+		&d.LastError,
+		&d.CreatedAt,
+		&d.UpdatedAt,
+	)
+	if nextAttemptAt.Valid {
+		d.NextAttemptAt = &nextAttemptAt.Time
+	}
+	return d, err
+}
+
+// StartDunning opens the dunning of a subscription, it returns false when the subscription
+// is already in dunning so the customer is not told twice
+func (m *DBModel) StartDunning(d Dunning) (bool, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	row := tx.QueryRowContext(ctx, `
+		SELECT id FROM subscription_dunning
+		WHERE gateway_subscription_id = ? AND state IN (?, ?, ?)
+		FOR UPDATE`,
+		d.GatewaySubscriptionID, DunningPastDue, DunningRetrying, DunningUnpaid)
+	err = row.Scan(&id)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+
+	stmt := `
+		INSERT INTO subscription_dunning
+		(order_id, gateway_subscription_id, invoice_id, state, attempts, next_attempt_at,
+			last_error, created_at, updated_at)
+		VALUES(?, ?, ?, ?, 0, ?, ?, ?, ?)
+	`
+	_, err = tx.ExecContext(ctx, stmt,
+		d.OrderID,
+		d.GatewaySubscriptionID,
+		d.InvoiceID,
+		DunningPastDue,
+		d.NextAttemptAt,
+		d.LastError,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// UpdateDunning saves the state of a dunning after an attempt
+func (m *DBModel) UpdateDunning(d Dunning) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	stmt := `
+		UPDATE subscription_dunning SET
+			state = ?, attempts = ?, next_attempt_at = ?, last_error = ?, updated_at = ?
+		WHERE id = ?
+	`
+	_, err := m.DB.ExecContext(ctx, stmt,
+		d.State,
+		d.Attempts,
+		d.NextAttemptAt,
+		d.LastError,
+		time.Now(),
+		d.ID,
+	)
+	return err
+}
+
+// CloseDunning ends the open dunning of a subscription with the given state
+func (m *DBModel) CloseDunning(subID, state string) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	stmt := `
+		UPDATE subscription_dunning SET
+			state = ?, next_attempt_at = null, updated_at = ?
+		WHERE gateway_subscription_id = ? AND state IN (?, ?, ?)
+	`
+	_, err := m.DB.ExecContext(ctx, stmt, state, time.Now(), subID, DunningPastDue, DunningRetrying, DunningUnpaid)
+	return err
+}
+
+// RetryDunningNow makes the open dunning of a subscription due, used after the customer
+// changed the card
+func (m *DBModel) RetryDunningNow(subID string) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	stmt := `
+		UPDATE subscription_dunning SET
+			next_attempt_at = ?, updated_at = ?
+		WHERE gateway_subscription_id = ? AND state IN (?, ?)
+	`
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now(), time.Now(), subID, DunningPastDue, DunningRetrying)
+	return err
+}
+
+// GetDueDunning returns the dunning waiting for an attempt and the unpaid ones still
+// to be cancelled
+func (m *DBModel) GetDueDunning(now time.Time) ([]*Dunning, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	query := `
+		SELECT ` + dunningColumns + `
+		FROM subscription_dunning
+		WHERE (state IN (?, ?) AND next_attempt_at <= ?) OR state = ?
+		ORDER BY next_attempt_at, id
+	`
+	rows, err := m.DB.QueryContext(ctx, query, DunningPastDue, DunningRetrying, now, DunningUnpaid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	due := []*Dunning{}
+	for rows.Next() {
+		d, err := scanDunning(rows)
+		if err != nil {
+			return nil, err
+		}
+		due = append(due, &d)
+	}
+
+	return due, rows.Err()
+}
+
+// GetDunningByOrderID returns the latest dunning of an order, nil when its renewals never failed
+func (m *DBModel) GetDunningByOrderID(orderID int) (*Dunning, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	row := m.DB.QueryRowContext(ctx, `
+		SELECT `+dunningColumns+` FROM subscription_dunning
+		WHERE order_id = ? ORDER BY id DESC LIMIT 1`, orderID)
+	d, err := scanDunning(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// IdempotencyKey is the type for a stored response of an idempotent request
+type IdempotencyKey struct {
+	ID           int       `json:"id"`
+	Key          string    `json:"key"`
+	UserID       int       `json:"user_id"`
+	RequestHash  string    `json:"request_hash"`
+	StatusCode   int       `json:"status_code"`
+	ResponseBody []byte    `json:"response_body"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// GetIdempotencyKey returns an unexpired idempotency key for a user
+func (m *DBModel) GetIdempotencyKey(key string, userID int) (IdempotencyKey, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	var k IdempotencyKey
+
+	query := `
+		SELECT id, idem_key, user_id, request_hash, status_code, coalesce(response_body, ''),
+			expires_at, created_at, updated_at
+		FROM idempotency_keys
+		WHERE idem_key = ? AND user_id = ? AND expires_at > ?
+	`
+
+	row := m.DB.QueryRowContext(ctx, query, key, userID, time.Now())
+	err := row.Scan(
+		&k.ID,
+		&k.Key,
+		&k.UserID,
+		&k.RequestHash,
+		&k.StatusCode,
+		&k.ResponseBody,
+		&k.ExpiresAt,
+		&k.CreatedAt,
+		&k.UpdatedAt,
+	)
+	if err != nil {
+		return k, err
+	}
+	return k, nil
+}
+
+// InsertIdempotencyKey reserves a key for a user, returns false when the key is already in use
+func (m *DBModel) InsertIdempotencyKey(k IdempotencyKey) (bool, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	// an expired key may be used again
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE idem_key = ? AND user_id = ? AND expires_at <= ?`,
+		k.Key, k.UserID, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	stmt := `
+		INSERT IGNORE INTO idempotency_keys
+		(idem_key, user_id, request_hash, status_code, expires_at, created_at, updated_at)
+		VALUES(?, ?, ?, 0, ?, ?, ?)
+	`
+
+	result, err := m.DB.ExecContext(ctx, stmt, k.Key, k.UserID, k.RequestHash, k.ExpiresAt, time.Now(), time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+// SaveIdempotencyResponse stores the first response sent for a key
+func (m *DBModel) SaveIdempotencyResponse(key string, userID, statusCode int, body []byte) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	stmt := `
+		UPDATE idempotency_keys SET status_code = ?, response_body = ?, updated_at = ?
+		WHERE idem_key = ? AND user_id = ?
+	`
+	_, err := m.DB.ExecContext(ctx, stmt, statusCode, body, time.Now(), key, userID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteIdempotencyKey releases a key so the request can be retried
+func (m *DBModel) DeleteIdempotencyKey(key string, userID int) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE idem_key = ? AND user_id = ?`, key, userID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
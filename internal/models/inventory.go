@@ -0,0 +1,169 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ErrOutOfStock is returned when there are not enough widgets to sell
+var ErrOutOfStock = errors.New("out of stock")
+
+// OutOfStockError tells which widget is short and how many are left
+type OutOfStockError struct {
+	WidgetID  int
+	Name      string
+	Available int
+}
+
+func (e *OutOfStockError) Error() string {
+	if e.Available <= 0 {
+		return fmt.Sprintf("%s is out of stock", e.Name)
+	}
+	return fmt.Sprintf("%s is out of stock, only %d left", e.Name, e.Available)
+}
+
+func (e *OutOfStockError) Is(target error) bool {
+	return target == ErrOutOfStock
+}
+
+// InventoryReservation is the type for stock held for a payment intent that is not paid yet
+type InventoryReservation struct {
+	ID        int       `json:"id"`
+	Reference string    `json:"reference"`
+	WidgetID  int       `json:"widget_id"`
+	Quantity  int       `json:"quantity"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// stockQuantities adds up the quantity of each widget, sorted by widget id so rows are always locked in the same order
+func stockQuantities(items []*OrderItem) ([]int, map[int]int) {
+	quantities := make(map[int]int)
+	for _, i := range items {
+		quantities[i.WidgetID] += i.Quantity
+	}
+
+	ids := make([]int, 0, len(quantities))
+	for id := range quantities {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids, quantities
+}
+
+// lockStock locks the widget row and checks the quantity is available, stock reserved under
+// reference is counted as available. Plans have no stock, ok is false for them.
+func lockStock(ctx context.Context, tx *sql.Tx, widgetID, quantity int, reference string) (bool, error) {
+	var name string
+	var level int
+	var recurring bool
+
+	row := tx.QueryRowContext(ctx, `SELECT name, inventory_level, is_recurring FROM widgets WHERE id = ? FOR UPDATE`, widgetID)
+	if err := row.Scan(&name, &level, &recurring); err != nil {
+		return false, err
+	}
+	if recurring {
+		return false, nil
+	}
+
+	var reserved int
+	row = tx.QueryRowContext(ctx, `
+		SELECT coalesce(sum(quantity), 0) FROM inventory_reservations
+		WHERE widget_id = ? AND expires_at > ? AND reference <> ?
+	`, widgetID, time.Now(), reference)
+	if err := row.Scan(&reserved); err != nil {
+		return false, err
+	}
+
+	available := level - reserved
+	if quantity > available {
+		return false, &OutOfStockError{WidgetID: widgetID, Name: name, Available: available}
+	}
+
+	return true, nil
+}
+
+// ReserveInventory holds stock for the lines of an order until expiresAt
+func (m *DBModel) ReserveInventory(reference string, items []*OrderItem, expiresAt time.Time) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// reservations of payment intents that were never confirmed are released
+	_, err = tx.ExecContext(ctx, `DELETE FROM inventory_reservations WHERE expires_at <= ?`, time.Now())
+	if err != nil {
+		return err
+	}
+
+	ids, quantities := stockQuantities(items)
+	for _, id := range ids {
+		stocked, err := lockStock(ctx, tx, id, quantities[id], reference)
+		if err != nil {
+			return err
+		}
+		if !stocked {
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO inventory_reservations (reference, widget_id, quantity, expires_at, created_at, updated_at)
+			VALUES(?, ?, ?, ?, ?, ?)
+		`, reference, id, quantities[id], expiresAt, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ReleaseInventory gives back the stock held under a reference
+func (m *DBModel) ReleaseInventory(reference string) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM inventory_reservations WHERE reference = ?`, reference)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// takeStock checks and decrements the stock of the lines of an order, and drops the reservation they were sold under
+func takeStock(ctx context.Context, tx *sql.Tx, items []*OrderItem, reference string) error {
+	ids, quantities := stockQuantities(items)
+	for _, id := range ids {
+		stocked, err := lockStock(ctx, tx, id, quantities[id], reference)
+		if err != nil {
+			return err
+		}
+		if !stocked {
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE widgets SET inventory_level = inventory_level - ?, updated_at = ? WHERE id = ?`,
+			quantities[id], time.Now(), id)
+		if err != nil {
+			return err
+		}
+	}
+
+	if reference != "" {
+		_, err := tx.ExecContext(ctx, `DELETE FROM inventory_reservations WHERE reference = ?`, reference)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -18,6 +19,11 @@ type Models struct {
 	DB DBModel
 }
 
+// rowScanner is a *sql.Row or *sql.Rows, so one scan function reads a row of either
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
 // return a model type with database connection pool
 func NewModels(db *sql.DB) Models {
 	return Models{
@@ -37,24 +43,42 @@ type Widget struct {
 	Image          string    `json:"image"`
 	IsRecurring    bool      `json:"is_recurring"`
 	PlanID         string    `json:"plan_id"`
+	Slug           string    `json:"slug"`
+	Interval       string    `json:"interval"`
+	IntervalCount  int       `json:"interval_count"`
+	Features       []string  `json:"features"`
+	TrialDays      int       `json:"trial_period_days"`
+	UsageUnit      string    `json:"usage_unit"`
 	CreatedAt      time.Time `json:"created_at"`
 	UpdatedAt      time.Time `json:"updated_at"`
 }
 
 // type Order is the type for order
 type Order struct {
-	ID            int         `json:"id"`
-	WidgetID      int         `json:"widget_id"`
-	TransactionID int         `json:"transaction_id"`
-	CustomerID    int         `json:"customer_id"`
-	StatusID      int         `json:"status_id"`
-	Quantity      int         `json:"quantity"`
-	Amount        int         `json:"amount"`
-	Widget        Widget      `json:"widget"`
-	Transaction   Transaction `json:"transaction"`
-	Customer      Customer    `json:"customer"`
-	CreatedAt     time.Time   `json:"created_at"`
-	UpdatedAt     time.Time   `json:"updated_at"`
+	ID             int                  `json:"id"`
+	WidgetID       int                  `json:"widget_id"`
+	TransactionID  int                  `json:"transaction_id"`
+	CustomerID     int                  `json:"customer_id"`
+	StatusID       int                  `json:"status_id"`
+	Quantity       int                  `json:"quantity"`
+	Amount         int                  `json:"amount"`
+	Widget         Widget               `json:"widget"`
+	Transaction    Transaction          `json:"transaction"`
+	Customer       Customer             `json:"customer"`
+	Items          []*OrderItem         `json:"items"`
+	CouponCode     string               `json:"coupon_code"`
+	DiscountAmount int                  `json:"discount_amount"`
+	Reservation    string               `json:"-"`
+	Refunds        []*Refund            `json:"refunds"`
+	RefundedAmount int                  `json:"refunded_amount"`
+	History        []*OrderHistory      `json:"history"`
+	StatusHistory  []*OrderStatusChange `json:"status_history"`
+	Subscription   *Subscription        `json:"subscription"`
+	Dunning        *Dunning             `json:"dunning"`
+	Disputes       []*Dispute           `json:"disputes"`
+	DisputeStatus  string               `json:"dispute_status"`
+	CreatedAt      time.Time            `json:"created_at"`
+	UpdatedAt      time.Time            `json:"updated_at"`
 }
 
 // Status for type for all statues
@@ -75,28 +99,36 @@ type TransactionStatus struct {
 
 // customer
 type Customer struct {
-	ID        int       `json:"id"`
-	FirstName string    `json:"first_name"`
-	LastName  string    `json:"last_name"`
-	Email     string    `json:"email"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID                int       `json:"id"`
+	FirstName         string    `json:"first_name"`
+	LastName          string    `json:"last_name"`
+	Email             string    `json:"email"`
+	GatewayCustomerID string    `json:"gateway_customer_id"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
 }
 
 // Transaction for type for all transaction
 type Transaction struct {
-	ID                  int       `json:"id"`
-	Amount              int       `json:"amount"`
-	Currency            string    `json:"currency"`
-	LastFour            string    `json:"last_four"`
-	ExpiryMonth         int       `json:"expiry_month"`
-	ExpiryYear          int       `json:"expiry_year"`
-	BankReturnCode      string    `json:"bank_return_code"`
-	TransactionStatusID int       `json:"transaction_status_id"`
-	PaymentIntent       string    `json:"payment_intent"`
-	PaymentMethod       string    `json:"payment_method"`
-	CreatedAt           time.Time `json:"created_at"`
-	UpdatedAt           time.Time `json:"updated_at"`
+	ID                  int    `json:"id"`
+	Amount              int    `json:"amount"`
+	Currency            string `json:"currency"`
+	LastFour            string `json:"last_four"`
+	ExpiryMonth         int    `json:"expiry_month"`
+	ExpiryYear          int    `json:"expiry_year"`
+	BankReturnCode      string `json:"bank_return_code"`
+	TransactionStatusID int    `json:"transaction_status_id"`
+	PaymentIntent       string `json:"payment_intent"`
+	PaymentMethod       string `json:"payment_method"`
+	// AuthorizedAmount and AuthorizationExpiresAt are set for amounts held on the card to capture later,
+	// CapturedAmount is what was taken of it. Amount stays the amount of the charge as the gateway has it.
+	AuthorizedAmount       int        `json:"authorized_amount"`
+	AuthorizationExpiresAt *time.Time `json:"authorization_expires_at"`
+	CapturedAmount         int        `json:"captured_amount"`
+	// Settlement is set once the gateway reported the fee and net amount
+	Settlement *Settlement `json:"settlement"`
+	CreatedAt  time.Time   `json:"created_at"`
+	UpdatedAt  time.Time   `json:"updated_at"`
 }
 
 // User for type for all transaction user
@@ -114,30 +146,37 @@ func (m *DBModel) GetWidget(id int) (Widget, error) {
 	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancle()
 
-	var widget Widget
+	row := m.DB.QueryRowContext(ctx, `SELECT `+widgetColumns+` FROM widgets WHERE id=?`, id)
+	return scanWidget(row)
+}
 
-	row := m.DB.QueryRowContext(ctx, `
-	SELECT 
-		id, name, description, inventory_level, price, coalesce(image, ''), is_recurring, plan_id, created_at, updated_at 
-	FROM 
-		widgets 
-	WHERE id=?`, id)
-	err := row.Scan(
-		&widget.ID,
-		&widget.Name,
-		&widget.Description,
-		&widget.InventoryLevel,
-		&widget.Price,
-		&widget.Image,
-		&widget.IsRecurring,
-		&widget.PlanID,
-		&widget.CreatedAt,
-		&widget.UpdatedAt,
-	)
+// GetPlans returns the widgets sold as subscriptions
+func (m *DBModel) GetPlans() ([]*Widget, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	plans := []*Widget{}
+
+	rows, err := m.DB.QueryContext(ctx, `
+	SELECT `+widgetColumns+`
+	FROM
+		widgets
+	WHERE is_recurring = 1
+	ORDER BY price, id`)
 	if err != nil {
-		return widget, err
+		return nil, err
 	}
-	return widget, nil
+	defer rows.Close()
+
+	for rows.Next() {
+		widget, err := scanWidget(rows)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, &widget)
+	}
+
+	return plans, rows.Err()
 }
 
 // insertTransaction insert new transaction and return transaction id
@@ -145,13 +184,18 @@ func (m *DBModel) InsertTransaction(txn Transaction) (int, error) {
 	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancle()
 
+	return insertTransaction(ctx, m.DB, txn)
+}
+
+func insertTransaction(ctx context.Context, db execer, txn Transaction) (int, error) {
 	stmt := `
 		INSERT INTO transactions 
-		(amount, currency, last_four, bank_return_code, expiry_month, expiry_year, transaction_status_id, payment_intent, payment_method, created_at, updated_at)
-		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		(amount, currency, last_four, bank_return_code, expiry_month, expiry_year, transaction_status_id, payment_intent, payment_method,
+			authorized_amount, authorization_expires_at, created_at, updated_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	result, err := m.DB.ExecContext(ctx, stmt, txn.Amount,
+	result, err := db.ExecContext(ctx, stmt, txn.Amount,
 		txn.Currency,
 		txn.LastFour,
 		txn.BankReturnCode,
@@ -160,6 +204,8 @@ func (m *DBModel) InsertTransaction(txn Transaction) (int, error) {
 		txn.TransactionStatusID,
 		txn.PaymentIntent,
 		txn.PaymentMethod,
+		txn.AuthorizedAmount,
+		txn.AuthorizationExpiresAt,
 		time.Now(),
 		time.Now(),
 	)
@@ -175,20 +221,66 @@ func (m *DBModel) InsertTransaction(txn Transaction) (int, error) {
 	return int(id), nil
 }
 
-// insert customer
+// NormalizeEmail returns the email as customers are looked up by
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// insert customer, a customer with the same email is reused as it is, the buyer is not signed
+// in so the name they typed must not replace the one stored
 func (m *DBModel) InsertCustomer(txn Customer) (int, error) {
 	ctx, cancle := context.WithTimeout(context.Background(), 20*time.Second)
 	defer cancle()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	id, err := insertCustomer(ctx, tx, txn)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func insertCustomer(ctx context.Context, tx *sql.Tx, txn Customer) (int, error) {
+	email := NormalizeEmail(txn.Email)
+
+	var id int
+	row := tx.QueryRowContext(ctx, `SELECT id FROM customers WHERE email = ? ORDER BY id LIMIT 1 FOR UPDATE`, email)
+	err := row.Scan(&id)
+	switch {
+	case err == nil:
+		// a gateway customer is only stored when the customer has none yet
+		_, err = tx.ExecContext(ctx, `
+			UPDATE customers SET gateway_customer_id = ?, updated_at = ?
+			WHERE id = ? AND coalesce(gateway_customer_id, '') = ''
+		`, txn.GatewayCustomerID, time.Now(), id)
+		if err != nil {
+			return 0, err
+		}
+		return id, nil
+	case !errors.Is(err, sql.ErrNoRows):
+		return 0, err
+	}
+
 	stmt := `
 		INSERT INTO customers 
-		(first_name, last_name, email, created_at, updated_at)
-		VALUES(?, ?, ?, ?, ?)
+		(first_name, last_name, email, gateway_customer_id, created_at, updated_at)
+		VALUES(?, ?, ?, ?, ?, ?)
 	`
 
-	result, err := m.DB.ExecContext(ctx, stmt, txn.FirstName,
+	result, err := tx.ExecContext(ctx, stmt, txn.FirstName,
 		txn.LastName,
-		txn.Email,
+		email,
+		txn.GatewayCustomerID,
 		time.Now(),
 		time.Now(),
 	)
@@ -196,26 +288,53 @@ func (m *DBModel) InsertCustomer(txn Customer) (int, error) {
 		return 0, err
 	}
 
-	id, err := result.LastInsertId()
+	newID, err := result.LastInsertId()
 	if err != nil {
 		return 0, err
 	}
 
-	return int(id), nil
+	return int(newID), nil
 }
 
-// insert order
+// insert order with its lines, the stock of the widgets is taken in the same database transaction
 func (m *DBModel) InsertOrder(txn Order) (int, error) {
 	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancle()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	id, err := insertOrder(ctx, tx, txn)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func insertOrder(ctx context.Context, tx *sql.Tx, txn Order) (int, error) {
+	items := txn.Items
+	if len(items) == 0 {
+		items = []*OrderItem{{WidgetID: txn.WidgetID, Quantity: txn.Quantity}}
+	}
+	if err := takeStock(ctx, tx, items, txn.Reservation); err != nil {
+		return 0, err
+	}
+
 	stmt := `
 		INSERT INTO orders 
 		(widget_id, status_id, transaction_id, customer_id, quantity, amount, created_at, updated_at)
 		VALUES(?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	result, err := m.DB.ExecContext(ctx, stmt, txn.WidgetID,
+	result, err := tx.ExecContext(ctx, stmt, txn.WidgetID,
 		txn.StatusID,
 		txn.TransactionID,
 		txn.CustomerID,
@@ -233,6 +352,22 @@ func (m *DBModel) InsertOrder(txn Order) (int, error) {
 		return 0, err
 	}
 
+	// save the lines of the order
+	for _, item := range txn.Items {
+		item.OrderID = int(id)
+		if _, err := insertOrderItem(ctx, tx, *item); err != nil {
+			return 0, err
+		}
+	}
+
+	if txn.Subscription != nil {
+		txn.Subscription.OrderID = int(id)
+		txn.Subscription.CustomerID = txn.CustomerID
+		if err := insertSubscription(ctx, tx, *txn.Subscription); err != nil {
+			return 0, err
+		}
+	}
+
 	return int(id), nil
 }
 
@@ -308,8 +443,8 @@ func (m *DBModel) GetAllOrders() ([]*Order, error) {
 			&o.Transaction.LastFour,
 			&o.Transaction.ExpiryMonth,
 			&o.Transaction.ExpiryYear,
-			&o.Transaction.BankReturnCode,
 			&o.Transaction.PaymentIntent,
+			&o.Transaction.BankReturnCode,
 
 			&o.Customer.ID,
 			&o.Customer.FirstName,
@@ -324,6 +459,13 @@ func (m *DBModel) GetAllOrders() ([]*Order, error) {
 	}
 
 	defer rows.Close()
+
+	if err = m.attachOrderItems(orders); err != nil {
+		return nil, err
+	}
+	if err = m.attachDisputeStatus(orders); err != nil {
+		return nil, err
+	}
 	return orders, nil
 }
 func (m *DBModel) GetAllOrdersPagination(pageSize, page int) ([]*Order, int, int, error) {
@@ -375,8 +517,8 @@ func (m *DBModel) GetAllOrdersPagination(pageSize, page int) ([]*Order, int, int
 			&o.Transaction.LastFour,
 			&o.Transaction.ExpiryMonth,
 			&o.Transaction.ExpiryYear,
-			&o.Transaction.BankReturnCode,
 			&o.Transaction.PaymentIntent,
+			&o.Transaction.BankReturnCode,
 
 			&o.Customer.ID,
 			&o.Customer.FirstName,
@@ -391,6 +533,13 @@ func (m *DBModel) GetAllOrdersPagination(pageSize, page int) ([]*Order, int, int
 	}
 	defer rows.Close()
 
+	if err = m.attachOrderItems(orders); err != nil {
+		return nil, 0, 0, err
+	}
+	if err = m.attachDisputeStatus(orders); err != nil {
+		return nil, 0, 0, err
+	}
+
 	queryCount := `
 		SELECT COUNT(o.id) FROM orders o 
 		LEFT JOIN widgets w ON (o.widget_id=w.id)
@@ -454,8 +603,8 @@ func (m *DBModel) GetAllSubscription() ([]*Order, error) {
 			&o.Transaction.LastFour,
 			&o.Transaction.ExpiryMonth,
 			&o.Transaction.ExpiryYear,
-			&o.Transaction.BankReturnCode,
 			&o.Transaction.PaymentIntent,
+			&o.Transaction.BankReturnCode,
 
 			&o.Customer.ID,
 			&o.Customer.FirstName,
@@ -522,8 +671,8 @@ func (m *DBModel) GetAllSubscriptionPagination(pageSize, page int) ([]*Order, in
 			&o.Transaction.LastFour,
 			&o.Transaction.ExpiryMonth,
 			&o.Transaction.ExpiryYear,
-			&o.Transaction.BankReturnCode,
 			&o.Transaction.PaymentIntent,
+			&o.Transaction.BankReturnCode,
 
 			&o.Customer.ID,
 			&o.Customer.FirstName,
@@ -540,6 +689,14 @@ func (m *DBModel) GetAllSubscriptionPagination(pageSize, page int) ([]*Order, in
 
 	defer rows.Close()
 
+	if err = m.attachSubscriptions(orders); err != nil {
+		return nil, 0, 0, err
+	}
+
+	if err = m.attachDisputeStatus(orders); err != nil {
+		return nil, 0, 0, err
+	}
+
 	queryCount := `
 		SELECT COUNT(o.id) FROM orders o 
 		LEFT JOIN widgets w ON (o.widget_id=w.id)
@@ -563,19 +720,24 @@ func (m *DBModel) GetOrderByID(id int) (Order, error) {
 	defer cancel()
 
 	var o Order
+	var settlement settlementRow
 
 	query := `
 		select
 			o.id, o.widget_id, o.transaction_id, o.customer_id,
 			o.status_id, o.quantity, o.amount, o.created_at,
-			o.updated_at, w.id, w.name, t.id, t.amount, t.currency,
+			o.updated_at, w.id, w.name, w.usage_unit, t.id, t.amount, t.currency,
 			t.last_four, t.expiry_month, t.expiry_year, t.payment_intent,
-			t.bank_return_code, c.id, c.first_name, c.last_name, c.email
+			t.bank_return_code, t.transaction_status_id, t.balance_transaction, t.gateway_fee,
+			t.net_amount, t.settlement_currency, t.exchange_rate, t.available_on,
+			c.id, c.first_name, c.last_name, c.email,
+			coalesce(cr.code, ''), coalesce(cr.discount_amount, 0)
 		from
 			orders o
 			left join widgets w on (o.widget_id = w.id)
 			left join transactions t on (o.transaction_id = t.id)
 			left join customers c on (o.customer_id = c.id)
+			left join coupon_redemptions cr on (cr.order_id = o.id)
 		where
 			o.id = ?
 	`
@@ -594,6 +756,7 @@ func (m *DBModel) GetOrderByID(id int) (Order, error) {
 		&o.UpdatedAt,
 		&o.Widget.ID,
 		&o.Widget.Name,
+		&o.Widget.UsageUnit,
 		&o.Transaction.ID,
 		&o.Transaction.Amount,
 		&o.Transaction.Currency,
@@ -602,29 +765,70 @@ func (m *DBModel) GetOrderByID(id int) (Order, error) {
 		&o.Transaction.ExpiryYear,
 		&o.Transaction.PaymentIntent,
 		&o.Transaction.BankReturnCode,
+		&o.Transaction.TransactionStatusID,
+		&settlement.balanceTransaction,
+		&settlement.fee,
+		&settlement.net,
+		&settlement.currency,
+		&settlement.exchangeRate,
+		&settlement.availableOn,
 		&o.Customer.ID,
 		&o.Customer.FirstName,
 		&o.Customer.LastName,
 		&o.Customer.Email,
+		&o.CouponCode,
+		&o.DiscountAmount,
 	)
 	if err != nil {
 		return o, err
 	}
 
-	return o, nil
-}
+	o.Transaction.Settlement = settlement.settlement()
 
-func (m *DBModel) UpdateOrderStatus(id, statusID int) error {
-	ctx, cancle := context.WithTimeout(context.Background(), time.Second*3)
-	defer cancle()
+	if err = m.attachOrderItems([]*Order{&o}); err != nil {
+		return o, err
+	}
 
-	stmt := `UPDATE orders SET status_id = ? WHERE id = ?`
-	_, err := m.DB.ExecContext(ctx, stmt, statusID, id)
+	o.Refunds, err = m.GetRefundsForOrder(o.ID)
 	if err != nil {
-		return err
+		return o, err
 	}
-	return nil
+
+	for _, r := range o.Refunds {
+		o.RefundedAmount += r.Amount
+	}
+
+	o.History, err = m.GetOrderHistory(o.ID)
+	if err != nil {
+		return o, err
+	}
+
+	o.StatusHistory, err = m.GetOrderStatusHistory(o.ID)
+	if err != nil {
+		return o, err
+	}
+
+	o.Subscription, err = m.GetSubscriptionByOrderID(o.ID)
+	if err != nil {
+		return o, err
+	}
+
+	o.Dunning, err = m.GetDunningByOrderID(o.ID)
+	if err != nil {
+		return o, err
+	}
+
+	o.Disputes, err = m.GetDisputesForOrder(o.ID)
+	if err != nil {
+		return o, err
+	}
+	if len(o.Disputes) > 0 {
+		o.DisputeStatus = o.Disputes[len(o.Disputes)-1].Status
+	}
+
+	return o, nil
 }
+
 func (m *DBModel) GetAllUsers() ([]*User, error) {
 	ctx, cancle := context.WithTimeout(context.Background(), time.Second*3)
 	defer cancle()
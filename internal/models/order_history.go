@@ -0,0 +1,143 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// actions recorded in the order history
+const (
+	OrderHistoryPlanChanged   = "plan_changed"
+	OrderHistoryPaused        = "paused"
+	OrderHistoryResumed       = "resumed"
+	OrderHistoryCancelled     = "cancelled"
+	OrderHistoryTrialExtended = "trial_extended"
+	OrderHistoryTrialEnded    = "trial_ended"
+)
+
+// OrderHistory is the type for one change made to an order, user id is 0 for changes made by the gateway
+type OrderHistory struct {
+	ID          int       `json:"id"`
+	OrderID     int       `json:"order_id"`
+	UserID      int       `json:"user_id"`
+	Action      string    `json:"action"`
+	Description string    `json:"description"`
+	User        User      `json:"user"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// InsertOrderHistory records a change made to an order and returns the id
+func (m *DBModel) InsertOrderHistory(h OrderHistory) (int, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	return insertOrderHistory(ctx, m.DB, h)
+}
+
+func insertOrderHistory(ctx context.Context, db execer, h OrderHistory) (int, error) {
+	stmt := `
+		INSERT INTO order_history
+		(order_id, user_id, action, description, created_at, updated_at)
+		VALUES(?, ?, ?, ?, ?, ?)
+	`
+
+	result, err := db.ExecContext(ctx, stmt,
+		h.OrderID,
+		h.UserID,
+		h.Action,
+		h.Description,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// GetOrderHistory returns the changes made to an order, oldest first
+func (m *DBModel) GetOrderHistory(orderID int) ([]*OrderHistory, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	history := []*OrderHistory{}
+
+	query := `
+		SELECT h.id, h.order_id, h.user_id, h.action, h.description, h.created_at, h.updated_at,
+			coalesce(u.first_name, ''), coalesce(u.last_name, ''), coalesce(u.email, '')
+		FROM order_history h
+			LEFT JOIN users u ON (h.user_id = u.id)
+		WHERE h.order_id = ?
+		ORDER BY h.created_at, h.id
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var h OrderHistory
+		err = rows.Scan(
+			&h.ID,
+			&h.OrderID,
+			&h.UserID,
+			&h.Action,
+			&h.Description,
+			&h.CreatedAt,
+			&h.UpdatedAt,
+			&h.User.FirstName,
+			&h.User.LastName,
+			&h.User.Email,
+		)
+		if err != nil {
+			return nil, err
+		}
+		h.User.ID = h.UserID
+		history = append(history, &h)
+	}
+
+	return history, rows.Err()
+}
+
+// ChangeOrderPlan moves a subscription order and its line to another plan at a new amount,
+// and records the change in the order history
+func (m *DBModel) ChangeOrderPlan(orderID int, plan Widget, amount int, h OrderHistory) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `UPDATE orders SET widget_id = ?, amount = ?, updated_at = ? WHERE id = ?`,
+		plan.ID, amount, time.Now(), orderID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE order_items SET widget_id = ?, unit_price = ?, amount = quantity * ?, updated_at = ?
+		WHERE order_id = ?
+	`, plan.ID, amount, amount, time.Now(), orderID)
+	if err != nil {
+		return err
+	}
+
+	h.OrderID = orderID
+	if _, err = insertOrderHistory(ctx, tx, h); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,239 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxOrderItems is the most lines an order may have, the lines must fit in the payment intent metadata
+const MaxOrderItems = 20
+
+// OrderItem is the type for one line of an order
+type OrderItem struct {
+	ID        int       `json:"id"`
+	OrderID   int       `json:"order_id"`
+	WidgetID  int       `json:"widget_id"`
+	Quantity  int       `json:"quantity"`
+	UnitPrice int       `json:"unit_price"`
+	Amount    int       `json:"amount"`
+	Widget    Widget    `json:"widget"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// execer runs statements on the database or inside a database transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// InsertOrderItem insert a line of an order and returns the id
+func (m *DBModel) InsertOrderItem(item OrderItem) (int, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	return insertOrderItem(ctx, m.DB, item)
+}
+
+func insertOrderItem(ctx context.Context, db execer, item OrderItem) (int, error) {
+	stmt := `
+		INSERT INTO order_items
+		(order_id, widget_id, quantity, unit_price, amount, created_at, updated_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?)
+	`
+
+	result, err := db.ExecContext(ctx, stmt,
+		item.OrderID,
+		item.WidgetID,
+		item.Quantity,
+		item.UnitPrice,
+		item.Amount,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// GetOrderItems returns the lines of an order
+func (m *DBModel) GetOrderItems(orderID int) ([]*OrderItem, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	query := `
+		SELECT i.id, i.order_id, i.widget_id, i.quantity, i.unit_price, i.amount,
+			i.created_at, i.updated_at, coalesce(w.id, 0), coalesce(w.name, '')
+		FROM order_items i
+			LEFT JOIN widgets w ON (i.widget_id = w.id)
+		WHERE i.order_id = ?
+		ORDER BY i.id
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []*OrderItem{}
+	for rows.Next() {
+		var i OrderItem
+		err = rows.Scan(
+			&i.ID,
+			&i.OrderID,
+			&i.WidgetID,
+			&i.Quantity,
+			&i.UnitPrice,
+			&i.Amount,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Widget.ID,
+			&i.Widget.Name,
+		)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+
+	return items, rows.Err()
+}
+
+// attachOrderItems loads the lines of the orders with one query, orders placed
+// before order items existed get one line made from the order itself
+func (m *DBModel) attachOrderItems(orders []*Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	byID := make(map[int]*Order, len(orders))
+	placeholders := make([]string, 0, len(orders))
+	args := make([]interface{}, 0, len(orders))
+	for _, o := range orders {
+		o.Items = []*OrderItem{}
+		byID[o.ID] = o
+		placeholders = append(placeholders, "?")
+		args = append(args, o.ID)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT i.id, i.order_id, i.widget_id, i.quantity, i.unit_price, i.amount,
+			i.created_at, i.updated_at, coalesce(w.id, 0), coalesce(w.name, '')
+		FROM order_items i
+			LEFT JOIN widgets w ON (i.widget_id = w.id)
+		WHERE i.order_id IN (%s)
+		ORDER BY i.order_id, i.id
+	`, strings.Join(placeholders, ","))
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i OrderItem
+		err = rows.Scan(
+			&i.ID,
+			&i.OrderID,
+			&i.WidgetID,
+			&i.Quantity,
+			&i.UnitPrice,
+			&i.Amount,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Widget.ID,
+			&i.Widget.Name,
+		)
+		if err != nil {
+			return err
+		}
+		if o, ok := byID[i.OrderID]; ok {
+			o.Items = append(o.Items, &i)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, o := range orders {
+		if len(o.Items) == 0 {
+			o.Items = []*OrderItem{legacyOrderItem(o)}
+		}
+	}
+
+	return nil
+}
+
+func legacyOrderItem(o *Order) *OrderItem {
+	item := &OrderItem{
+		OrderID:   o.ID,
+		WidgetID:  o.WidgetID,
+		Quantity:  o.Quantity,
+		Amount:    o.Amount,
+		Widget:    o.Widget,
+		CreatedAt: o.CreatedAt,
+		UpdatedAt: o.UpdatedAt,
+	}
+	if o.Quantity > 0 {
+		item.UnitPrice = o.Amount / o.Quantity
+	}
+	return item
+}
+
+// FormatItemsMetadata encodes order lines for payment intent metadata as widget:quantity:unit_price,...
+func FormatItemsMetadata(items []*OrderItem) string {
+	parts := make([]string, 0, len(items))
+	for _, i := range items {
+		parts = append(parts, fmt.Sprintf("%d:%d:%d", i.WidgetID, i.Quantity, i.UnitPrice))
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseItemsMetadata decodes order lines written by FormatItemsMetadata
+func ParseItemsMetadata(s string) ([]*OrderItem, error) {
+	items := []*OrderItem{}
+	if s == "" {
+		return items, nil
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		fields := strings.Split(part, ":")
+		if len(fields) != 3 {
+			return nil, errors.New("invalid order items metadata")
+		}
+
+		var n [3]int
+		for i, f := range fields {
+			v, err := strconv.Atoi(f)
+			if err != nil {
+				return nil, errors.New("invalid order items metadata")
+			}
+			n[i] = v
+		}
+
+		items = append(items, &OrderItem{
+			WidgetID:  n[0],
+			Quantity:  n[1],
+			UnitPrice: n[2],
+			Amount:    n[1] * n[2],
+		})
+	}
+
+	return items, nil
+}
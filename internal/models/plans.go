@@ -0,0 +1,77 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// widgetColumns are read with scanWidget, slug, interval, features, trial and usage unit are only set for plans
+const widgetColumns = `
+	id, name, description, inventory_level, price, coalesce(image, ''), is_recurring, plan_id,
+	coalesce(slug, ''), plan_interval, plan_interval_count, coalesce(features, ''), trial_period_days,
+	usage_unit, created_at, updated_at
+`
+
+func scanWidget(row rowScanner) (Widget, error) {
+	var widget Widget
+	var features string
+	err := row.Scan(
+		&widget.ID,
+		&widget.Name,
+		&widget.Description,
+		&widget.InventoryLevel,
+		&widget.Price,
+		&widget.Image,
+		&widget.IsRecurring,
+		&widget.PlanID,
+		&widget.Slug,
+		&widget.Interval,
+		&widget.IntervalCount,
+		&features,
+		&widget.TrialDays,
+		&widget.UsageUnit,
+		&widget.CreatedAt,
+		&widget.UpdatedAt,
+	)
+	widget.Features = splitFeatures(features)
+	return widget, err
+}
+
+// splitFeatures returns the features of a plan, they are stored one per line
+func splitFeatures(s string) []string {
+	features := []string{}
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			features = append(features, line)
+		}
+	}
+	return features
+}
+
+// BillingPeriod describes how often a plan is billed, e.g. month or 3 months
+func (w Widget) BillingPeriod() string {
+	interval := w.Interval
+	if interval == "" {
+		interval = "month"
+	}
+	if w.IntervalCount <= 1 {
+		return interval
+	}
+	return fmt.Sprintf("%d %ss", w.IntervalCount, interval)
+}
+
+// Metered reports whether a plan is billed by the usage reported for it, e.g. per API call
+func (w Widget) Metered() bool {
+	return w.UsageUnit != ""
+}
+
+// GetPlanBySlug returns the plan with a slug
+func (m *DBModel) GetPlanBySlug(slug string) (Widget, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	row := m.DB.QueryRowContext(ctx, `SELECT `+widgetColumns+` FROM widgets WHERE slug = ? AND is_recurring = 1`, slug)
+	return scanWidget(row)
+}
@@ -0,0 +1,148 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/fajarcahyadiputra/udemy-web-application/internal/currency"
+)
+
+// WidgetPrice is the type for the price of a widget or plan in one currency,
+// amounts are in minor units of the currency
+type WidgetPrice struct {
+	ID         int       `json:"id"`
+	WidgetID   int       `json:"widget_id"`
+	Currency   string    `json:"currency"`
+	Price      int       `json:"price"`
+	PlanID     string    `json:"plan_id"`
+	MinorUnits int       `json:"minor_units"`
+	Formatted  string    `json:"formatted"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// ErrNoPrice is returned when a widget is not sold in a currency
+var ErrNoPrice = errors.New("widget is not sold in this currency")
+
+// defaultPrice is the widget price in the default currency, used when there is no price row for it
+func defaultPrice(widget Widget) WidgetPrice {
+	return WidgetPrice{
+		WidgetID:  widget.ID,
+		Currency:  currency.DefaultCurrency,
+		Price:     widget.Price,
+		PlanID:    widget.PlanID,
+		CreatedAt: widget.CreatedAt,
+		UpdatedAt: widget.UpdatedAt,
+	}
+}
+
+func (p *WidgetPrice) format() {
+	c, _ := currency.Get(p.Currency)
+	p.MinorUnits = c.MinorUnits
+	p.Formatted = currency.Format(p.Price, p.Currency)
+}
+
+// GetWidgetPrice returns the price of a widget in a currency
+func (m *DBModel) GetWidgetPrice(widget Widget, code string) (WidgetPrice, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	code = strings.ToLower(code)
+	if !currency.Valid(code) {
+		return WidgetPrice{}, ErrNoPrice
+	}
+
+	var p WidgetPrice
+
+	query := `
+		SELECT id, widget_id, currency, price, coalesce(plan_id, ''), created_at, updated_at
+		FROM widget_prices
+		WHERE widget_id = ? AND currency = ?
+	`
+
+	row := m.DB.QueryRowContext(ctx, query, widget.ID, code)
+	err := row.Scan(
+		&p.ID,
+		&p.WidgetID,
+		&p.Currency,
+		&p.Price,
+		&p.PlanID,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return p, err
+		}
+		if code != currency.DefaultCurrency {
+			return p, ErrNoPrice
+		}
+		p = defaultPrice(widget)
+	}
+
+	p.format()
+	return p, nil
+}
+
+// GetWidgetPrices returns all prices of a widget, the default currency first
+func (m *DBModel) GetWidgetPrices(widget Widget) ([]WidgetPrice, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	prices := []WidgetPrice{}
+
+	query := `
+		SELECT id, widget_id, currency, price, coalesce(plan_id, ''), created_at, updated_at
+		FROM widget_prices
+		WHERE widget_id = ?
+		ORDER BY currency
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, widget.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hasDefault := false
+	for rows.Next() {
+		var p WidgetPrice
+		err = rows.Scan(
+			&p.ID,
+			&p.WidgetID,
+			&p.Currency,
+			&p.Price,
+			&p.PlanID,
+			&p.CreatedAt,
+			&p.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		// rows for currencies we no longer support are not offered
+		if !currency.Valid(p.Currency) {
+			continue
+		}
+		p.format()
+		if p.Currency == currency.DefaultCurrency {
+			hasDefault = true
+			prices = append([]WidgetPrice{p}, prices...)
+			continue
+		}
+		prices = append(prices, p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if !hasDefault {
+		p := defaultPrice(widget)
+		p.format()
+		prices = append([]WidgetPrice{p}, prices...)
+	}
+
+	return prices, nil
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// PaymentReconciliation marks a payment the gateway took but the order for it could not be saved,
+// payload keeps what is needed to write the order or refund the customer
+type PaymentReconciliation struct {
+	ID            int        `json:"id"`
+	PaymentIntent string     `json:"payment_intent"`
+	Amount        int        `json:"amount"`
+	Currency      string     `json:"currency"`
+	Email         string     `json:"email"`
+	Reason        string     `json:"reason"`
+	Payload       string     `json:"payload"`
+	ResolvedAt    *time.Time `json:"resolved_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// InsertPaymentReconciliation records a payment that needs to be reconciled and returns the id
+func (m *DBModel) InsertPaymentReconciliation(p PaymentReconciliation) (int, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	stmt := `
+		INSERT INTO payment_reconciliations
+		(payment_intent, amount, currency, email, reason, payload, created_at, updated_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	result, err := m.DB.ExecContext(ctx, stmt,
+		p.PaymentIntent,
+		p.Amount,
+		p.Currency,
+		p.Email,
+		p.Reason,
+		p.Payload,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
@@ -0,0 +1,216 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// statuses of a reconciliation report
+const (
+	ReconciliationRunning   = "running"
+	ReconciliationCompleted = "completed"
+	ReconciliationFailed    = "failed"
+)
+
+// ReconciliationReport is the type for one comparison of our transactions with the gateway,
+// issues holds the JSON list of records that did not match
+type ReconciliationReport struct {
+	ID         int       `json:"id"`
+	DateFrom   time.Time `json:"date_from"`
+	DateTo     time.Time `json:"date_to"`
+	Status     string    `json:"status"`
+	Matched    int       `json:"matched"`
+	Missing    int       `json:"missing"`
+	Extra      int       `json:"extra"`
+	Mismatched int       `json:"mismatched"`
+	Issues     []byte    `json:"-"`
+	Error      string    `json:"error"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// SettledTransaction is a transaction the gateway should have, recurring is set for subscriptions
+type SettledTransaction struct {
+	Transaction
+	Recurring bool `json:"recurring"`
+}
+
+// InsertReconciliationReport saves a new report and returns the id
+func (m *DBModel) InsertReconciliationReport(r ReconciliationReport) (int, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	stmt := `
+		INSERT INTO reconciliation_reports
+		(date_from, date_to, status, matched, missing, extra, mismatched, issues, error, created_at, updated_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	result, err := m.DB.ExecContext(ctx, stmt,
+		r.DateFrom,
+		r.DateTo,
+		r.Status,
+		r.Matched,
+		r.Missing,
+		r.Extra,
+		r.Mismatched,
+		string(r.Issues),
+		r.Error,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// UpdateReconciliationReport saves the outcome of a report
+func (m *DBModel) UpdateReconciliationReport(r ReconciliationReport) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	stmt := `
+		UPDATE reconciliation_reports SET
+			status = ?, matched = ?, missing = ?, extra = ?, mismatched = ?, issues = ?, error = ?, updated_at = ?
+		WHERE id = ?
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		r.Status,
+		r.Matched,
+		r.Missing,
+		r.Extra,
+		r.Mismatched,
+		string(r.Issues),
+		r.Error,
+		time.Now(),
+		r.ID,
+	)
+	return err
+}
+
+// GetLastReconciliationReport returns the report started last
+func (m *DBModel) GetLastReconciliationReport() (ReconciliationReport, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	var r ReconciliationReport
+
+	query := `
+		SELECT id, date_from, date_to, status, matched, missing, extra, mismatched, issues, error, created_at, updated_at
+		FROM reconciliation_reports
+		ORDER BY id DESC
+		LIMIT 1
+	`
+
+	row := m.DB.QueryRowContext(ctx, query)
+	err := row.Scan(
+		&r.ID,
+		&r.DateFrom,
+		&r.DateTo,
+		&r.Status,
+		&r.Matched,
+		&r.Missing,
+		&r.Extra,
+		&r.Mismatched,
+		&r.Issues,
+		&r.Error,
+		&r.CreatedAt,
+		&r.UpdatedAt,
+	)
+	return r, err
+}
+
+// GetSettledTransactions returns the cleared and refunded transactions created in the range
+func (m *DBModel) GetSettledTransactions(from, to time.Time) ([]*SettledTransaction, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	query := `
+		SELECT t.id, t.amount, t.currency, t.payment_intent, t.bank_return_code, t.transaction_status_id,
+			t.created_at, t.updated_at, coalesce(w.is_recurring, 0)
+		FROM transactions t
+			LEFT JOIN orders o ON (o.transaction_id = t.id)
+			LEFT JOIN widgets w ON (o.widget_id = w.id)
+		WHERE t.created_at >= ? AND t.created_at < ? AND t.transaction_status_id IN (?, ?, ?)
+		ORDER BY t.id
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, from, to,
+		TransactionCleared, TransactionPartiallyRefunded, TransactionRefunded)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var txns []*SettledTransaction
+	for rows.Next() {
+		var t SettledTransaction
+		err := rows.Scan(
+			&t.ID,
+			&t.Amount,
+			&t.Currency,
+			&t.PaymentIntent,
+			&t.BankReturnCode,
+			&t.TransactionStatusID,
+			&t.CreatedAt,
+			&t.UpdatedAt,
+			&t.Recurring,
+		)
+		if err != nil {
+			return nil, err
+		}
+		txns = append(txns, &t)
+	}
+
+	return txns, rows.Err()
+}
+
+// GetRefundsBetween returns the refunds recorded in the range
+func (m *DBModel) GetRefundsBetween(from, to time.Time) ([]*Refund, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	query := `
+		SELECT id, order_id, transaction_id, user_id, amount, currency, reason, gateway_refund_id, created_at, updated_at
+		FROM refunds
+		WHERE created_at >= ? AND created_at < ?
+		ORDER BY id
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var refunds []*Refund
+	for rows.Next() {
+		var r Refund
+		err := rows.Scan(
+			&r.ID,
+			&r.OrderID,
+			&r.TransactionID,
+			&r.UserID,
+			&r.Amount,
+			&r.Currency,
+			&r.Reason,
+			&r.GatewayRefundID,
+			&r.CreatedAt,
+			&r.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		refunds = append(refunds, &r)
+	}
+
+	return refunds, rows.Err()
+}
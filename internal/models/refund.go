@@ -0,0 +1,271 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Refund is the type for one refund issued against an order
+type Refund struct {
+	ID              int       `json:"id"`
+	OrderID         int       `json:"order_id"`
+	TransactionID   int       `json:"transaction_id"`
+	UserID          int       `json:"user_id"`
+	Amount          int       `json:"amount"`
+	Currency        string    `json:"currency"`
+	Reason          string    `json:"reason"`
+	GatewayRefundID string    `json:"gateway_refund_id"`
+	User            User      `json:"user"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// RefundExceededError is returned when a refund is more than what is left to refund of the order
+type RefundExceededError struct {
+	Remaining int
+}
+
+func (e *RefundExceededError) Error() string {
+	return fmt.Sprintf("must not be more than the remaining refundable amount of %d", e.Remaining)
+}
+
+// RefundOrder refunds part or all of an order while its transaction row is locked, so two refunds
+// made at the same time can't together refund more than was paid. The order and transaction become
+// (partially) refunded, gatewayRefund makes the refund at the gateway and returns its id.
+// It returns the order status set and the amount left to refund.
+func (m *DBModel) RefundOrder(r Refund, gatewayRefund func() (string, error)) (int, int, error) {
+	// the lock is held while the gateway refunds
+	ctx, cancle := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancle()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	var txn Transaction
+	var refunded, orderStatusID int
+	row := tx.QueryRowContext(ctx, `
+		SELECT amount, authorized_amount, captured_amount FROM transactions WHERE id = ? FOR UPDATE
+	`, r.TransactionID)
+	if err = row.Scan(&txn.Amount, &txn.AuthorizedAmount, &txn.CapturedAmount); err != nil {
+		return 0, 0, err
+	}
+	row = tx.QueryRowContext(ctx, `SELECT coalesce(sum(amount), 0) FROM refunds WHERE order_id = ?`, r.OrderID)
+	if err = row.Scan(&refunded); err != nil {
+		return 0, 0, err
+	}
+	row = tx.QueryRowContext(ctx, `SELECT status_id FROM orders WHERE id = ?`, r.OrderID)
+	if err = row.Scan(&orderStatusID); err != nil {
+		return 0, 0, err
+	}
+
+	remaining := txn.refundable() - refunded
+	if r.Amount > remaining {
+		return 0, remaining, &RefundExceededError{Remaining: remaining}
+	}
+
+	newOrderStatusID, txnStatusID := OrderRefunded, TransactionRefunded
+	if r.Amount < remaining {
+		newOrderStatusID, txnStatusID = OrderPartiallyRefunded, TransactionPartiallyRefunded
+	}
+	if !CanChangeOrderStatus(orderStatusID, newOrderStatusID) {
+		return 0, remaining, fmt.Errorf("%w: a %s order can not be refunded",
+			ErrIllegalTransition, strings.ToLower(OrderStatusName(orderStatusID)))
+	}
+
+	r.GatewayRefundID, err = gatewayRefund()
+	if err != nil {
+		return 0, remaining, err
+	}
+
+	if _, err = insertRefund(ctx, tx, r, newOrderStatusID, txnStatusID); err != nil {
+		return 0, remaining, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, remaining, err
+	}
+
+	return newOrderStatusID, remaining - r.Amount, nil
+}
+
+// refundable returns what was taken from the card, for an authorization that is what was captured
+func (t Transaction) refundable() int {
+	if t.AuthorizedAmount > 0 {
+		return t.CapturedAmount
+	}
+	return t.Amount
+}
+
+// InsertRefund records a refund and sets the order and transaction status in one database transaction,
+// returns 0 when the gateway refund was already recorded and ErrIllegalTransition when the order
+// can not be refunded
+func (m *DBModel) InsertRefund(r Refund, orderStatusID, txnStatusID int) (int, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	id, err := insertRefund(ctx, tx, r, orderStatusID, txnStatusID)
+	if err != nil || id == 0 {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// insertRefund locks the transaction before the refund row, in the order RefundOrder does, so a
+// webhook recording the same refund waits for it instead of deadlocking
+func insertRefund(ctx context.Context, tx *sql.Tx, r Refund, orderStatusID, txnStatusID int) (int, error) {
+	var id int
+	row := tx.QueryRowContext(ctx, `SELECT id FROM transactions WHERE id = ? FOR UPDATE`, r.TransactionID)
+	if err := row.Scan(&id); err != nil {
+		return 0, err
+	}
+
+	stmt := `
+		INSERT IGNORE INTO refunds
+		(order_id, transaction_id, user_id, amount, currency, reason, gateway_refund_id, created_at, updated_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	result, err := tx.ExecContext(ctx, stmt,
+		r.OrderID,
+		r.TransactionID,
+		r.UserID,
+		r.Amount,
+		r.Currency,
+		r.Reason,
+		r.GatewayRefundID,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if rows == 0 {
+		return 0, nil
+	}
+
+	refundID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	reason := fmt.Sprintf("Refunded %d %s", r.Amount, r.Currency)
+	if r.Reason != "" {
+		reason = fmt.Sprintf("%s: %s", reason, r.Reason)
+	}
+	if err = setOrderStatus(ctx, tx, r.OrderID, orderStatusID, r.UserID, reason); err != nil {
+		return 0, err
+	}
+
+	if err = setTransactionStatus(ctx, tx, r.TransactionID, txnStatusID); err != nil {
+		return 0, err
+	}
+
+	return int(refundID), nil
+}
+
+// GetRefundsForOrder returns the refund history of an order, oldest first
+func (m *DBModel) GetRefundsForOrder(orderID int) ([]*Refund, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	refunds := []*Refund{}
+
+	query := `
+		SELECT r.id, r.order_id, r.transaction_id, r.user_id, r.amount, r.currency,
+			r.reason, r.gateway_refund_id, r.created_at, r.updated_at,
+			coalesce(u.first_name, ''), coalesce(u.last_name, ''), coalesce(u.email, '')
+		FROM refunds r
+			LEFT JOIN users u ON (r.user_id = u.id)
+		WHERE r.order_id = ?
+		ORDER BY r.created_at, r.id
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r Refund
+		err = rows.Scan(
+			&r.ID,
+			&r.OrderID,
+			&r.TransactionID,
+			&r.UserID,
+			&r.Amount,
+			&r.Currency,
+			&r.Reason,
+			&r.GatewayRefundID,
+			&r.CreatedAt,
+			&r.UpdatedAt,
+			&r.User.FirstName,
+			&r.User.LastName,
+			&r.User.Email,
+		)
+		if err != nil {
+			return nil, err
+		}
+		r.User.ID = r.UserID
+		refunds = append(refunds, &r)
+	}
+
+	return refunds, nil
+}
+
+// GetRefundedAmount returns the total amount refunded for an order
+func (m *DBModel) GetRefundedAmount(orderID int) (int, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	var total int
+	row := m.DB.QueryRowContext(ctx, `SELECT coalesce(sum(amount), 0) FROM refunds WHERE order_id = ?`, orderID)
+	err := row.Scan(&total)
+	if err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// GetOrderIDByPaymentIntent returns the order and transaction id paid by a payment intent
+func (m *DBModel) GetOrderIDByPaymentIntent(pi string) (int, int, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	var orderID, txnID int
+	query := `
+		SELECT o.id, t.id
+		FROM orders o
+			INNER JOIN transactions t ON (o.transaction_id = t.id)
+		WHERE t.payment_intent = ?
+		ORDER BY o.id
+		LIMIT 1
+	`
+	err := m.DB.QueryRowContext(ctx, query, pi).Scan(&orderID, &txnID)
+	if err != nil {
+		return 0, 0, err
+	}
+	return orderID, txnID, nil
+}
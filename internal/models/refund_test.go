@@ -0,0 +1,24 @@
+package models
+
+import "testing"
+
+func TestTransactionRefundable(t *testing.T) {
+	tests := []struct {
+		name string
+		txn  Transaction
+		want int
+	}{
+		{"charge", Transaction{Amount: 1000}, 1000},
+		{"authorization not captured", Transaction{Amount: 1000, AuthorizedAmount: 1000}, 0},
+		{"authorization partly captured", Transaction{Amount: 1000, AuthorizedAmount: 1000, CapturedAmount: 500}, 500},
+		{"authorization captured", Transaction{Amount: 1000, AuthorizedAmount: 1000, CapturedAmount: 1000}, 1000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.txn.refundable(); got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Settlement is what the gateway paid out for a transaction. Fee and net are in the settlement
+// currency, exchange rate converts the transaction currency to it and is 1 when they are the same.
+type Settlement struct {
+	BalanceTransaction string    `json:"balance_transaction"`
+	Fee                int       `json:"fee"`
+	Net                int       `json:"net"`
+	Currency           string    `json:"currency"`
+	ExchangeRate       float64   `json:"exchange_rate"`
+	AvailableOn        time.Time `json:"available_on"`
+}
+
+// InvoiceSettlement is a paid invoice of a subscription, each renewal is charged and settled on its own
+// while the subscription has one transaction
+type InvoiceSettlement struct {
+	ID            int         `json:"id"`
+	TransactionID int         `json:"transaction_id"`
+	InvoiceID     string      `json:"invoice_id"`
+	Amount        int         `json:"amount"`
+	Currency      string      `json:"currency"`
+	PaidAt        time.Time   `json:"paid_at"`
+	Settlement    *Settlement `json:"settlement"`
+	CreatedAt     time.Time   `json:"created_at"`
+	UpdatedAt     time.Time   `json:"updated_at"`
+}
+
+// maxSettlementAttempts is how many times the gateway is asked for the settlement of a charge,
+// one it still has none for is left for reconciliation so it doesn't hold up newer charges
+const maxSettlementAttempts = 48
+
+// RevenueReportRow is the settled revenue of one period in one settlement currency
+type RevenueReportRow struct {
+	Period       string  `json:"period"`
+	Currency     string  `json:"currency"`
+	Transactions int     `json:"transactions"`
+	Gross        int     `json:"gross"`
+	Fees         int     `json:"fees"`
+	Net          int     `json:"net"`
+	FeeRate      float64 `json:"fee_rate"`
+}
+
+// settlementRow holds the settlement columns of a transaction, balance transaction is empty until
+// the charge is settled
+type settlementRow struct {
+	balanceTransaction string
+	fee                int
+	net                int
+	currency           string
+	exchangeRate       float64
+	availableOn        sql.NullTime
+}
+
+func (s *settlementRow) settlement() *Settlement {
+	if s.balanceTransaction == "" {
+		return nil
+	}
+	return &Settlement{
+		BalanceTransaction: s.balanceTransaction,
+		Fee:                s.fee,
+		Net:                s.net,
+		Currency:           s.currency,
+		ExchangeRate:       s.exchangeRate,
+		AvailableOn:        s.availableOn.Time,
+	}
+}
+
+// GetUnsettledTransactions returns the paid transactions created since the given time that have
+// no settlement yet, after the transaction afterID and oldest first. Subscriptions are settled
+// by invoice.
+func (m *DBModel) GetUnsettledTransactions(since time.Time, afterID, limit int) ([]*Transaction, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	query := `
+		SELECT id, amount, currency, payment_intent, transaction_status_id, created_at, updated_at
+		FROM transactions
+		WHERE balance_transaction = '' AND created_at >= ? AND id > ? AND transaction_status_id IN (?, ?, ?)
+			AND settlement_attempts < ? AND LEFT(payment_intent, 4) <> 'sub_'
+		ORDER BY id
+		LIMIT ?
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, since, afterID,
+		TransactionCleared, TransactionRefunded, TransactionPartiallyRefunded, maxSettlementAttempts, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var txns []*Transaction
+	for rows.Next() {
+		var t Transaction
+		err := rows.Scan(
+			&t.ID,
+			&t.Amount,
+			&t.Currency,
+			&t.PaymentIntent,
+			&t.TransactionStatusID,
+			&t.CreatedAt,
+			&t.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		txns = append(txns, &t)
+	}
+
+	return txns, rows.Err()
+}
+
+// SaveSettlement stores the settlement of a transaction
+func (m *DBModel) SaveSettlement(txnID int, s Settlement) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	stmt := `
+		UPDATE transactions SET
+			balance_transaction = ?, gateway_fee = ?, net_amount = ?, settlement_currency = ?,
+			exchange_rate = ?, available_on = ?, updated_at = ?
+		WHERE id = ?
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		s.BalanceTransaction,
+		s.Fee,
+		s.Net,
+		s.Currency,
+		s.ExchangeRate,
+		s.AvailableOn,
+		time.Now(),
+		txnID,
+	)
+	return err
+}
+
+// AddSettlementAttempt counts a time the gateway had no settlement for a transaction
+func (m *DBModel) AddSettlementAttempt(txnID int) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	stmt := `UPDATE transactions SET settlement_attempts = settlement_attempts + 1, updated_at = ? WHERE id = ?`
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now(), txnID)
+	return err
+}
+
+// InsertInvoiceSettlement records a paid invoice to be settled, an invoice already recorded is left as it is
+func (m *DBModel) InsertInvoiceSettlement(inv InvoiceSettlement) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	stmt := `
+		INSERT IGNORE INTO invoice_settlements
+		(transaction_id, invoice_id, amount, currency, paid_at, created_at, updated_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?)
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		inv.TransactionID,
+		inv.InvoiceID,
+		inv.Amount,
+		inv.Currency,
+		inv.PaidAt,
+		time.Now(),
+		time.Now(),
+	)
+	return err
+}
+
+// GetUnsettledInvoices returns the invoices paid since the given time that have no settlement yet,
+// after the invoice afterID and oldest first
+func (m *DBModel) GetUnsettledInvoices(since time.Time, afterID, limit int) ([]*InvoiceSettlement, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	query := `
+		SELECT id, transaction_id, invoice_id, amount, currency, paid_at, created_at, updated_at
+		FROM invoice_settlements
+		WHERE balance_transaction = '' AND paid_at >= ? AND id > ? AND settlement_attempts < ?
+		ORDER BY id
+		LIMIT ?
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, since, afterID, maxSettlementAttempts, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invoices []*InvoiceSettlement
+	for rows.Next() {
+		var inv InvoiceSettlement
+		err := rows.Scan(
+			&inv.ID,
+			&inv.TransactionID,
+			&inv.InvoiceID,
+			&inv.Amount,
+			&inv.Currency,
+			&inv.PaidAt,
+			&inv.CreatedAt,
+			&inv.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, &inv)
+	}
+
+	return invoices, rows.Err()
+}
+
+// SaveInvoiceSettlement stores the settlement of an invoice
+func (m *DBModel) SaveInvoiceSettlement(id int, s Settlement) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	stmt := `
+		UPDATE invoice_settlements SET
+			balance_transaction = ?, gateway_fee = ?, net_amount = ?, settlement_currency = ?,
+			exchange_rate = ?, available_on = ?, updated_at = ?
+		WHERE id = ?
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		s.BalanceTransaction,
+		s.Fee,
+		s.Net,
+		s.Currency,
+		s.ExchangeRate,
+		s.AvailableOn,
+		time.Now(),
+		id,
+	)
+	return err
+}
+
+// AddInvoiceSettlementAttempt counts a time the gateway had no settlement for an invoice
+func (m *DBModel) AddInvoiceSettlementAttempt(id int) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	stmt := `UPDATE invoice_settlements SET settlement_attempts = settlement_attempts + 1, updated_at = ? WHERE id = ?`
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now(), id)
+	return err
+}
+
+// GetRevenueReport sums the settled transactions created and the settled invoices paid in the range
+// by day or month and settlement currency, what is not settled yet is left out. Subscriptions settled
+// by transaction before they were settled by invoice are counted once.
+func (m *DBModel) GetRevenueReport(from, to time.Time, monthly bool) ([]*RevenueReportRow, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	format := "%Y-%m-%d"
+	if monthly {
+		format = "%Y-%m"
+	}
+
+	query := `
+		SELECT s.period, s.settlement_currency,
+			count(*), coalesce(sum(s.net_amount + s.gateway_fee), 0), coalesce(sum(s.gateway_fee), 0), coalesce(sum(s.net_amount), 0)
+		FROM (
+			SELECT DATE_FORMAT(created_at, ?) AS period, settlement_currency, gateway_fee, net_amount
+			FROM transactions
+			WHERE balance_transaction <> '' AND created_at >= ? AND created_at < ?
+			UNION ALL
+			SELECT DATE_FORMAT(i.paid_at, ?), i.settlement_currency, i.gateway_fee, i.net_amount
+			FROM invoice_settlements i
+			WHERE i.balance_transaction <> '' AND i.paid_at >= ? AND i.paid_at < ?
+				AND NOT EXISTS (SELECT 1 FROM transactions t WHERE t.balance_transaction = i.balance_transaction)
+		) s
+		GROUP BY s.period, s.settlement_currency
+		ORDER BY s.period, s.settlement_currency
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, format, from, to, format, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := []*RevenueReportRow{}
+	for rows.Next() {
+		var r RevenueReportRow
+		err := rows.Scan(
+			&r.Period,
+			&r.Currency,
+			&r.Transactions,
+			&r.Gross,
+			&r.Fees,
+			&r.Net,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if r.Gross > 0 {
+			r.FeeRate = float64(r.Fees) / float64(r.Gross)
+		}
+		report = append(report, &r)
+	}
+
+	return report, rows.Err()
+}
@@ -0,0 +1,340 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// order statuses, the ids of the rows in the statuses table
+const (
+	OrderCharged           = 1
+	OrderRefunded          = 2
+	OrderCancelled         = 3
+	OrderPartiallyRefunded = 4
+)
+
+// transaction statuses, the ids of the rows in the transaction_statuses table
+const (
+	TransactionPending           = 1
+	TransactionCleared           = 2
+	TransactionDeclined          = 3
+	TransactionRefunded          = 4
+	TransactionPartiallyRefunded = 5
+	TransactionAuthorized        = 6
+	TransactionVoided            = 7
+)
+
+// ErrIllegalTransition is returned when a status can not be changed to the one asked for
+var ErrIllegalTransition = errors.New("illegal status transition")
+
+var orderStatusNames = map[int]string{
+	OrderCharged:           "Charged",
+	OrderRefunded:          "Refunded",
+	OrderCancelled:         "Cancelled",
+	OrderPartiallyRefunded: "Partially refunded",
+}
+
+var transactionStatusNames = map[int]string{
+	TransactionPending:           "Pending",
+	TransactionCleared:           "Cleared",
+	TransactionDeclined:          "Declined",
+	TransactionRefunded:          "Refunded",
+	TransactionPartiallyRefunded: "Partially refunded",
+	TransactionAuthorized:        "Authorized",
+	TransactionVoided:            "Voided",
+}
+
+// orderTransitions lists the statuses an order can move to. A subscription stays charged while
+// it renews and another partial refund keeps it partially refunded, refunded and cancelled are final.
+var orderTransitions = map[int][]int{
+	OrderCharged:           {OrderCharged, OrderRefunded, OrderCancelled, OrderPartiallyRefunded},
+	OrderPartiallyRefunded: {OrderRefunded, OrderCancelled, OrderPartiallyRefunded},
+}
+
+// transactionTransitions lists the statuses a transaction can move to. The transaction of a
+// subscription follows its invoices, so it goes between cleared and declined as renewals fail and recover.
+var transactionTransitions = map[int][]int{
+	TransactionPending:           {TransactionCleared, TransactionDeclined},
+	TransactionCleared:           {TransactionCleared, TransactionDeclined, TransactionRefunded, TransactionPartiallyRefunded},
+	TransactionDeclined:          {TransactionCleared, TransactionDeclined},
+	TransactionPartiallyRefunded: {TransactionRefunded, TransactionPartiallyRefunded},
+	TransactionAuthorized:        {TransactionCleared, TransactionVoided},
+}
+
+// OrderStatusName returns the name of an order status
+func OrderStatusName(id int) string {
+	if name, ok := orderStatusNames[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("status %d", id)
+}
+
+// TransactionStatusName returns the name of a transaction status
+func TransactionStatusName(id int) string {
+	if name, ok := transactionStatusNames[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("status %d", id)
+}
+
+// CanChangeOrderStatus reports whether an order can move from one status to the other
+func CanChangeOrderStatus(from, to int) bool {
+	return allowed(orderTransitions, from, to)
+}
+
+// CanChangeTransactionStatus reports whether a transaction can move from one status to the other
+func CanChangeTransactionStatus(from, to int) bool {
+	return allowed(transactionTransitions, from, to)
+}
+
+func allowed(transitions map[int][]int, from, to int) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// OrderStatusChange is the type for one change of the status of an order, user id is 0 for
+// changes made by the gateway or the app itself
+type OrderStatusChange struct {
+	ID           int       `json:"id"`
+	OrderID      int       `json:"order_id"`
+	FromStatusID int       `json:"from_status_id"`
+	ToStatusID   int       `json:"to_status_id"`
+	UserID       int       `json:"user_id"`
+	Reason       string    `json:"reason"`
+	User         User      `json:"user"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	execer
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// UpdateOrderStatus moves an order to a new status and records who changed it, the
+// change is refused with ErrIllegalTransition when the current status does not allow it
+func (m *DBModel) UpdateOrderStatus(id, statusID, userID int, reason string) error {
+	ctx, cancle := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancle()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = setOrderStatus(ctx, tx, id, statusID, userID, reason); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateOrderStatusByPaymentIntent sets the status of the orders paid by a payment intent or subscription id
+func (m *DBModel) UpdateOrderStatusByPaymentIntent(pi string, statusID int, reason string) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ids, err := selectIDs(ctx, tx, `
+		SELECT o.id
+		FROM orders o
+			INNER JOIN transactions t ON (o.transaction_id = t.id)
+		WHERE t.payment_intent = ?
+	`, pi)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err = setOrderStatus(ctx, tx, id, statusID, 0, reason); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UpdateTransactionStatusByPaymentIntent sets the status of the transactions for a payment intent or subscription id
+func (m *DBModel) UpdateTransactionStatusByPaymentIntent(pi string, statusID int) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ids, err := selectIDs(ctx, tx, `SELECT id FROM transactions WHERE payment_intent = ?`, pi)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err = setTransactionStatus(ctx, tx, id, statusID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetOrderStatusHistory returns the status changes of an order, oldest first
+func (m *DBModel) GetOrderStatusHistory(orderID int) ([]*OrderStatusChange, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	changes := []*OrderStatusChange{}
+
+	query := `
+		SELECT h.id, h.order_id, h.from_status_id, h.to_status_id, h.user_id, h.reason, h.created_at, h.updated_at,
+			coalesce(u.first_name, ''), coalesce(u.last_name, ''), coalesce(u.email, '')
+		FROM orders_status_history h
+			LEFT JOIN users u ON (h.user_id = u.id)
+		WHERE h.order_id = ?
+		ORDER BY h.created_at, h.id
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c OrderStatusChange
+		err = rows.Scan(
+			&c.ID,
+			&c.OrderID,
+			&c.FromStatusID,
+			&c.ToStatusID,
+			&c.UserID,
+			&c.Reason,
+			&c.CreatedAt,
+			&c.UpdatedAt,
+			&c.User.FirstName,
+			&c.User.LastName,
+			&c.User.Email,
+		)
+		if err != nil {
+			return nil, err
+		}
+		c.User.ID = c.UserID
+		changes = append(changes, &c)
+	}
+
+	return changes, rows.Err()
+}
+
+// CheckStatuses returns an error when a status known to the app is missing from the statuses
+// or transaction_statuses table
+func (m *DBModel) CheckStatuses() error {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	for table, names := range map[string]map[int]string{
+		"statuses":             orderStatusNames,
+		"transaction_statuses": transactionStatusNames,
+	} {
+		ids, err := selectIDs(ctx, m.DB, `SELECT id FROM `+table)
+		if err != nil {
+			return err
+		}
+		found := make(map[int]bool, len(ids))
+		for _, id := range ids {
+			found[id] = true
+		}
+		for id, name := range names {
+			if !found[id] {
+				return fmt.Errorf("%s is missing status %d (%s)", table, id, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// setOrderStatus locks the order, checks the transition and records it in the status history,
+// setting the status an order already has is a no-op when the transition is allowed
+func setOrderStatus(ctx context.Context, db querier, id, statusID, userID int, reason string) error {
+	var from int
+	err := db.QueryRowContext(ctx, `SELECT status_id FROM orders WHERE id = ? FOR UPDATE`, id).Scan(&from)
+	if err != nil {
+		return err
+	}
+
+	if !CanChangeOrderStatus(from, statusID) {
+		return fmt.Errorf("%w: order %d can not go from %s to %s",
+			ErrIllegalTransition, id, OrderStatusName(from), OrderStatusName(statusID))
+	}
+	if from == statusID {
+		return nil
+	}
+
+	_, err = db.ExecContext(ctx, `UPDATE orders SET status_id = ?, updated_at = ? WHERE id = ?`, statusID, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	stmt := `
+		INSERT INTO orders_status_history
+		(order_id, from_status_id, to_status_id, user_id, reason, created_at, updated_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err = db.ExecContext(ctx, stmt, id, from, statusID, userID, reason, time.Now(), time.Now())
+	return err
+}
+
+// setTransactionStatus locks the transaction and checks the transition before changing it
+func setTransactionStatus(ctx context.Context, db querier, id, statusID int) error {
+	var from int
+	err := db.QueryRowContext(ctx, `SELECT transaction_status_id FROM transactions WHERE id = ? FOR UPDATE`, id).Scan(&from)
+	if err != nil {
+		return err
+	}
+
+	if !CanChangeTransactionStatus(from, statusID) {
+		return fmt.Errorf("%w: transaction %d can not go from %s to %s",
+			ErrIllegalTransition, id, TransactionStatusName(from), TransactionStatusName(statusID))
+	}
+	if from == statusID {
+		return nil
+	}
+
+	_, err = db.ExecContext(ctx, `UPDATE transactions SET transaction_status_id = ?, updated_at = ? WHERE id = ?`,
+		statusID, time.Now(), id)
+	return err
+}
+
+func selectIDs(ctx context.Context, db querier, query string, args ...interface{}) ([]int, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
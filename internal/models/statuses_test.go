@@ -0,0 +1,57 @@
+package models
+
+import "testing"
+
+func TestCanChangeOrderStatus(t *testing.T) {
+	tests := []struct {
+		from, to int
+		want     bool
+	}{
+		{OrderCharged, OrderCharged, true},
+		{OrderCharged, OrderRefunded, true},
+		{OrderCharged, OrderCancelled, true},
+		{OrderCharged, OrderPartiallyRefunded, true},
+		{OrderPartiallyRefunded, OrderPartiallyRefunded, true},
+		{OrderPartiallyRefunded, OrderRefunded, true},
+		{OrderPartiallyRefunded, OrderCharged, false},
+		{OrderRefunded, OrderCharged, false},
+		{OrderRefunded, OrderPartiallyRefunded, false},
+		{OrderCancelled, OrderCharged, false},
+		{0, OrderCharged, false},
+	}
+
+	for _, tt := range tests {
+		if got := CanChangeOrderStatus(tt.from, tt.to); got != tt.want {
+			t.Errorf("%s to %s: got %t, want %t", OrderStatusName(tt.from), OrderStatusName(tt.to), got, tt.want)
+		}
+	}
+}
+
+func TestCanChangeTransactionStatus(t *testing.T) {
+	tests := []struct {
+		from, to int
+		want     bool
+	}{
+		{TransactionPending, TransactionCleared, true},
+		{TransactionPending, TransactionDeclined, true},
+		{TransactionPending, TransactionRefunded, false},
+		{TransactionCleared, TransactionRefunded, true},
+		{TransactionCleared, TransactionPartiallyRefunded, true},
+		{TransactionCleared, TransactionPending, false},
+		{TransactionDeclined, TransactionCleared, true},
+		{TransactionDeclined, TransactionRefunded, false},
+		{TransactionPartiallyRefunded, TransactionRefunded, true},
+		{TransactionPartiallyRefunded, TransactionCleared, false},
+		{TransactionRefunded, TransactionCleared, false},
+		{TransactionAuthorized, TransactionCleared, true},
+		{TransactionAuthorized, TransactionVoided, true},
+		{TransactionAuthorized, TransactionRefunded, false},
+		{TransactionVoided, TransactionCleared, false},
+	}
+
+	for _, tt := range tests {
+		if got := CanChangeTransactionStatus(tt.from, tt.to); got != tt.want {
+			t.Errorf("%s to %s: got %t, want %t", TransactionStatusName(tt.from), TransactionStatusName(tt.to), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// subscription statuses, they are the statuses used by the gateway
+const (
+	SubscriptionActive     = "active"
+	SubscriptionTrialing   = "trialing"
+	SubscriptionPastDue    = "past_due"
+	SubscriptionUnpaid     = "unpaid"
+	SubscriptionCanceled   = "canceled"
+	SubscriptionIncomplete = "incomplete"
+)
+
+// Subscription is the type for the lifecycle state of a subscription kept in sync with the gateway
+type Subscription struct {
+	ID                    int        `json:"id"`
+	OrderID               int        `json:"order_id"`
+	CustomerID            int        `json:"customer_id"`
+	WidgetID              int        `json:"widget_id"`
+	GatewaySubscriptionID string     `json:"gateway_subscription_id"`
+	GatewayPlanID         string     `json:"gateway_plan_id"`
+	Status                string     `json:"status"`
+	CurrentPeriodStart    time.Time  `json:"current_period_start"`
+	CurrentPeriodEnd      time.Time  `json:"current_period_end"`
+	CancelAtPeriodEnd     bool       `json:"cancel_at_period_end"`
+	Paused                bool       `json:"paused"`
+	TrialEnd              *time.Time `json:"trial_end"`
+	CanceledAt            *time.Time `json:"canceled_at"`
+	CreatedAt             time.Time  `json:"created_at"`
+	UpdatedAt             time.Time  `json:"updated_at"`
+}
+
+// insertSubscription saves the subscription of an order, or updates it when the gateway
+// subscription is already known. A trial end that moved gets a new reminder, the reminder is
+// reset before trial_end is updated as MySQL applies the assignments in order.
+func insertSubscription(ctx context.Context, db execer, s Subscription) error {
+	stmt := `
+		INSERT INTO subscriptions
+		(order_id, customer_id, widget_id, gateway_subscription_id, gateway_plan_id, status,
+			current_period_start, current_period_end, cancel_at_period_end, paused, trial_end,
+			canceled_at, created_at, updated_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			widget_id = VALUES(widget_id), gateway_plan_id = VALUES(gateway_plan_id),
+			status = VALUES(status), current_period_start = VALUES(current_period_start),
+			current_period_end = VALUES(current_period_end), cancel_at_period_end = VALUES(cancel_at_period_end),
+			paused = VALUES(paused),
+			trial_reminder_sent_at = IF(trial_end <=> VALUES(trial_end), trial_reminder_sent_at, NULL),
+			trial_end = VALUES(trial_end), canceled_at = VALUES(canceled_at),
+			updated_at = VALUES(updated_at)
+	`
+
+	_, err := db.ExecContext(ctx, stmt,
+		s.OrderID,
+		s.CustomerID,
+		s.WidgetID,
+		s.GatewaySubscriptionID,
+		s.GatewayPlanID,
+		s.Status,
+		s.CurrentPeriodStart,
+		s.CurrentPeriodEnd,
+		s.CancelAtPeriodEnd,
+		s.Paused,
+		s.TrialEnd,
+		s.CanceledAt,
+		time.Now(),
+		time.Now(),
+	)
+	return err
+}
+
+// SaveSubscription inserts or updates the local state of a gateway subscription
+func (m *DBModel) SaveSubscription(s Subscription) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	return insertSubscription(ctx, m.DB, s)
+}
+
+const subscriptionColumns = `
+	s.id, s.order_id, s.customer_id, s.widget_id, s.gateway_subscription_id, s.gateway_plan_id,
+	s.status, s.current_period_start, s.current_period_end, s.cancel_at_period_end, s.paused,
+	s.trial_end, s.canceled_at, s.created_at, s.updated_at
+`
+
+func scanSubscription(row rowScanner) (Subscription, error) {
+	var s Subscription
+	var trialEnd, canceledAt sql.NullTime
+	err := row.Scan(
+		&s.ID,
+		&s.OrderID,
+		&s.CustomerID,
+		&s.WidgetID,
+		&s.GatewaySubscriptionID,
+		&s.GatewayPlanID,
+		&s.Status,
+		&s.CurrentPeriodStart,
+		&s.CurrentPeriodEnd,
+		&s.CancelAtPeriodEnd,
+		&s.Paused,
+		&trialEnd,
+		&canceledAt,
+		&s.CreatedAt,
+		&s.UpdatedAt,
+	)
+	if trialEnd.Valid {
+		s.TrialEnd = &trialEnd.Time
+	}
+	if canceledAt.Valid {
+		s.CanceledAt = &canceledAt.Time
+	}
+	return s, err
+}
+
+// GetSubscriptionByOrderID returns the subscription of an order, nil for orders placed
+// before subscriptions were kept
+func (m *DBModel) GetSubscriptionByOrderID(orderID int) (*Subscription, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	row := m.DB.QueryRowContext(ctx, `SELECT `+subscriptionColumns+` FROM subscriptions s WHERE s.order_id = ?`, orderID)
+	s, err := scanSubscription(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// attachSubscriptions loads the subscriptions of the orders with one query
+func (m *DBModel) attachSubscriptions(orders []*Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	byID := make(map[int]*Order, len(orders))
+	placeholders := make([]string, 0, len(orders))
+	args := make([]interface{}, 0, len(orders))
+	for _, o := range orders {
+		byID[o.ID] = o
+		placeholders = append(placeholders, "?")
+		args = append(args, o.ID)
+	}
+
+	query := `SELECT ` + subscriptionColumns + ` FROM subscriptions s WHERE s.order_id IN (` + strings.Join(placeholders, ",") + `)`
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		s, err := scanSubscription(rows)
+		if err != nil {
+			return err
+		}
+		if o, ok := byID[s.OrderID]; ok {
+			o.Subscription = &s
+		}
+	}
+
+	return rows.Err()
+}
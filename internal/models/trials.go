@@ -0,0 +1,48 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// GetTrialsEndingBefore returns the trialing subscriptions whose trial ends before the given time
+// and that have not been reminded yet, trials cancelled at the end are left out as they never convert
+func (m *DBModel) GetTrialsEndingBefore(before time.Time) ([]*Subscription, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	query := `
+		SELECT ` + subscriptionColumns + `
+		FROM subscriptions s
+		WHERE s.status = ? AND s.trial_end IS NOT NULL AND s.trial_end > ? AND s.trial_end <= ?
+			AND s.cancel_at_period_end = 0 AND s.trial_reminder_sent_at IS NULL
+		ORDER BY s.trial_end, s.id
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, SubscriptionTrialing, time.Now(), before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscriptions []*Subscription
+	for rows.Next() {
+		s, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, &s)
+	}
+
+	return subscriptions, rows.Err()
+}
+
+// MarkTrialReminderSent records that the customer was told the trial of a subscription ends
+func (m *DBModel) MarkTrialReminderSent(id int) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	stmt := `UPDATE subscriptions SET trial_reminder_sent_at = ?, updated_at = ? WHERE id = ?`
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now(), time.Now(), id)
+	return err
+}
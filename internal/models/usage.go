@@ -0,0 +1,289 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// UsageRecord is the type for one usage event of a metered subscription, the event id is chosen
+// by the caller so an event sent twice is only counted once
+type UsageRecord struct {
+	ID                   int        `json:"id"`
+	OrderID              int        `json:"order_id"`
+	EventID              string     `json:"event_id"`
+	Quantity             int        `json:"quantity"`
+	OccurredAt           time.Time  `json:"occurred_at"`
+	PeriodStart          time.Time  `json:"period_start"`
+	PeriodEnd            time.Time  `json:"period_end"`
+	ReportedAt           *time.Time `json:"reported_at"`
+	GatewayUsageRecordID string     `json:"gateway_usage_record_id"`
+	CreatedAt            time.Time  `json:"created_at"`
+	UpdatedAt            time.Time  `json:"updated_at"`
+}
+
+// UsageSummary is the usage of a subscription in one billing period
+type UsageSummary struct {
+	OrderID     int            `json:"order_id"`
+	Unit        string         `json:"unit"`
+	PeriodStart time.Time      `json:"period_start"`
+	PeriodEnd   time.Time      `json:"period_end"`
+	Total       int            `json:"total"`
+	Reported    int            `json:"reported"`
+	Unreported  int            `json:"unreported"`
+	Records     []*UsageRecord `json:"records"`
+}
+
+// UsageBatch is the unreported usage of a subscription in one billing period, it is reported
+// to the gateway as one usage record. Key is set once the batch was sent, its records are then
+// kept together until they are marked reported.
+type UsageBatch struct {
+	Key                   string
+	OrderID               int
+	GatewaySubscriptionID string
+	PeriodStart           time.Time
+	PeriodEnd             time.Time
+	Quantity              int
+	LastOccurredAt        time.Time
+	RecordIDs             []int
+}
+
+// recentUsageRecords is how many records the usage summary lists
+const recentUsageRecords = 50
+
+const usageColumns = `
+	id, order_id, event_id, quantity, occurred_at, period_start, period_end, reported_at,
+	gateway_usage_record_id, created_at, updated_at
+`
+
+func scanUsageRecord(row rowScanner) (*UsageRecord, error) {
+	var u UsageRecord
+	var reportedAt sql.NullTime
+	err := row.Scan(
+		&u.ID,
+		&u.OrderID,
+		&u.EventID,
+		&u.Quantity,
+		&u.OccurredAt,
+		&u.PeriodStart,
+		&u.PeriodEnd,
+		&reportedAt,
+		&u.GatewayUsageRecordID,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if reportedAt.Valid {
+		u.ReportedAt = &reportedAt.Time
+	}
+	return &u, nil
+}
+
+// InsertUsageRecord stores a usage event and returns its id, false is returned when the
+// event id was already recorded for the subscription
+func (m *DBModel) InsertUsageRecord(u UsageRecord) (int, bool, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	stmt := `
+		INSERT IGNORE INTO usage_records
+		(order_id, event_id, quantity, occurred_at, period_start, period_end, gateway_usage_record_id,
+			created_at, updated_at)
+		VALUES(?, ?, ?, ?, ?, ?, '', ?, ?)
+	`
+
+	result, err := m.DB.ExecContext(ctx, stmt,
+		u.OrderID,
+		u.EventID,
+		u.Quantity,
+		u.OccurredAt,
+		u.PeriodStart,
+		u.PeriodEnd,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return 0, false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, false, err
+	}
+	if rows == 0 {
+		return 0, false, nil
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, false, err
+	}
+	return int(id), true, nil
+}
+
+// GetUsageRecordByEventID returns the usage event of a subscription by its event id
+func (m *DBModel) GetUsageRecordByEventID(orderID int, eventID string) (*UsageRecord, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	query := `SELECT ` + usageColumns + ` FROM usage_records WHERE order_id = ? AND event_id = ?`
+	return scanUsageRecord(m.DB.QueryRowContext(ctx, query, orderID, eventID))
+}
+
+// GetUnreportedUsage sums the usage not yet reported to the gateway by subscription and billing
+// period, usage of periods that ended before the given time is left out as the gateway no longer takes it
+func (m *DBModel) GetUnreportedUsage(now time.Time) ([]*UsageBatch, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancle()
+
+	query := `
+		SELECT u.id, u.order_id, t.payment_intent, u.quantity, u.occurred_at, u.period_start, u.period_end, u.batch_key
+		FROM usage_records u
+			INNER JOIN orders o ON (u.order_id = o.id)
+			INNER JOIN transactions t ON (o.transaction_id = t.id)
+		WHERE u.reported_at IS NULL AND u.period_end > ?
+		ORDER BY u.order_id, u.period_start, u.batch_key, u.id
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batches []*UsageBatch
+	var batch *UsageBatch
+	for rows.Next() {
+		var id, orderID, quantity int
+		var subID, key string
+		var occurredAt, periodStart, periodEnd time.Time
+		err := rows.Scan(&id, &orderID, &subID, &quantity, &occurredAt, &periodStart, &periodEnd, &key)
+		if err != nil {
+			return nil, err
+		}
+
+		if batch == nil || batch.OrderID != orderID || !batch.PeriodStart.Equal(periodStart) || batch.Key != key {
+			batch = &UsageBatch{
+				Key:                   key,
+				OrderID:               orderID,
+				GatewaySubscriptionID: subID,
+				PeriodStart:           periodStart,
+				PeriodEnd:             periodEnd,
+			}
+			batches = append(batches, batch)
+		}
+		batch.Quantity += quantity
+		batch.RecordIDs = append(batch.RecordIDs, id)
+		if occurredAt.After(batch.LastOccurredAt) {
+			batch.LastOccurredAt = occurredAt
+		}
+	}
+
+	return batches, rows.Err()
+}
+
+// StartUsageBatch gives a new batch its key before it is sent to the gateway, a batch sent before
+// keeps its key so the gateway takes a retry for the same usage record
+func (m *DBModel) StartUsageBatch(b *UsageBatch) error {
+	if b.Key != "" || len(b.RecordIDs) == 0 {
+		return nil
+	}
+
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	key := fmt.Sprintf("usage-%d-%d", b.OrderID, b.RecordIDs[0])
+	placeholders := make([]string, 0, len(b.RecordIDs))
+	args := []interface{}{key, time.Now()}
+	for _, id := range b.RecordIDs {
+		placeholders = append(placeholders, "?")
+		args = append(args, id)
+	}
+
+	stmt := fmt.Sprintf(`
+		UPDATE usage_records SET batch_key = ?, updated_at = ?
+		WHERE id IN (%s) AND batch_key = ''
+	`, strings.Join(placeholders, ","))
+
+	if _, err := m.DB.ExecContext(ctx, stmt, args...); err != nil {
+		return err
+	}
+	b.Key = key
+	return nil
+}
+
+// MarkUsageReported records that the usage records were reported to the gateway as one usage record
+func (m *DBModel) MarkUsageReported(ids []int, gatewayUsageRecordID string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	placeholders := make([]string, 0, len(ids))
+	args := []interface{}{time.Now(), gatewayUsageRecordID, time.Now()}
+	for _, id := range ids {
+		placeholders = append(placeholders, "?")
+		args = append(args, id)
+	}
+
+	stmt := fmt.Sprintf(`
+		UPDATE usage_records SET reported_at = ?, gateway_usage_record_id = ?, updated_at = ?
+		WHERE id IN (%s)
+	`, strings.Join(placeholders, ","))
+
+	_, err := m.DB.ExecContext(ctx, stmt, args...)
+	return err
+}
+
+// GetUsageSummary returns the usage of a subscription in the billing period that starts at
+// periodStart, with the most recent records
+func (m *DBModel) GetUsageSummary(orderID int, periodStart, periodEnd time.Time) (*UsageSummary, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	summary := &UsageSummary{
+		OrderID:     orderID,
+		PeriodStart: periodStart,
+		PeriodEnd:   periodEnd,
+		Records:     []*UsageRecord{},
+	}
+
+	query := `
+		SELECT coalesce(sum(quantity), 0), coalesce(sum(IF(reported_at IS NULL, 0, quantity)), 0)
+		FROM usage_records
+		WHERE order_id = ? AND period_start = ?
+	`
+	err := m.DB.QueryRowContext(ctx, query, orderID, periodStart).Scan(&summary.Total, &summary.Reported)
+	if err != nil {
+		return nil, err
+	}
+	summary.Unreported = summary.Total - summary.Reported
+
+	rows, err := m.DB.QueryContext(ctx, `
+		SELECT `+usageColumns+`
+		FROM usage_records
+		WHERE order_id = ? AND period_start = ?
+		ORDER BY occurred_at DESC, id DESC
+		LIMIT ?
+	`, orderID, periodStart, recentUsageRecords)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		u, err := scanUsageRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		summary.Records = append(summary.Records, u)
+	}
+
+	return summary, rows.Err()
+}
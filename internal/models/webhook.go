@@ -0,0 +1,51 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// WebhookEvent is the type for processed gateway webhook events
+type WebhookEvent struct {
+	ID        int       `json:"id"`
+	EventID   string    `json:"event_id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// InsertWebhookEvent records a webhook event id, returns false when the event was already recorded
+func (m *DBModel) InsertWebhookEvent(eventID, eventType string) (bool, error) {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	stmt := `
+		INSERT IGNORE INTO webhook_events
+		(event_id, type, created_at, updated_at)
+		VALUES(?, ?, ?, ?)
+	`
+
+	result, err := m.DB.ExecContext(ctx, stmt, eventID, eventType, time.Now(), time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+// DeleteWebhookEvent forgets a webhook event so the gateway can deliver it again
+func (m *DBModel) DeleteWebhookEvent(eventID string) error {
+	ctx, cancle := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancle()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM webhook_events WHERE event_id = ?`, eventID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
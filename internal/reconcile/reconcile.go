@@ -0,0 +1,141 @@
+package reconcile
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// kinds of records that are reconciled
+const (
+	KindCharge       = "charge"
+	KindRefund       = "refund"
+	KindSubscription = "subscription"
+)
+
+// issue types of a report
+const (
+	IssueMissing        = "missing"
+	IssueExtra          = "extra"
+	IssueAmountMismatch = "amount_mismatch"
+)
+
+// Record is one payment as either we or the gateway have it, reference is the payment intent,
+// refund or subscription id and alt reference the charge id kept as bank return code
+type Record struct {
+	Kind         string
+	Reference    string
+	AltReference string
+	Amount       int
+	Currency     string
+}
+
+// Issue is a record that does not match, missing records are settled by the gateway but not
+// in our tables, extra records are in our tables but not at the gateway
+type Issue struct {
+	Type            string `json:"type"`
+	Kind            string `json:"kind"`
+	Reference       string `json:"reference"`
+	LocalAmount     int    `json:"local_amount"`
+	GatewayAmount   int    `json:"gateway_amount"`
+	LocalCurrency   string `json:"local_currency"`
+	GatewayCurrency string `json:"gateway_currency"`
+}
+
+// Compare matches the gateway records against ours by kind and reference and returns the
+// number of matched records and the issues found
+func Compare(local, gateway []Record) (int, []Issue) {
+	byReference := make(map[string]int, len(local)*2)
+	for i, r := range local {
+		if r.Reference != "" {
+			byReference[r.Kind+":"+r.Reference] = i
+		}
+		if r.AltReference != "" {
+			byReference[r.Kind+":"+r.AltReference] = i
+		}
+	}
+
+	used := make([]bool, len(local))
+	matched := 0
+	issues := []Issue{}
+
+	for _, g := range gateway {
+		i, ok := byReference[g.Kind+":"+g.Reference]
+		if !ok && g.AltReference != "" {
+			i, ok = byReference[g.Kind+":"+g.AltReference]
+		}
+		if !ok || used[i] {
+			issues = append(issues, Issue{
+				Type:            IssueMissing,
+				Kind:            g.Kind,
+				Reference:       g.Reference,
+				GatewayAmount:   g.Amount,
+				GatewayCurrency: g.Currency,
+			})
+			continue
+		}
+
+		used[i] = true
+		l := local[i]
+		if l.Amount != g.Amount || !strings.EqualFold(l.Currency, g.Currency) {
+			issues = append(issues, Issue{
+				Type:            IssueAmountMismatch,
+				Kind:            g.Kind,
+				Reference:       g.Reference,
+				LocalAmount:     l.Amount,
+				GatewayAmount:   g.Amount,
+				LocalCurrency:   l.Currency,
+				GatewayCurrency: g.Currency,
+			})
+			continue
+		}
+		matched++
+	}
+
+	for i, l := range local {
+		if used[i] {
+			continue
+		}
+		reference := l.Reference
+		if reference == "" {
+			reference = l.AltReference
+		}
+		issues = append(issues, Issue{
+			Type:          IssueExtra,
+			Kind:          l.Kind,
+			Reference:     reference,
+			LocalAmount:   l.Amount,
+			LocalCurrency: l.Currency,
+		})
+	}
+
+	return matched, issues
+}
+
+// WriteCSV writes the issues with a header line
+func WriteCSV(w io.Writer, issues []Issue) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"type", "kind", "reference", "local_amount", "gateway_amount", "local_currency", "gateway_currency"})
+	if err != nil {
+		return err
+	}
+
+	for _, i := range issues {
+		err := cw.Write([]string{
+			i.Type,
+			i.Kind,
+			i.Reference,
+			strconv.Itoa(i.LocalAmount),
+			strconv.Itoa(i.GatewayAmount),
+			i.LocalCurrency,
+			i.GatewayCurrency,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,87 @@
+package reconcile
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name    string
+		local   []Record
+		gateway []Record
+		matched int
+		issues  []Issue
+	}{
+		{
+			name:    "matched by reference",
+			local:   []Record{{Kind: KindCharge, Reference: "pi_1", Amount: 1000, Currency: "usd"}},
+			gateway: []Record{{Kind: KindCharge, Reference: "pi_1", Amount: 1000, Currency: "USD"}},
+			matched: 1,
+			issues:  []Issue{},
+		},
+		{
+			name:    "matched by alt reference",
+			local:   []Record{{Kind: KindCharge, AltReference: "ch_1", Amount: 1000, Currency: "usd"}},
+			gateway: []Record{{Kind: KindCharge, Reference: "pi_1", AltReference: "ch_1", Amount: 1000, Currency: "usd"}},
+			matched: 1,
+			issues:  []Issue{},
+		},
+		{
+			name:    "kinds are not mixed",
+			local:   []Record{{Kind: KindRefund, Reference: "re_1", Amount: 1000, Currency: "usd"}},
+			gateway: []Record{{Kind: KindCharge, Reference: "re_1", Amount: 1000, Currency: "usd"}},
+			issues: []Issue{
+				{Type: IssueMissing, Kind: KindCharge, Reference: "re_1", GatewayAmount: 1000, GatewayCurrency: "usd"},
+				{Type: IssueExtra, Kind: KindRefund, Reference: "re_1", LocalAmount: 1000, LocalCurrency: "usd"},
+			},
+		},
+		{
+			name:    "amount mismatch",
+			local:   []Record{{Kind: KindCharge, Reference: "pi_1", Amount: 1000, Currency: "usd"}},
+			gateway: []Record{{Kind: KindCharge, Reference: "pi_1", Amount: 900, Currency: "usd"}},
+			issues: []Issue{
+				{Type: IssueAmountMismatch, Kind: KindCharge, Reference: "pi_1", LocalAmount: 1000, GatewayAmount: 900, LocalCurrency: "usd", GatewayCurrency: "usd"},
+			},
+		},
+		{
+			name:    "currency mismatch",
+			local:   []Record{{Kind: KindCharge, Reference: "pi_1", Amount: 1000, Currency: "usd"}},
+			gateway: []Record{{Kind: KindCharge, Reference: "pi_1", Amount: 1000, Currency: "eur"}},
+			issues: []Issue{
+				{Type: IssueAmountMismatch, Kind: KindCharge, Reference: "pi_1", LocalAmount: 1000, GatewayAmount: 1000, LocalCurrency: "usd", GatewayCurrency: "eur"},
+			},
+		},
+		{
+			name:  "one local record for two gateway records",
+			local: []Record{{Kind: KindCharge, Reference: "pi_1", AltReference: "ch_1", Amount: 1000, Currency: "usd"}},
+			gateway: []Record{
+				{Kind: KindCharge, Reference: "pi_1", Amount: 1000, Currency: "usd"},
+				{Kind: KindCharge, Reference: "pi_2", AltReference: "ch_1", Amount: 1000, Currency: "usd"},
+			},
+			matched: 1,
+			issues: []Issue{
+				{Type: IssueMissing, Kind: KindCharge, Reference: "pi_2", GatewayAmount: 1000, GatewayCurrency: "usd"},
+			},
+		},
+		{
+			name:  "extra reported by alt reference",
+			local: []Record{{Kind: KindSubscription, AltReference: "ch_1", Amount: 500, Currency: "usd"}},
+			issues: []Issue{
+				{Type: IssueExtra, Kind: KindSubscription, Reference: "ch_1", LocalAmount: 500, LocalCurrency: "usd"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matched, issues := Compare(tt.local, tt.gateway)
+			if matched != tt.matched {
+				t.Errorf("got %d matched, want %d", matched, tt.matched)
+			}
+			if !reflect.DeepEqual(issues, tt.issues) {
+				t.Errorf("got issues %+v, want %+v", issues, tt.issues)
+			}
+		})
+	}
+}